@@ -0,0 +1,475 @@
+package rethinkgo
+
+// Helpers for setting up databases, tables and indexes.
+
+import (
+	"encoding/json"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnsureIndexes creates any of the secondary indexes in `specs` that don't
+// exist yet on the table, then waits for the new ones to be ready.  Indexes
+// that already exist are left alone, so this can be called every time an
+// application starts.  If any of them were created with a different
+// Function, Multi or Geo option than their spec, an IndexDriftError is
+// returned once the indexes are ready.  Servers before 1.12 cannot wait for
+// indexes or report their options, so with those the indexes are only
+// created.
+//
+// Functions are compared as text, the function in the spec is written out
+// like .String() does and compared with the query the server reports the
+// index was created with, ignoring the names of variables.  Servers before
+// 2.1 do not report the query, so with those only Multi and Geo are checked.
+// Drop and recreate an index to change its function.
+//
+// Example usage:
+//
+//  err := r.EnsureIndexes(session, "heroes", []r.IndexSpec{
+//      {Name: "name"},
+//      {Name: "powers", Multi: true},
+//      {Name: "awesomeness", Function: func(hero r.Exp) r.Exp {
+//          return hero.Attr("speed").Mul(hero.Attr("strength"))
+//      }},
+//  })
+func EnsureIndexes(session *Session, table string, specs []IndexSpec) error {
+	var existing []string
+	if err := Table(table).IndexList().Run(session).One(&existing); err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	var names, created []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+		if exists[spec.Name] {
+			continue
+		}
+		err := Table(table).IndexCreateWithSpec(spec).Run(session).Exec()
+		if err != nil && !isAlreadyExists(err) {
+			return err
+		}
+		// an index that another client just created may not be ready either
+		created = append(created, spec.Name)
+	}
+
+	if len(names) == 0 || !session.version.supports(p.Term_INDEX_WAIT) {
+		return nil
+	}
+	if len(created) > 0 {
+		if err := Table(table).IndexWait(created...).Run(session).Exec(); err != nil {
+			return err
+		}
+	}
+	var statuses []indexStatus
+	if err := Table(table).IndexStatus(names...).Run(session).All(&statuses); err != nil {
+		return err
+	}
+	return checkIndexDrift(table, specs, statuses)
+}
+
+// indexStatus is the part of the server's index status used to check that an
+// index matches its spec.  Older servers do not return the options.
+type indexStatus struct {
+	Index string `json:"index"`
+	Multi *bool  `json:"multi"`
+	Geo   *bool  `json:"geo"`
+	// the .indexCreate() the index was made with, in JavaScript
+	Query *string `json:"query"`
+}
+
+// checkIndexDrift compares the functions and options of the indexes on the
+// server with the specs they should have been created from.
+func checkIndexDrift(table string, specs []IndexSpec, statuses []indexStatus) error {
+	byName := map[string]indexStatus{}
+	for _, status := range statuses {
+		byName[status.Index] = status
+	}
+
+	differences := map[string]string{}
+	for _, spec := range specs {
+		status := byName[spec.Name]
+		var found []string
+		if status.Multi != nil && *status.Multi != spec.Multi {
+			found = append(found, fmt.Sprintf("multi is %v on the server", *status.Multi))
+		}
+		if status.Geo != nil && *status.Geo != spec.Geo {
+			found = append(found, fmt.Sprintf("geo is %v on the server", *status.Geo))
+		}
+		if status.Query != nil {
+			text, err := indexFunctionText(spec)
+			if err != nil {
+				return err
+			}
+			// the query has the name and options around the function
+			if !strings.Contains(normalizeFunctionText(*status.Query), normalizeFunctionText(text)) {
+				found = append(found, fmt.Sprintf("function is %v on the server", *status.Query))
+			}
+		}
+		if len(found) > 0 {
+			differences[spec.Name] = strings.Join(found, ", ")
+		}
+	}
+	if len(differences) > 0 {
+		return IndexDriftError{Table: table, Differences: differences}
+	}
+	return nil
+}
+
+// indexFunctionText writes out the function of an index spec as ReQL text.
+// Functions made from r.Row are written with a variable instead, the way the
+// server reports them.
+func indexFunctionText(spec IndexSpec) (string, error) {
+	function := spec.Function
+	if function == nil {
+		function = func(row Exp) Exp { return row.Attr(spec.Name) }
+	}
+	queryProto, err := context{}.buildProtobuf(funcWrapper(function, 1))
+	if err != nil {
+		return "", err
+	}
+	term := queryProto.GetQuery()
+	if term.GetType() == p.Term_FUNC && len(term.Args) == 2 && len(term.Args[0].Args) == 1 && containsImplicitVariable(term.Args[1]) {
+		variable := &p.Term{Type: p.Term_VAR.Enum(), Args: term.Args[0].Args}
+		term = &p.Term{
+			Type: term.Type,
+			Args: []*p.Term{term.Args[0], replaceImplicitVariable(term.Args[1], variable)},
+		}
+	}
+	return queryText(term), nil
+}
+
+// replaceImplicitVariable returns a copy of a term with r.row replaced by
+// `variable`.
+func replaceImplicitVariable(term *p.Term, variable *p.Term) *p.Term {
+	if term.GetType() == p.Term_IMPLICIT_VAR {
+		return variable
+	}
+	result := &p.Term{Type: term.Type, Datum: term.Datum}
+	for _, arg := range term.Args {
+		result.Args = append(result.Args, replaceImplicitVariable(arg, variable))
+	}
+	for _, optarg := range term.Optargs {
+		result.Optargs = append(result.Optargs, &p.Term_AssocPair{
+			Key: optarg.Key,
+			Val: replaceImplicitVariable(optarg.Val, variable),
+		})
+	}
+	return result
+}
+
+var (
+	// variables are written as var1 by the driver and _var1 or var_1 by
+	// some servers
+	functionVariablePattern = regexp.MustCompile(`\b_?var_?[0-9]+\b`)
+	// JavaScript's row("name") is row.getField("name")
+	bracketFieldPattern = regexp.MustCompile(`(var[0-9]+|\))\(("(?:[^"\\]|\\.)*")\)`)
+)
+
+// normalizeFunctionText rewrites a function written out by the driver or
+// the server so that the two can be compared: variables are numbered in the
+// order they appear, whitespace and semicolons are removed, and the
+// JavaScript spellings are replaced with the driver's.
+func normalizeFunctionText(text string) string {
+	text = strings.Replace(text, "'", `"`, -1)
+	text = strings.Replace(text, "function", "func", -1)
+	names := map[string]string{}
+	text = functionVariablePattern.ReplaceAllStringFunc(text, func(name string) string {
+		if _, ok := names[name]; !ok {
+			names[name] = fmt.Sprintf("var%v", len(names)+1)
+		}
+		return names[name]
+	})
+	text = strings.Join(strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ';'
+	}), "")
+	for {
+		replaced := bracketFieldPattern.ReplaceAllString(text, "$1.getField($2)")
+		if replaced == text {
+			return text
+		}
+		text = replaced
+	}
+}
+
+// Keys returns the keys a row has in the index, computed in Go with KeyFunc,
+// or from the field named Name if KeyFunc is nil.  The row is converted to
+// JSON first, so it can be a struct as well as a map.  A row with no key,
+// because the field is missing or KeyFunc returns nil, is left out of the
+// index, and has no keys.  For Multi indexes, each element of an array is a
+// separate key.  This is useful in tests, to check that KeyFunc, and so
+// Function, gives the expected keys.
+//
+// Example usage:
+//
+//  spec := r.IndexSpec{
+//      Name:  "powers",
+//      Multi: true,
+//      KeyFunc: func(row map[string]interface{}) interface{} {
+//          return row["powers"]
+//      },
+//  }
+//  keys, err := spec.Keys(Hero{Name: "Storm", Powers: []string{"weather", "flight"}})
+//  // keys is []interface{}{"weather", "flight"}
+func (spec IndexSpec) Keys(row interface{}) ([]interface{}, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, newDecodeError(&fields, data, err)
+	}
+
+	var key interface{}
+	if spec.KeyFunc != nil {
+		key = spec.KeyFunc(fields)
+	} else {
+		key = fields[spec.Name]
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	value := reflect.ValueOf(key)
+	if !spec.Multi || (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) {
+		return []interface{}{key}, nil
+	}
+	keys := []interface{}{}
+	for i := 0; i < value.Len(); i++ {
+		keys = append(keys, value.Index(i).Interface())
+	}
+	return keys, nil
+}
+
+// isAlreadyExists is true for errors from the server saying that something we
+// tried to create has been created already, most likely by another client.
+func isAlreadyExists(err error) bool {
+	_, ok := err.(ErrRuntime)
+	return ok && strings.Contains(err.Error(), "already exists")
+}
+
+// EnsureDatabase creates a database if it does not exist yet.
+//
+// Example usage:
+//
+//  err := r.EnsureDatabase(session, "marvel")
+func EnsureDatabase(session *Session, name string) error {
+	var databases []string
+	if err := DbList().Run(session).One(&databases); err != nil {
+		return err
+	}
+	for _, database := range databases {
+		if database == name {
+			return nil
+		}
+	}
+
+	err := DbCreate(name).Run(session).Exec()
+	if err != nil && !isAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// EnsureTable creates a table in the session's database if it does not exist
+// yet.  If the table exists, it is left alone even if it was created with
+// different parameters.
+//
+// Example usage:
+//
+//  err := r.EnsureTable(session, r.TableSpec{Name: "heroes", PrimaryKey: "name"})
+func EnsureTable(session *Session, spec TableSpec) error {
+	var tables []string
+	if err := TableList().Run(session).One(&tables); err != nil {
+		return err
+	}
+	name := spec.Name
+	if session.tableResolver != nil {
+		name = session.tableResolver(name)
+	}
+	for _, table := range tables {
+		if table == name {
+			return nil
+		}
+	}
+
+	err := TableCreateWithSpec(spec).Run(session).Exec()
+	if err != nil && !isAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// TableInfo runs .Info() on a table and returns the result as a TableInfo.
+//
+// Example usage:
+//
+//  info, err := r.Table("heroes").TableInfo(session)
+//  fmt.Println("primary key:", info.PrimaryKey)
+func (e Exp) TableInfo(session *Session) (TableInfo, error) {
+	var info TableInfo
+	err := e.Info().Run(session).One(&info)
+	return info, err
+}
+
+// WaitReady waits until a table can be read from, which may take a moment after
+// it has been created.  The table is polled until it responds or the timeout
+// expires, in which case the last error from the server is returned.
+//
+// Example usage:
+//
+//  err := r.TableCreate("heroes").Run(session).Exec()
+//  err = r.Table("heroes").WaitReady(session, 10*time.Second)
+func (e Exp) WaitReady(session *Session, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := 10 * time.Millisecond
+	for {
+		err := e.Limit(1).Count().Run(session).Exec()
+		if _, ok := err.(ErrRuntime); !ok {
+			// either the table is ready or something is wrong with the query
+			return err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		time.Sleep(delay)
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// Truncate deletes every row of a table, `batchSize` rows at a time, instead of
+// in a single .Delete() that may time out on a large table.  Each batch is a
+// range of primary keys, found by ordering the primary key index, which
+// requires server >= 1.12.  If `progress` is not nil, it is called after each
+// batch with the number of rows deleted so far.  The total number of rows
+// deleted is returned.
+//
+// Example usage:
+//
+//  deleted, err := r.Table("battles").Truncate(session, 10000, func(deleted int) {
+//      log.Println("deleted", deleted, "battles")
+//  })
+func (e Exp) Truncate(session *Session, batchSize int, progress func(deleted int)) (int, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("rethinkdb: Truncate batch size must be positive, got %v", batchSize)
+	}
+	info, err := e.TableInfo(session)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		// the last key of the next batch, since the earlier batches are gone
+		// the batch starts at the beginning of the table
+		var keys []interface{}
+		query := e.UseIndex(info.PrimaryKey).OrderBy().Skip(batchSize - 1).Limit(1).Map(Row.Attr(info.PrimaryKey))
+		if err := query.RunWith(session, RunOpts{TimeFormat: "raw"}).All(&keys); err != nil {
+			return total, err
+		}
+
+		batch := e.Between("", nil, nil)
+		if len(keys) > 0 {
+			batch = e.Between("", nil, keys[0]).RightBound("closed")
+		}
+		var response WriteResponse
+		if err := batch.Delete().Run(session).One(&response); err != nil {
+			return total, err
+		}
+		total += response.Deleted
+		if progress != nil {
+			progress(total)
+		}
+		if len(keys) == 0 {
+			return total, nil
+		}
+	}
+}
+
+// EstimatedCount returns the approximate number of rows in a table, from the
+// per-shard estimates that newer servers include in the table's .Info().
+// Servers that do not provide estimates fall back to an exact .Count(), which
+// reads the whole table.
+//
+// Example usage:
+//
+//  count, err := r.Table("battles").EstimatedCount(session)
+func (e Exp) EstimatedCount(session *Session) (int, error) {
+	info, err := e.TableInfo(session)
+	if err != nil {
+		return 0, err
+	}
+	if len(info.DocCountEstimates) > 0 {
+		count := 0
+		for _, estimate := range info.DocCountEstimates {
+			count += estimate
+		}
+		return count, nil
+	}
+
+	var count int
+	err = e.Count().Run(session).One(&count)
+	return count, err
+}
+
+// DeleteOpts holds the options for .DeleteAll().
+type DeleteOpts struct {
+	// Secondary index the keys are looked up in, defaults to the primary key.
+	Index string
+	// Number of keys deleted with each query, defaults to the .GetAll() chunk
+	// size, see SetGetAllChunkSize().  If that is zero, all of the keys are
+	// deleted with a single query.
+	BatchSize int
+	// Durability of the deletes, either "hard" or "soft".
+	Durability string
+}
+
+// DeleteAll deletes the rows of a table with the given keys, using
+// .GetAll().Delete() on `opts.BatchSize` keys at a time.  The responses of
+// the deletes are added together.  If a delete fails, the response so far is
+// returned along with the error.
+//
+// Example usage:
+//
+//  response, err := r.Table("heroes").DeleteAll(session, []interface{}{"Storm", "Rogue"}, r.DeleteOpts{Index: "name"})
+//  fmt.Println("deleted", response.Deleted, "heroes")
+func (e Exp) DeleteAll(session *Session, keys []interface{}, opts DeleteOpts) (WriteResponse, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = getAllChunkSize
+	}
+	if batchSize <= 0 {
+		batchSize = len(keys)
+	}
+
+	var total WriteResponse
+	for len(keys) > 0 {
+		n := batchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		query := e.GetAll(opts.Index, keys[:n]...).Delete()
+		if opts.Durability != "" {
+			query = query.Durability(opts.Durability)
+		}
+		var response WriteResponse
+		if err := query.Run(session).One(&response); err != nil {
+			return total, err
+		}
+		total.add(response)
+		keys = keys[n:]
+	}
+	return total, nil
+}
@@ -0,0 +1,239 @@
+// Package analyzer reports common misuse of the rethinkgo driver that can be
+// found without running the code, for use with "go vet" style tools.
+//
+// It checks for:
+//
+//  .Filter() given a string, which the server treats as a value rather than
+//  a predicate, so every row matches or none do
+//
+//  the *Rows returned by .Run() being thrown away, which hides any error from
+//  the query
+//
+//  rows that are read with .Next() but may be left before the last row, by a
+//  break or return in the loop or by reading a single row, and are never
+//  closed, which leaves the query running on the server
+//
+// Example usage:
+//
+//  go run github.com/christopherhesse/rethinkgo/analyzer/cmd/rethinkvet ./...
+package analyzer
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const driverPath = "github.com/christopherhesse/rethinkgo"
+
+// Analyzer reports misuse of the rethinkgo driver.
+var Analyzer = &analysis.Analyzer{
+	Name:     "rethinkgo",
+	Doc:      "report misuse of the rethinkgo driver, such as .Filter() on a string, ignoring the result of .Run() or not closing rows that are partly read",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	nodes := []ast.Node{(*ast.CallExpr)(nil), (*ast.ExprStmt)(nil), (*ast.FuncDecl)(nil)}
+	inspect.Preorder(nodes, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			checkFilter(pass, n)
+		case *ast.ExprStmt:
+			checkUnusedRun(pass, n)
+		case *ast.FuncDecl:
+			if n.Body != nil {
+				checkUnclosedRows(pass, n.Body)
+			}
+		}
+	})
+	return nil, nil
+}
+
+// driverMethod returns the name of the driver method a call is to, if any.
+func driverMethod(pass *analysis.Pass, call *ast.CallExpr) string {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	fn, ok := pass.TypesInfo.Uses[selector.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != driverPath {
+		return ""
+	}
+	if fn.Type().(*types.Signature).Recv() == nil {
+		return ""
+	}
+	return fn.Name()
+}
+
+func checkFilter(pass *analysis.Pass, call *ast.CallExpr) {
+	if driverMethod(pass, call) != "Filter" || len(call.Args) != 1 {
+		return
+	}
+	t := pass.TypesInfo.TypeOf(call.Args[0])
+	if t == nil {
+		return
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok && basic.Info()&types.IsString != 0 {
+		pass.Reportf(call.Args[0].Pos(), ".Filter() needs a predicate, such as r.Map{...}, a function or r.Row.Attr(...), not a string")
+	}
+}
+
+func checkUnusedRun(pass *analysis.Pass, stmt *ast.ExprStmt) {
+	call, ok := stmt.X.(*ast.CallExpr)
+	if !ok {
+		return
+	}
+	if isRun(pass, call) {
+		pass.Reportf(call.Pos(), "result of .%v() is not used, so any error is ignored; use .Exec() to check it", driverMethod(pass, call))
+	}
+}
+
+// isRun is true for calls that start a query and return its *Rows.
+func isRun(pass *analysis.Pass, call *ast.CallExpr) bool {
+	switch driverMethod(pass, call) {
+	case "Run", "RunWith", "RunWithDb":
+		return true
+	}
+	return false
+}
+
+// rowsUse is what a function does with a *Rows variable it got from .Run().
+type rowsUse struct {
+	run     *ast.CallExpr
+	closed  bool
+	escapes bool
+	partial bool
+}
+
+// checkUnclosedRows reports rows in a function body that may be left part way
+// through and are never closed.  Rows that are passed to another function,
+// returned or stored are left alone, since they may be closed there.
+func checkUnclosedRows(pass *analysis.Pass, body *ast.BlockStmt) {
+	uses := map[types.Object]*rowsUse{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != len(assign.Rhs) {
+			return true
+		}
+		for i, rhs := range assign.Rhs {
+			call, ok := rhs.(*ast.CallExpr)
+			ident, isIdent := assign.Lhs[i].(*ast.Ident)
+			if ok && isIdent && isRun(pass, call) {
+				if obj := pass.TypesInfo.ObjectOf(ident); obj != nil && uses[obj] == nil {
+					uses[obj] = &rowsUse{run: call}
+				}
+			}
+		}
+		return true
+	})
+	if len(uses) == 0 {
+		return
+	}
+
+	var stack []ast.Node
+	ast.Inspect(body, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		ident, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		use := uses[pass.TypesInfo.Uses[ident]]
+		if use == nil {
+			return true
+		}
+		parent := stack[len(stack)-2]
+		switch parent := parent.(type) {
+		case *ast.SelectorExpr:
+			call, ok := stack[len(stack)-3].(*ast.CallExpr)
+			if !ok || call.Fun != parent {
+				use.escapes = true
+				break
+			}
+			switch parent.Sel.Name {
+			case "Close":
+				use.closed = true
+			case "Next":
+				if !readsToEnd(call, stack[:len(stack)-3]) {
+					use.partial = true
+				}
+			}
+		case *ast.AssignStmt:
+			// assigning to the variable is fine, anything else may store it
+			for _, lhs := range parent.Lhs {
+				if lhs == ident {
+					return true
+				}
+			}
+			use.escapes = true
+		default:
+			use.escapes = true
+		}
+		return true
+	})
+
+	for _, use := range uses {
+		if use.partial && !use.closed && !use.escapes {
+			pass.Reportf(use.run.Pos(), "rows may be left before the last row but are never closed, so the query keeps running on the server; use defer rows.Close()")
+		}
+	}
+}
+
+// readsToEnd is true if a call to .Next() is the condition of a for loop that
+// can only end once there are no more rows, where `stack` holds the nodes
+// enclosing the call.
+func readsToEnd(next *ast.CallExpr, stack []ast.Node) bool {
+	if len(stack) == 0 {
+		return false
+	}
+	loop, ok := stack[len(stack)-1].(*ast.ForStmt)
+	if !ok || loop.Cond != next {
+		return false
+	}
+	return !leavesLoop(loop.Body)
+}
+
+// leavesLoop is true if a loop body has a return, goto or break that can end
+// the loop early.
+func leavesLoop(body *ast.BlockStmt) bool {
+	leaves := false
+	var visit func(n ast.Node, nested bool) bool
+	visit = func(n ast.Node, nested bool) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			leaves = true
+		case *ast.BranchStmt:
+			switch {
+			case n.Tok == token.GOTO, n.Tok == token.BREAK && n.Label != nil:
+				leaves = true
+			case n.Tok == token.BREAK && !nested:
+				leaves = true
+			}
+		case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+			// an unlabeled break in here ends the inner statement instead
+			ast.Inspect(n, func(child ast.Node) bool {
+				if child == n {
+					return true
+				}
+				return child != nil && visit(child, true)
+			})
+			return false
+		}
+		return !leaves
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		return n != nil && visit(n, false)
+	})
+	return leaves
+}
@@ -0,0 +1,19 @@
+package analyzer
+
+import (
+	"golang.org/x/tools/go/analysis/analysistest"
+	test "launchpad.net/gocheck"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { test.TestingT(t) }
+
+type AnalyzerSuite struct{}
+
+var _ = test.Suite(&AnalyzerSuite{})
+
+// The expected diagnostics are the "// want" comments in testdata/src.
+func (s *AnalyzerSuite) TestAnalyzer(c *test.C) {
+	analysistest.Run(c, analysistest.TestData(), Analyzer, "queries")
+}
@@ -0,0 +1,16 @@
+// Command rethinkvet reports misuse of the rethinkgo driver, see the analyzer
+// package for the checks it runs.
+//
+// Example usage:
+//
+//  rethinkvet ./...
+package main
+
+import (
+	"github.com/christopherhesse/rethinkgo/analyzer"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
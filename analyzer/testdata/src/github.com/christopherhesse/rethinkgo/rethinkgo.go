@@ -0,0 +1,30 @@
+// Package rethinkgo is a stand-in for the driver with just the parts that the
+// analyzer looks at.
+package rethinkgo
+
+type Session struct{}
+
+type RunOpts struct{}
+
+type Map map[string]interface{}
+
+type Exp struct{}
+
+func Table(name string) Exp { return Exp{} }
+
+func (e Exp) Filter(predicate interface{}) Exp                  { return e }
+func (e Exp) Run(session *Session) *Rows                        { return &Rows{} }
+func (e Exp) RunWith(session *Session, opts RunOpts) *Rows      { return &Rows{} }
+func (e Exp) RunWithDb(session *Session, database string) *Rows { return &Rows{} }
+func (s *Session) Run(query Exp) *Rows                          { return &Rows{} }
+func (s *Session) RunWith(query Exp, opts RunOpts) *Rows        { return &Rows{} }
+
+type Rows struct{}
+
+func (rows *Rows) Next() bool                  { return false }
+func (rows *Rows) Scan(dest interface{}) error { return nil }
+func (rows *Rows) Err() error                  { return nil }
+func (rows *Rows) Close() error                { return nil }
+func (rows *Rows) Exec() error                 { return nil }
+func (rows *Rows) One(dest interface{}) error  { return nil }
+func (rows *Rows) All(dest interface{}) error  { return nil }
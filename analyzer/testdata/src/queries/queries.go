@@ -0,0 +1,86 @@
+package queries
+
+import (
+	r "github.com/christopherhesse/rethinkgo"
+)
+
+var session *r.Session
+
+func filters(name string) {
+	r.Table("heroes").Filter(name)    // want `\.Filter\(\) needs a predicate`
+	r.Table("heroes").Filter("Storm") // want `\.Filter\(\) needs a predicate`
+	r.Table("heroes").Filter(r.Map{"name": name})
+	r.Table("heroes").Filter(func(row r.Exp) r.Exp { return row })
+}
+
+func unusedRuns(query r.Exp) error {
+	query.Run(session)                  // want `result of \.Run\(\) is not used`
+	query.RunWith(session, r.RunOpts{}) // want `result of \.RunWith\(\) is not used`
+	query.RunWithDb(session, "test")    // want `result of \.RunWithDb\(\) is not used`
+	session.Run(query)                  // want `result of \.Run\(\) is not used`
+	return query.Run(session).Exec()
+}
+
+func firstRow(query r.Exp) (interface{}, error) {
+	var row interface{}
+	rows := query.Run(session) // want `rows may be left before the last row but are never closed`
+	if rows.Next() {
+		err := rows.Scan(&row)
+		return row, err
+	}
+	return nil, rows.Err()
+}
+
+func firstRowClosed(query r.Exp) (interface{}, error) {
+	var row interface{}
+	rows := query.Run(session)
+	defer rows.Close()
+	if rows.Next() {
+		err := rows.Scan(&row)
+		return row, err
+	}
+	return nil, rows.Err()
+}
+
+func findRow(query r.Exp, want string) (bool, error) {
+	rows := query.Run(session) // want `rows may be left before the last row but are never closed`
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == want {
+			break
+		}
+	}
+	return false, rows.Err()
+}
+
+func allRows(query r.Exp) ([]string, error) {
+	var names []string
+	rows := query.Run(session)
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		// these breaks only end the inner statements
+		for _, c := range name {
+			if c == ' ' {
+				break
+			}
+		}
+		switch name {
+		case "":
+			break
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func handedOff(query r.Exp) *r.Rows {
+	rows := query.Run(session)
+	if rows.Next() {
+		return rows
+	}
+	return nil
+}
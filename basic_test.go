@@ -4,10 +4,22 @@ package rethinkgo
 // https://github.com/rethinkdb/rethinkdb/blob/next/drivers/javascript/rethinkdb/test.js
 
 import (
+	"bufio"
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	gocontext "context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"io"
+	"io/ioutil"
 	test "launchpad.net/gocheck"
+	"net"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Global expressions used in tests
@@ -60,13 +72,26 @@ func (s *RethinkSuite) TearDownSuite(c *test.C) {
 }
 
 func resetDatabase(c *test.C) {
-	// Drop the test database, then re-create it with some test data
-	DbDrop("test").Run(session)
-	err := DbCreate("test").Run(session).Err()
+	// Create the test database and tables if needed, then fill them with some
+	// test data
+	err := EnsureDatabase(session, "test")
 	c.Assert(err, test.IsNil)
 
-	err = Db("test").TableCreate("table1").Run(session).Err()
-	c.Assert(err, test.IsNil)
+	tables := []TableSpec{
+		{Name: "table1"},
+		{Name: "table2"},
+		{Name: "table3"},
+		{Name: "table4"},
+		{Name: "joins1"},
+		{Name: "joins2"},
+		{Name: "joins3", PrimaryKey: "it"},
+	}
+	for _, spec := range tables {
+		err = EnsureTable(session, spec)
+		c.Assert(err, test.IsNil)
+		err = Table(spec.Name).Delete().Run(session).Err()
+		c.Assert(err, test.IsNil)
+	}
 
 	pair := ExpectPair{tbl.Insert(Map{"id": 0, "num": 20}), MatchMap{"inserted": 1}}
 	runQuery(c, pair)
@@ -78,9 +103,6 @@ func resetDatabase(c *test.C) {
 	pair = ExpectPair{tbl.Insert(others), MatchMap{"inserted": 9}}
 	runQuery(c, pair)
 
-	err = Db("test").TableCreate("table2").Run(session).Err()
-	c.Assert(err, test.IsNil)
-
 	pair = ExpectPair{tbl2.Insert(List{
 		Map{"id": 20, "name": "bob"},
 		Map{"id": 19, "name": "tom"},
@@ -89,15 +111,9 @@ func resetDatabase(c *test.C) {
 	runQuery(c, pair)
 
 	// det
-	err = Db("test").TableCreate("table3").Run(session).Err()
-	c.Assert(err, test.IsNil)
-
 	err = tbl3.Insert(docs).Run(session).Err()
 	c.Assert(err, test.IsNil)
 
-	err = Db("test").TableCreate("table4").Run(session).Err()
-	c.Assert(err, test.IsNil)
-
 	// joins tables
 	s1 := List{
 		Map{"id": 0, "name": "bob"},
@@ -113,12 +129,8 @@ func resetDatabase(c *test.C) {
 		Map{"it": 2, "title": "lmoe"},
 	}
 
-	Db("test").TableCreate("joins1").Run(session)
 	j1.Insert(s1).Run(session)
-	Db("test").TableCreate("joins2").Run(session)
 	j2.Insert(s2).Run(session)
-	spec := TableSpec{Name: "joins3", PrimaryKey: "it"}
-	Db("test").TableCreateWithSpec(spec).Run(session)
 	j3.Insert(s3).Run(session)
 }
 
@@ -209,6 +221,9 @@ var testGroups = map[string][]ExpectPair{
 		{Expr(true), true},
 		{Expr("bob"), "bob"},
 		{Expr(nil), nil},
+		{Expr((*int)(nil)), nil},
+		{Expr([]int(nil)), List{}},
+		{Expr(map[string]int(nil)), Map{}},
 	},
 	"arith": {
 		{Expr(1).Add(2), 3},
@@ -216,6 +231,21 @@ var testGroups = map[string][]ExpectPair{
 		{Expr(5).Mul(8), 40},
 		{Expr(8).Div(2), 4},
 		{Expr(7).Mod(2), 1},
+		{Expr(-7).Mod(2), -1},
+		{Expr(7).Mod(-2), 1},
+		{Expr(-7).Mod(-2), -1},
+		{Expr(7).IntDiv(2), 3},
+		{Expr(-7).IntDiv(2), -4},
+		{Expr(7).IntDiv(-2), -4},
+		{Expr(-7).IntDiv(-2), 3},
+		{Expr(6).IntDiv(-2), -3},
+		{Expr(-6).IntDiv(2), -3},
+		{Expr(-7).IntDiv(Expr(2)), -4},
+		{Expr(7).IntDiv(Expr(-2)), -4},
+		{Expr(-7).IntDiv(Expr(-2)), 3},
+		{Expr(-6).IntDiv(Expr(2)), -3},
+		{Expr(List{Expr(1).Add(2), 4}), List{3, 4}},
+		{Expr(List{List{Expr(1).Add(2)}, Map{"a": Expr(2).Mul(3)}}), List{List{3}, Map{"a": 6}}},
 	},
 	"compare": {
 		{Expr(1).Eq(1), true},
@@ -249,6 +279,9 @@ var testGroups = map[string][]ExpectPair{
 	},
 	"merge": {
 		{Expr(Map{"a": 1}).Merge(Map{"b": 2}), Map{"a": 1, "b": 2}},
+		{Expr(Map{"a": Map{"b": 1, "c": 2}}).Merge(Map{"a": Map{"b": 3}}), Map{"a": Map{"b": 3, "c": 2}}},
+		{Expr(Map{"a": Map{"b": 1, "c": 2}}).Merge(Map{"a": Literal(Map{"b": 3})}), Map{"a": Map{"b": 3}}},
+		{Expr(Map{"a": 1, "b": 2}).Merge(Map{"b": Literal()}), Map{"a": 1}},
 	},
 	"if": {
 		{Branch(true, 1, 2), 1},
@@ -272,12 +305,33 @@ var testGroups = map[string][]ExpectPair{
 			21,
 		},
 	},
+	"aggregate": {
+		{arr.Sum(), 21},
+		{arr.Avg(), 3.5},
+		{arr.Min(), 1},
+		{arr.Max(), 6},
+		{gobj.Sum("num"), 115},
+		{gobj.Avg("num"), 23},
+		{gobj.Sum(func(row Exp) Exp { return row.Attr("g1") }), 7},
+		{gobj.Min("g2").Attr("num"), 0},
+		{gobj.Max("num"), Map{"g1": 2, "g2": 3, "num": 100}},
+		{gobj.Max(Row.Attr("g2")).Attr("g2"), 3},
+		{Expr(List{}).Sum(), 0},
+	},
 	"filter": {
 		{arr.Filter(func(val Exp) Exp {
 			return val.Lt(3)
 		}).Count(),
 			2,
 		},
+		{tbl.Filter(Map{"num >=": 15}).Count(), 6},
+		{tbl.Filter(Map{"num": Map{">": 12, "<=": 15}}).Count(), 3},
+		{tbl.Filter(Map{"id": 3, "num <": 20}).Count(), 1},
+		{tbl.Filter(Map{"id": 0, "num !=": 20}).Count(), 0},
+		{tbl.Filter(Map{"num": Gt(15)}).Count(), 5},
+		{tbl.Filter(Map{"num": Lte(12), "id": Ne(9)}).Count(), 1},
+		{tbl.Filter(Map{"id": In(1, 3, 5, 100)}).Count(), 3},
+		{tbl.Filter(Map{"num": Gte(11), "id": Lt(2)}).Count(), 2},
 	},
 	"has_fields": {
 		{tobj.HasFields("a"), true},
@@ -322,6 +376,13 @@ var testGroups = map[string][]ExpectPair{
 		{tbl.Filter(Map{"num": 16}).Nth(0), Map{"id": 4, "num": 16}},
 		{tbl.Filter(Map{"num": Expr(20).Sub(Row.Attr("id"))}).Count(), 10},
 	},
+	"exists": {
+		{tbl.Filter(Map{"num": 16}).Exists(), true},
+		{tbl.Filter(Map{"num": 100}).Exists(), false},
+		{Expr(List{}).Exists(), false},
+		{tbl.ExistsByIndex("id", 3), true},
+		{tbl.ExistsByIndex("id", 100), false},
+	},
 	"tablemap": {
 		{tbl.OrderBy("num").Map(Row.Attr("num")).Nth(2), 13},
 	},
@@ -403,6 +464,34 @@ var testGroups = map[string][]ExpectPair{
 			},
 		},
 	},
+	"group": {
+		{gobj.Group("g1").Count(),
+			List{
+				Map{"group": 1, "reduction": 3},
+				Map{"group": 2, "reduction": 2},
+			},
+		},
+		{gobj.Group("g1").Sum("num"),
+			List{
+				Map{"group": 1, "reduction": 15},
+				Map{"group": 2, "reduction": 100},
+			},
+		},
+		{gobj.Group("g1", "g2").Count(),
+			List{
+				Map{"group": List{1, 1}, "reduction": 1},
+				Map{"group": List{1, 2}, "reduction": 2},
+				Map{"group": List{2, 3}, "reduction": 2},
+			},
+		},
+		{gobj.Group(func(row Exp) Exp { return row.Attr("num").Gt(5) }).Count(),
+			List{
+				Map{"group": false, "reduction": 3},
+				Map{"group": true, "reduction": 2},
+			},
+		},
+		{gobj.Group("g1").Count().Ungroup().OrderBy(Desc("reduction")).Nth(0).Attr("group"), 1},
+	},
 	"concatmap": {
 		{tbl.ConcatMap(func(row Exp) Exp {return Expr(List{1, 2})}).Count(), 20},
 	},
@@ -686,6 +775,2724 @@ func (s *RethinkSuite) TestOrderBy(c *test.C) {
 func (s *RethinkSuite) TestDropTable(c *test.C) {
 	err := Db("test").TableCreate("tablex").Run(session).Err()
 	c.Assert(err, test.IsNil)
+	err = Db("test").Table("tablex").WaitReady(session, 10*time.Second)
+	c.Assert(err, test.IsNil)
 	err = Db("test").TableDrop("tablex").Run(session).Err()
 	c.Assert(err, test.IsNil)
 }
+
+// xorCipher is a toy Cipher for testing encrypted fields
+type xorCipher struct{}
+
+func (xorCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	ciphertext := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		ciphertext[i] = b ^ 0x5a
+	}
+	return ciphertext, nil
+}
+
+func (c xorCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	return c.Encrypt(ciphertext)
+}
+
+type secretHero struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Identity string `json:"identity" rethinkdb:",encrypted"`
+}
+
+func (s *RethinkSuite) TestEncryptedFields(c *test.C) {
+	SetCipher(xorCipher{})
+	defer SetCipher(nil)
+
+	hero := secretHero{Id: 100, Name: "Superman", Identity: "Clark Kent"}
+	err := tbl4.Insert(hero).Run(session).Err()
+	c.Assert(err, test.IsNil)
+
+	var raw Map
+	err = tbl4.Get(100).Run(session).One(&raw)
+	c.Assert(err, test.IsNil)
+	c.Assert(raw["name"], test.Equals, "Superman")
+	c.Assert(raw["identity"], test.Not(test.Equals), "Clark Kent")
+
+	var result secretHero
+	err = tbl4.Get(100).Run(session).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, hero)
+}
+
+type alignment int
+
+const (
+	good alignment = iota
+	evil
+)
+
+func (a alignment) String() string {
+	return []string{"good", "evil"}[a]
+}
+
+type alignedHero struct {
+	Id        int       `json:"id"`
+	Alignment alignment `json:"alignment" rethinkdb:",string"`
+}
+
+func (s *RethinkSuite) TestEnumFields(c *test.C) {
+	RegisterEnum(good, evil)
+
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	hero := alignedHero{Id: 1, Alignment: evil}
+	err = tbl4.Insert(hero).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var raw Map
+	err = tbl4.Get(1).Run(session).One(&raw)
+	c.Assert(err, test.IsNil)
+	c.Assert(raw["alignment"], test.Equals, "evil")
+
+	var result alignedHero
+	err = tbl4.Get(1).Run(session).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, hero)
+
+	// rows from before the field was tagged still decode
+	err = tbl4.Insert(Map{"id": 2, "alignment": 1}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Get(2).Run(session).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result.Alignment, test.Equals, evil)
+
+	err = tbl4.Insert(Map{"id": 3, "alignment": "neutral"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Get(3).Run(session).One(&result)
+	c.Assert(err, test.NotNil)
+}
+
+type legacyHero struct {
+	Name     string `json:"name"`
+	Strength int    `json:"strength"`
+	Speed    int    `json:"speed"`
+	Total    int    `json:"-"`
+}
+
+func (s *RethinkSuite) TestTransforms(c *test.C) {
+	var strengths []interface{}
+	RegisterTransform(legacyHero{}, Transform{
+		Before: func(row map[string]interface{}) error {
+			if name, ok := row["hero_name"]; ok {
+				row["name"] = name
+			}
+			strengths = append(strengths, row["strength"])
+			return nil
+		},
+		After: func(value interface{}) error {
+			hero := value.(*legacyHero)
+			hero.Total = hero.Strength + hero.Speed
+			return nil
+		},
+	})
+	defer RegisterTransform(legacyHero{}, Transform{})
+
+	var hero legacyHero
+	err := Expr(Map{"hero_name": "Storm", "strength": 2, "speed": 5}).Run(session).One(&hero)
+	c.Assert(err, test.IsNil)
+	c.Assert(hero, test.Equals, legacyHero{"Storm", 2, 5, 7})
+	c.Assert(strengths, test.DeepEquals, []interface{}{json.Number("2")})
+
+	var heroes []legacyHero
+	rows := List{Map{"name": "Rogue", "strength": 7}, Map{"hero_name": "Storm", "speed": 5}}
+	err = Expr(rows).Run(session).All(&heroes)
+	c.Assert(err, test.IsNil)
+	c.Assert(heroes, test.DeepEquals, []legacyHero{{"Rogue", 7, 0, 7}, {"Storm", 0, 5, 5}})
+}
+
+func (s *RethinkSuite) TestWriteResponseErr(c *test.C) {
+	var response WriteResponse
+	err := tbl.Insert(List{Map{"id": 0}, Map{"id": 1}, Map{"id": 100}}).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 1)
+
+	err = response.Err()
+	writeErr, ok := err.(WriteError)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(writeErr.Errors, test.Equals, 2)
+	c.Assert(writeErr.FirstError, test.Equals, response.FirstError)
+
+	err = tbl.Get(100).Delete().Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Err(), test.IsNil)
+}
+
+func (s *RethinkSuite) TestServerVersion(c *test.C) {
+	if version := session.ServerVersion(); version != "" {
+		_, ok := parseServerVersion(version)
+		c.Assert(ok, test.Equals, true)
+	}
+}
+
+// fakeServerDialer returns a dialer for a server that accepts the handshake,
+// refuses SERVER_INFO and answers every other query with the number 1.
+func fakeServerDialer() func(ctx gocontext.Context, network, address string) (net.Conn, error) {
+	return func(ctx gocontext.Context, network, address string) (net.Conn, error) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			defer listener.Close()
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			var magic, length uint32
+			binary.Read(conn, binary.LittleEndian, &magic)
+			binary.Read(conn, binary.LittleEndian, &length)
+			conn.Read(make([]byte, length))
+			conn.Write([]byte("SUCCESS\x00"))
+			for binary.Read(conn, binary.LittleEndian, &length) == nil {
+				data := make([]byte, length)
+				if _, err := io.ReadFull(conn, data); err != nil {
+					return
+				}
+				query := &p.Query{}
+				if proto.Unmarshal(data, query) != nil {
+					return
+				}
+				response := &p.Response{Token: query.Token, Type: p.Response_SUCCESS_ATOM.Enum()}
+				if query.GetType() == p.Query_SERVER_INFO {
+					response.Type = p.Response_CLIENT_ERROR.Enum()
+				}
+				response.Response = []*p.Datum{{Type: p.Datum_R_NUM.Enum(), RNum: proto.Float64(1)}}
+				data, _ = proto.Marshal(response)
+				binary.Write(conn, binary.LittleEndian, uint32(len(data)))
+				conn.Write(data)
+			}
+		}()
+		return net.Dial("tcp", listener.Addr().String())
+	}
+}
+
+func (s *RethinkSuite) TestServerVersionUnknown(c *test.C) {
+	sess, err := ConnectWithOpts(ConnectOpts{Address: "fake", Database: "test", Dialer: fakeServerDialer()})
+	c.Assert(err, test.IsNil)
+	defer sess.Close()
+
+	// a server that does not say what version it runs is sent every term
+	c.Assert(sess.ServerVersion(), test.Equals, "")
+	var result int
+	err = tbl.Changes().Run(sess).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, 1)
+
+	// unless the version is given
+	c.Assert(sess.SetServerVersion("1.15.0"), test.IsNil)
+	c.Assert(sess.ServerVersion(), test.Equals, "1.15.0")
+	err = tbl.Changes().Run(sess).One(&result)
+	c.Assert(err, test.ErrorMatches, ".*CHANGES requires server >= 1.16.0, but the server is running 1.15.0.*")
+	c.Assert(sess.Reconnect(), test.IsNil)
+	c.Assert(sess.ServerVersion(), test.Equals, "1.15.0")
+
+	c.Assert(sess.SetServerVersion("next"), test.NotNil)
+	c.Assert(sess.SetServerVersion(""), test.IsNil)
+	c.Assert(sess.Reconnect(), test.IsNil)
+	c.Assert(sess.ServerVersion(), test.Equals, "")
+}
+
+func (s *RethinkSuite) TestBetweenBounds(c *test.C) {
+	// bounds only apply to the .Between() they follow, so both ranges can be
+	// used in the same query
+	open := tbl.Between("id", 2, 4).LeftBound("open").RightBound("open")
+	closed := tbl.Between("id", 2, 4).LeftBound("closed").RightBound("closed")
+	pair := ExpectPair{Expr(Map{"open": open.Count(), "closed": closed.Count()}), Map{"open": 1, "closed": 3}}
+	runQuery(c, pair)
+
+	query, err := session.getContext().buildProtobuf(open.Union(tbl.Between("id", 6, 8)))
+	c.Assert(err, test.IsNil)
+	union := query.GetQuery()
+	c.Assert(union.Args[0].Optargs, test.HasLen, 3)
+	c.Assert(union.Args[1].Optargs, test.HasLen, 1)
+
+	err = tbl.Between("id", 2, 4).Count().LeftBound("open").Check(session)
+	c.Assert(err, test.NotNil)
+}
+
+// optargNames returns the names of the optargs set on a term
+func optargNames(term *p.Term) map[string]bool {
+	names := map[string]bool{}
+	for _, optarg := range term.GetOptargs() {
+		names[optarg.GetKey()] = true
+	}
+	return names
+}
+
+func (s *RethinkSuite) TestWriteOptionsScope(c *test.C) {
+	// durability should only end up on the insert it is chained on
+	query, err := session.getContext().buildProtobuf(tbl.ForEach(func(row Exp) Exp {
+		return tbl2.Insert(row)
+	}).Union(tbl4.Insert(Map{"id": 1}).Durability("soft")))
+	c.Assert(err, test.IsNil)
+
+	union := query.GetQuery()
+	forEachInsert := union.Args[0].Args[1].Args[1]
+	c.Assert(optargNames(forEachInsert)["durability"], test.Equals, false)
+	c.Assert(optargNames(union.Args[1])["durability"], test.Equals, true)
+
+	err = tbl.ForEach(func(row Exp) Exp {
+		return tbl2.Insert(row)
+	}).Durability("soft").Check(session)
+	c.Assert(err, test.NotNil)
+}
+
+type character interface {
+	Alignment() string
+}
+
+type hero struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type villain struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Nemesis string `json:"nemesis"`
+}
+
+func (hero) Alignment() string     { return "good" }
+func (*villain) Alignment() string { return "evil" }
+
+func (s *RethinkSuite) TestRegisteredTypes(c *test.C) {
+	RegisterType("hero", hero{})
+	RegisterType("villain", &villain{})
+
+	characters := List{hero{200, "Batman"}, &villain{201, "Joker", "Batman"}}
+	err := tbl4.Insert(characters).Run(session).Err()
+	c.Assert(err, test.IsNil)
+
+	var results []character
+	err = tbl4.Filter(Row.Attr("id").Ge(200)).OrderBy("id").Run(session).All(&results)
+	c.Assert(err, test.IsNil)
+	c.Assert(results, test.DeepEquals, []character{hero{200, "Batman"}, &villain{201, "Joker", "Batman"}})
+
+	var raw Map
+	err = tbl4.Get(201).Run(session).One(&raw)
+	c.Assert(err, test.IsNil)
+	c.Assert(raw["type"], test.Equals, "villain")
+}
+
+func (s *RethinkSuite) TestRunBatch(c *test.C) {
+	results, err := RunBatch(session, map[string]Exp{
+		"count": tbl.Count(),
+		"rows":  tbl2.OrderBy("id"),
+		"error": Expr(1).Add("a"),
+	})
+	c.Assert(err, test.IsNil)
+	c.Assert(results, test.HasLen, 3)
+
+	var count int
+	err = results["count"].One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 10)
+
+	var rows []Map
+	err = results["rows"].All(&rows)
+	c.Assert(err, test.IsNil)
+	c.Assert(rows, JsonEquals, []Map{
+		Map{"id": 18, "name": "joe"},
+		Map{"id": 19, "name": "tom"},
+		Map{"id": 20, "name": "bob"},
+	})
+
+	c.Assert(results["error"].Err(), test.NotNil)
+}
+
+func (s *RethinkSuite) TestBetweenTime(c *test.C) {
+	to := time.Date(2013, 8, 1, 0, 0, 0, 0, time.UTC)
+	query, err := session.getContext().buildProtobuf(tbl.BetweenTime("date", time.Time{}, to))
+	c.Assert(err, test.IsNil)
+
+	between := query.GetQuery()
+	c.Assert(between.GetType(), test.Equals, p.Term_BETWEEN)
+	c.Assert(between.Args[2].GetType(), test.Equals, p.Term_EPOCH_TIME)
+	names := optargNames(between)
+	c.Assert(names["left_bound"], test.Equals, true)
+	c.Assert(names["right_bound"], test.Equals, true)
+
+	var seconds float64
+	// the number is sent as a JSON term
+	err = json.Unmarshal([]byte(between.Args[2].Args[0].Args[0].Datum.GetRStr()), &seconds)
+	c.Assert(err, test.IsNil)
+	c.Assert(seconds, test.Equals, float64(to.Unix()))
+}
+
+func (s *RethinkSuite) TestRunCached(c *test.C) {
+	cache := NewLRUCache(10)
+	query := func() Exp {
+		return tbl4.Filter(func(row Exp) Exp { return row.Attr("id").Ge(300) }).Count()
+	}
+
+	var count int
+	err := query().RunCached(session, cache, time.Minute).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 0)
+
+	err = tbl4.Insert(Map{"id": 300}).Run(session).Err()
+	c.Assert(err, test.IsNil)
+
+	// the same query built again should still come from the cache
+	err = query().RunCached(session, cache, time.Minute).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 0)
+
+	err = query().RunCached(session, NewLRUCache(10), time.Minute).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	// the session's default options are part of the key
+	defaults := session.defaultRunOpts
+	defer session.SetDefaultRunOpts(defaults)
+	session.SetDefaultRunOpts(RunOpts{UseOutdated: Bool(true), TimeFormat: "raw"})
+	err = query().RunCached(session, cache, time.Minute).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	// and times are decoded as the session says, whether cached or not
+	for i := 0; i < 2; i++ {
+		var raw map[string]interface{}
+		err = Expr(time.Unix(0, 0)).RunCached(session, cache, time.Minute).One(&raw)
+		c.Assert(err, test.IsNil)
+		c.Assert(raw["$reql_type$"], test.Equals, "TIME")
+	}
+
+	// writes are never cached
+	var response WriteResponse
+	for i := 0; i < 2; i++ {
+		err = tbl4.Get(300).Update(Map{"n": i}).RunCached(session, cache, time.Minute).One(&response)
+		c.Assert(err, test.IsNil)
+		c.Assert(response.Replaced, test.Equals, 1)
+	}
+}
+
+func (s *RethinkSuite) TestImport(c *test.C) {
+	csvData := "name,zip,age,active\nWolverine,02139,137,true\nStorm,,,false\n"
+	response, err := ImportCSV(session, tbl4, strings.NewReader(csvData), ImportOpts{KeyColumn: "name", BatchSize: 1})
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 2)
+	c.Assert(response.Err(), test.IsNil)
+
+	var row Map
+	err = tbl4.Get("Wolverine").Run(session).One(&row)
+	c.Assert(err, test.IsNil)
+	c.Assert(row, JsonEquals, Map{"id": "Wolverine", "zip": "02139", "age": 137, "active": true})
+
+	// only plain decimal numbers are converted
+	csvData = "name,a,b,c,d,e,f\nRogue,NaN,-Inf,Infinity,0x1p-2,1_000,2.5e3\n"
+	response, err = ImportCSV(session, tbl4, strings.NewReader(csvData), ImportOpts{KeyColumn: "name"})
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 1)
+	err = tbl4.Get("Rogue").Run(session).One(&row)
+	c.Assert(err, test.IsNil)
+	c.Assert(row, JsonEquals, Map{"id": "Rogue", "a": "NaN", "b": "-Inf", "c": "Infinity", "d": "0x1p-2", "e": "1_000", "f": 2500})
+
+	ndjson := "{\"id\": \"Cyclops\", \"power\": 9}\n\n{\"id\": \"Storm\"}\n"
+	response, err = ImportNDJSON(session, tbl4, strings.NewReader(ndjson), ImportOpts{})
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 1)
+	c.Assert(response.Errors, test.Equals, 1)
+}
+
+func (s *RethinkSuite) TestEnsureIndexes(c *test.C) {
+	specs := []IndexSpec{
+		{Name: "num"},
+		{Name: "double", Function: func(row Exp) Exp { return row.Attr("num").Mul(2) }},
+		{Name: "tags", Multi: true},
+	}
+	// the second call should find all of the indexes already there
+	for i := 0; i < 2; i++ {
+		err := EnsureIndexes(session, "table1", specs)
+		c.Assert(err, test.IsNil)
+	}
+
+	var indexes []string
+	err := tbl.IndexList().Run(session).One(&indexes)
+	c.Assert(err, test.IsNil)
+	c.Assert(indexes, test.HasLen, 3)
+
+	var count int
+	err = tbl.GetAll("double", 40).Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	for _, spec := range specs {
+		err = tbl.IndexDrop(spec.Name).Run(session).Exec()
+		c.Assert(err, test.IsNil)
+	}
+
+	// older servers cannot wait for indexes, so they are only created
+	version := session.version
+	session.version = serverVersion{1, 11, 0}
+	err = EnsureIndexes(session, "table1", specs[:1])
+	session.version = version
+	c.Assert(err, test.IsNil)
+	err = tbl.IndexDrop(specs[0].Name).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *RethinkSuite) TestTableInfo(c *test.C) {
+	info, err := j3.TableInfo(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(info.Type, test.Equals, "TABLE")
+	c.Assert(info.Name, test.Equals, "joins3")
+	c.Assert(info.PrimaryKey, test.Equals, "it")
+	c.Assert(info.Db.Name, test.Equals, "test")
+}
+
+func (s *RethinkSuite) TestConnectMultipleAddresses(c *test.C) {
+	c.Assert(splitAddresses(" a:1, b:2,,"), test.DeepEquals, []string{"a:1", "b:2"})
+
+	// nothing is listening on port 1, so every session should end up on the
+	// working server
+	for i := 0; i < 3; i++ {
+		sess, err := Connect("localhost:1,localhost:28015", "test")
+		c.Assert(err, test.IsNil)
+		var count int
+		err = sess.Run(Table("table1").Count()).One(&count)
+		c.Assert(err, test.IsNil)
+		c.Assert(sess.Close(), test.IsNil)
+	}
+
+	_, err := Connect("localhost:1,localhost:2", "test")
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestReconnect(c *test.C) {
+	sess, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	defer sess.Close()
+
+	events := make(chan ReconnectEvent, 10)
+	sess.SetReconnectHook(func(event ReconnectEvent) { events <- event })
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() { done <- sess.Reconnect() }()
+	}
+	for i := 0; i < 10; i++ {
+		c.Assert(<-done, test.IsNil)
+	}
+	close(events)
+	callers := 0
+	for event := range events {
+		c.Assert(event.Err, test.IsNil)
+		c.Assert(event.Wait, test.Equals, time.Duration(0))
+		callers += 1 + event.Joined
+	}
+	c.Assert(callers, test.Equals, 10)
+
+	// a query that failed on a connection that has since been replaced does
+	// not reconnect again
+	reconnects := 0
+	sess.SetReconnectHook(func(event ReconnectEvent) { reconnects++ })
+	generation := sess.connGeneration()
+	c.Assert(sess.Reconnect(), test.IsNil)
+	c.Assert(sess.connGeneration(), test.Equals, generation+1)
+	c.Assert(sess.reconnectFrom(generation), test.IsNil)
+	c.Assert(reconnects, test.Equals, 1)
+	c.Assert(sess.connGeneration(), test.Equals, generation+1)
+
+	// nothing is listening on port 1, so reconnects back off
+	bad, err := Connect("localhost:1", "test")
+	c.Assert(err, test.NotNil)
+	bad.SetReconnectBackoff(2*time.Millisecond, 4*time.Millisecond)
+	var last ReconnectEvent
+	bad.SetReconnectHook(func(event ReconnectEvent) { last = event })
+	for i := 2; i < 5; i++ {
+		c.Assert(bad.Reconnect(), test.NotNil)
+		c.Assert(last.Failures, test.Equals, i)
+		c.Assert(last.Wait >= time.Millisecond, test.Equals, true)
+		c.Assert(last.Wait <= 4*time.Millisecond, test.Equals, true)
+	}
+}
+
+func (s *RethinkSuite) TestInternedStrings(c *test.C) {
+	query, err := session.getContext().buildProtobuf(Row.Attr("name").Add(Row.Attr("name")))
+	c.Assert(err, test.IsNil)
+	args := query.GetQuery().Args
+	c.Assert(args[0].Args[1], test.Equals, args[1].Args[1])
+
+	// separate queries do not share strings
+	other, err := session.getContext().buildProtobuf(Row.Attr("name"))
+	c.Assert(err, test.IsNil)
+	c.Assert(other.GetQuery().Args[1] == args[0].Args[1], test.Equals, false)
+
+	var response []string
+	err = Expr(List{Map{"name": "a"}, Map{"name": "b"}}).Map(Row.Attr("name").Add(Row.Attr("name"))).Run(session).All(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response, test.DeepEquals, []string{"aa", "bb"})
+}
+
+func (s *RethinkSuite) TestRunNoreply(c *test.C) {
+	tbl := tbl4
+	err := tbl.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	for i := 0; i < 5; i++ {
+		err = tbl.Insert(Map{"id": i}).RunNoreply(session)
+		c.Assert(err, test.IsNil)
+	}
+	c.Assert(session.PendingNoreply(), test.Equals, 5)
+
+	err = session.NoreplyWait()
+	c.Assert(err, test.IsNil)
+	c.Assert(session.PendingNoreply(), test.Equals, 0)
+
+	var count int
+	err = tbl.Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 5)
+
+	// reaching the high water mark waits for the pending queries
+	session.SetNoreplyHighWater(3)
+	defer session.SetNoreplyHighWater(0)
+	for i := 5; i < 8; i++ {
+		err = tbl.Insert(Map{"id": i}).RunNoreply(session)
+		c.Assert(err, test.IsNil)
+	}
+	c.Assert(session.PendingNoreply(), test.Equals, 0)
+
+	err = tbl.Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 8)
+
+	err = tbl.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *RethinkSuite) TestUseIndex(c *test.C) {
+	heroes := tbl.UseIndex("id")
+	pair := ExpectPair{heroes.Between("", 2, 4).Count().Eq(tbl.Between("id", 2, 4).Count()), true}
+	runQuery(c, pair)
+
+	query, err := session.getContext().buildProtobuf(heroes.GetAll("", 1, 2))
+	c.Assert(err, test.IsNil)
+	c.Assert(optargNames(query.GetQuery())["index"], test.Equals, true)
+	c.Assert(query.GetQuery().Args[0].GetType(), test.Equals, p.Term_TABLE)
+
+	// the hint only applies to the term that directly follows it
+	query, err = session.getContext().buildProtobuf(heroes.Filter(Map{"id": 1}).OrderBy("num"))
+	c.Assert(err, test.IsNil)
+	c.Assert(optargNames(query.GetQuery())["index"], test.Equals, false)
+
+	query, err = session.getContext().buildProtobuf(tbl.Between("", 2, 4))
+	c.Assert(err, test.IsNil)
+	c.Assert(optargNames(query.GetQuery())["index"], test.Equals, false)
+}
+
+func (s *RethinkSuite) TestDecodeError(c *test.C) {
+	var hero struct {
+		Name   string `json:"name"`
+		Powers []struct {
+			Level int `json:"level"`
+		} `json:"powers"`
+	}
+	row := Map{"name": "Storm", "powers": List{Map{"level": 3}, Map{"level": "high"}}}
+	err := Expr(row).Run(session).One(&hero)
+	decodeErr, ok := err.(DecodeError)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(decodeErr.Path, test.Equals, "powers.1.level")
+	c.Assert(strings.Contains(decodeErr.Data, `"high"`), test.Equals, true)
+
+	var names []int
+	err = Expr(List{1, "two"}).Run(session).All(&names)
+	_, ok = err.(DecodeError)
+	c.Assert(ok, test.Equals, true)
+}
+
+func (s *RethinkSuite) TestRowsIndex(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	docs := List{}
+	for i := 0; i < 2500; i++ {
+		docs = append(docs, Map{"id": i})
+	}
+	err = tbl4.Insert(docs).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	// enough rows that the server sends them in more than one batch
+	rows := tbl4.Run(session)
+	c.Assert(rows.Index(), test.Equals, -1)
+	for i := 0; rows.Next(); i++ {
+		c.Assert(rows.Index(), test.Equals, i)
+	}
+	c.Assert(rows.Err(), test.IsNil)
+	c.Assert(rows.Index(), test.Equals, 2499)
+}
+
+func (s *RethinkSuite) TestRowsType(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	c.Assert(tbl4.Count().Run(session).Type(), test.Equals, ResultAtom)
+	c.Assert(tbl4.Run(session).Type(), test.Equals, ResultSequence)
+	c.Assert(Expr(1).Add("a").Run(session).Type(), test.Equals, ResultNone)
+
+	var row Map
+	err = tbl4.Filter(Map{"id": 1}).Run(session).One(&row)
+	c.Assert(err, test.IsNil)
+	c.Assert(row["id"], test.Equals, 1.0)
+
+	err = tbl4.Filter(Map{"id": 3}).Run(session).One(&row)
+	c.Assert(err, test.Equals, ErrRowCount{Count: 0})
+
+	rows := tbl4.Run(session)
+	err = rows.One(&row)
+	c.Assert(err, test.Equals, ErrRowCount{Count: 2})
+	// the rest of the rows are closed
+	c.Assert(rows.Next(), test.Equals, false)
+	c.Assert(rows.Err(), test.IsNil)
+}
+
+func (s *RethinkSuite) TestRunOpts(c *test.C) {
+	defaults := RunOpts{Durability: "soft", UseOutdated: Bool(true)}
+	merged := RunOpts{UseOutdated: Bool(false)}.merge(defaults)
+	c.Assert(merged.globalOptargs(), test.DeepEquals, map[string]interface{}{
+		"durability":   "soft",
+		"use_outdated": false,
+	})
+
+	session.SetDefaultRunOpts(defaults)
+	defer session.SetDefaultRunOpts(RunOpts{})
+
+	query, err := session.buildQuery(tbl.Count(), RunOpts{Durability: "hard"})
+	c.Assert(err, test.IsNil)
+	c.Assert(query.GlobalOptargs, test.HasLen, 2)
+
+	var count int
+	err = tbl.Count().RunWith(session, RunOpts{Durability: "hard"}).One(&count)
+	c.Assert(err, test.IsNil)
+}
+
+func (s *RethinkSuite) TestRunOptsOptargs(c *test.C) {
+	defaults := RunOpts{Optargs: map[string]interface{}{"array_limit": 10, "profile": true}}
+	merged := RunOpts{ArrayLimit: 20, Optargs: map[string]interface{}{"durability": "soft"}}.merge(defaults)
+	c.Assert(merged.globalOptargs(), test.DeepEquals, map[string]interface{}{
+		"array_limit": 20,
+		"durability":  "soft",
+		"profile":     true,
+	})
+
+	err := Expr(List{1, 2, 3}).Map(Row.Add(1)).RunWith(session, RunOpts{ArrayLimit: 2}).Exec()
+	c.Assert(err, test.NotNil)
+
+	err = tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	rows := tbl4.Insert(Map{"id": 1}).RunWith(session, RunOpts{Noreply: Bool(true)})
+	c.Assert(rows.Exec(), test.IsNil)
+	c.Assert(rows.Next(), test.Equals, false)
+	c.Assert(session.NoreplyWait(), test.IsNil)
+
+	// a query can wait for its response when noreply is the default
+	defaults = RunOpts{Noreply: Bool(true)}
+	c.Assert(*RunOpts{}.merge(defaults).Noreply, test.Equals, true)
+	c.Assert(*RunOpts{Noreply: Bool(false)}.merge(defaults).Noreply, test.Equals, false)
+	c.Assert(RunOpts{Noreply: Bool(false)}.globalOptargs(), test.DeepEquals, map[string]interface{}{})
+
+	var count int
+	err = tbl4.Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+}
+
+func (s *RethinkSuite) TestRunOptsBatching(c *test.C) {
+	opts := RunOpts{MaxBatchRows: 2, MaxBatchBytes: 1024, FirstBatchScaledown: 1}
+	c.Assert(opts.globalOptargs(), test.DeepEquals, map[string]interface{}{
+		"max_batch_rows":               2,
+		"max_batch_bytes":              1024,
+		"first_batch_scaledown_factor": 1,
+	})
+
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}, Map{"id": 3}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var rows []Map
+	err = tbl4.RunWith(session, opts).All(&rows)
+	c.Assert(err, test.IsNil)
+	c.Assert(rows, test.HasLen, 3)
+}
+
+func (s *RethinkSuite) TestRunWithDb(c *test.C) {
+	DbCreate("rethinkgo_other").Run(session).Exec()
+	defer DbDrop("rethinkgo_other").Run(session).Exec()
+	err := Db("rethinkgo_other").TableCreate("table4").Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	err = tbl4.Insert(Map{"id": 1}).RunWithDb(session, "rethinkgo_other").Exec()
+	c.Assert(err, test.IsNil)
+
+	var count int
+	err = tbl4.Count().RunWithDb(session, "rethinkgo_other").One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	// the session's database is unchanged
+	err = Db("rethinkgo_other").Table("table4").Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+	c.Assert(session.database, test.Equals, "test")
+}
+
+func (s *RethinkSuite) TestInsertWith(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	next := 0
+	opts := InsertOpts{KeyFunc: func(doc interface{}) string {
+		next++
+		return fmt.Sprintf("key%v", next)
+	}}
+	row := Map{"name": "a"}
+	err = tbl4.InsertWith(opts, List{row, Map{"id": "mine", "name": "b"}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	c.Assert(row["id"], test.IsNil)
+
+	var result Map
+	err = tbl4.Get("key1").Run(session).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result["name"], test.Equals, "a")
+	err = tbl4.Get("mine").Run(session).One(&result)
+	c.Assert(err, test.IsNil)
+
+	// the same key again fails unless conflicts replace the row
+	next = 0
+	var response WriteResponse
+	err = tbl4.InsertWith(opts, Map{"name": "c"}).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Errors, test.Equals, 1)
+
+	next = 0
+	opts.Conflict = "replace"
+	err = tbl4.InsertWith(opts, Map{"name": "c"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Get("key1").Run(session).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result["name"], test.Equals, "c")
+}
+
+func (s *RethinkSuite) TestTruncate(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	docs := List{}
+	for i := 0; i < 25; i++ {
+		docs = append(docs, Map{"id": i})
+	}
+	err = tbl4.Insert(docs).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var progress []int
+	deleted, err := tbl4.Truncate(session, 10, func(deleted int) {
+		progress = append(progress, deleted)
+	})
+	c.Assert(err, test.IsNil)
+	c.Assert(deleted, test.Equals, 25)
+	c.Assert(progress, test.DeepEquals, []int{10, 20, 25})
+
+	var count int
+	err = tbl4.Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 0)
+
+	_, err = tbl4.Truncate(session, 0, nil)
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestEstimatedCount(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}, Map{"id": 3}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	count, err := tbl4.EstimatedCount(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(count > 0, test.Equals, true)
+
+	_, err = Table("nonexistent").EstimatedCount(session)
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestDeterministic(c *test.C) {
+	addOne := func(row Exp) Exp { return Expr(Map{"n": row.Attr("n").Add(1)}) }
+	c.Assert(CheckDeterministic(addOne), test.IsNil)
+	c.Assert(CheckDeterministic(Map{"n": Row.Attr("n")}), test.IsNil)
+
+	lookup := func(row Exp) Exp { return tbl.Get(row.Attr("id")) }
+	err := CheckDeterministic(lookup)
+	c.Assert(err, test.ErrorMatches, ".*TABLE reads a table.*")
+	err = CheckDeterministic(Map{"n": Js("1")})
+	c.Assert(err, test.ErrorMatches, ".*JAVASCRIPT runs javascript.*")
+	err = CheckDeterministic(Map{"n": Random(10)})
+	c.Assert(err, test.ErrorMatches, ".*RANDOM picks a random number.*")
+
+	err = tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1, "n": 1}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Update(Deterministic(addOne)).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Update(Deterministic(lookup)).Run(session).Exec()
+	c.Assert(err, test.ErrorMatches, ".*not deterministic.*")
+}
+
+func (s *RethinkSuite) TestUpdateWithOpts(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1, "n": 1}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var response WriteResponse
+	opts := UpdateOpts{NonAtomic: true, Durability: "soft", ReturnValues: true}
+	err = tbl4.Get(1).UpdateWithOpts(Map{"n": Js("2")}, opts).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Replaced, test.Equals, 1)
+	c.Assert(response.NewValue, test.DeepEquals, map[string]interface{}{"id": 1.0, "n": 2.0})
+
+	err = tbl4.Get(1).ReplaceWithOpts(Map{"id": 1, "n": 3}, UpdateOpts{Durability: "hard"}).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Replaced, test.Equals, 1)
+
+	// without NonAtomic the server refuses the javascript, and reports it as
+	// an error in the write response
+	response = WriteResponse{}
+	err = tbl4.Get(1).UpdateWithOpts(Map{"n": Js("4")}, UpdateOpts{}).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Errors, test.Equals, 1)
+	c.Assert(response.Replaced, test.Equals, 0)
+	c.Assert(response.FirstError, test.Matches, ".*non_atomic.*")
+}
+
+func (s *RethinkSuite) TestDeleteAll(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	docs := List{}
+	keys := []interface{}{}
+	for i := 0; i < 10; i++ {
+		docs = append(docs, Map{"id": i})
+		keys = append(keys, i)
+	}
+	err = tbl4.Insert(docs).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	// a key that does not exist is skipped
+	keys = append(keys[:7], 100)
+	response, err := tbl4.DeleteAll(session, keys, DeleteOpts{BatchSize: 3, Durability: "soft"})
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Deleted, test.Equals, 7)
+
+	var count int
+	err = tbl4.Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 3)
+
+	// a chunk size of zero deletes all of the keys at once
+	SetGetAllChunkSize(0)
+	defer SetGetAllChunkSize(1000)
+	response, err = tbl4.DeleteAll(session, []interface{}{7, 8, 9}, DeleteOpts{})
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Deleted, test.Equals, 3)
+}
+
+func (s *RethinkSuite) TestPaginate(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	docs := List{}
+	for i := 0; i < 25; i++ {
+		docs = append(docs, Map{"id": i})
+	}
+	err = tbl4.Insert(docs).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var items []Map
+	page, err := Paginate(session, tbl4.OrderBy("id"), 3, 10, &items)
+	c.Assert(err, test.IsNil)
+	c.Assert(page.Total, test.Equals, 25)
+	c.Assert(page.Pages, test.Equals, 3)
+	c.Assert(len(items), test.Equals, 5)
+	c.Assert(items[0]["id"], test.Equals, 20.0)
+	c.Assert(page.Items, test.DeepEquals, items)
+
+	_, err = Paginate(session, tbl4, 0, 10, &items)
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestDecodeNumbers(c *test.C) {
+	var small struct {
+		N int8
+		U uint
+	}
+	err := Expr(Map{"N": 5, "U": 7}).Run(session).One(&small)
+	c.Assert(err, test.IsNil)
+	c.Assert(small.N, test.Equals, int8(5))
+
+	tests := []struct {
+		row    Map
+		reason string
+	}{
+		{Map{"N": 300}, "it is out of range"},
+		{Map{"N": 1.5}, "it has a fractional part"},
+		{Map{"U": -1}, "it is negative"},
+	}
+	for _, t := range tests {
+		err := Expr(t.row).Run(session).One(&small)
+		decodeErr, ok := err.(DecodeError)
+		c.Assert(ok, test.Equals, true)
+		numErr, ok := decodeErr.Err.(NumberError)
+		c.Assert(ok, test.Equals, true)
+		c.Assert(numErr.Reason, test.Equals, t.reason)
+	}
+}
+
+func (s *RethinkSuite) TestSlowQueryLog(c *test.C) {
+	var logged []string
+	session.SetSlowQueryThreshold(time.Nanosecond, func(query string, duration time.Duration) {
+		logged = append(logged, query)
+		c.Assert(duration > 0, test.Equals, true)
+	})
+	defer session.SetSlowQueryThreshold(0, nil)
+
+	err := tbl4.Count().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	c.Assert(logged, test.DeepEquals, []string{`COUNT(TABLE(DB("test"), "table4"))`})
+
+	session.SetSlowQueryThreshold(time.Hour, func(query string, duration time.Duration) {
+		c.Error("query should not be slow:", query)
+	})
+	err = tbl4.Count().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+}
+
+type recordingHook struct {
+	events []string
+	tokens []int64
+	errs   []error
+}
+
+func (h *recordingHook) OnQueryStart(token int64, query string) {
+	h.events = append(h.events, "start "+query)
+	h.tokens = append(h.tokens, token)
+}
+
+func (h *recordingHook) OnQueryEnd(token int64, query string, duration time.Duration, err error) {
+	h.events = append(h.events, "end "+query)
+	h.tokens = append(h.tokens, token)
+	h.errs = append(h.errs, err)
+}
+
+func (s *RethinkSuite) TestQueryHook(c *test.C) {
+	hook := &recordingHook{}
+	session.SetQueryHook(hook)
+	defer session.SetQueryHook(nil)
+
+	err := tbl4.Count().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = Expr(1).Div(0).Run(session).Exec()
+	c.Assert(err, test.NotNil)
+	// not sent, so not seen by the hook
+	err = tbl.Map(nil).Run(session).Exec()
+	c.Assert(err, test.NotNil)
+
+	c.Assert(hook.events, test.DeepEquals, []string{
+		`start r.db("test").table("table4").count()`,
+		`end r.db("test").table("table4").count()`,
+		`start r.expr(1).div(0)`,
+		`end r.expr(1).div(0)`,
+	})
+	c.Assert(hook.tokens[0], test.Equals, hook.tokens[1])
+	c.Assert(hook.tokens[2], test.Equals, hook.tokens[3])
+	c.Assert(hook.errs[0], test.IsNil)
+	c.Assert(hook.errs[1], test.ErrorMatches, ".*Cannot divide by zero.*")
+
+	// each query of a batch starts and ends on its own, in no particular order
+	*hook = recordingHook{}
+	results, err := RunBatch(session, map[string]Exp{
+		"one":    Expr(1),
+		"div":    Expr(1).Div(0),
+		"broken": tbl.Map(nil),
+	})
+	c.Assert(err, test.IsNil)
+	c.Assert(results["div"].Err(), test.NotNil)
+
+	// all of the queries are started before any of them end
+	c.Assert(hook.events, test.HasLen, 4)
+	starts := map[string]int64{}
+	ends := map[string]int64{}
+	errs := map[string]error{}
+	for i := 0; i < 2; i++ {
+		starts[strings.TrimPrefix(hook.events[i], "start ")] = hook.tokens[i]
+		query := strings.TrimPrefix(hook.events[2+i], "end ")
+		ends[query] = hook.tokens[2+i]
+		errs[query] = hook.errs[i]
+	}
+	c.Assert(starts, test.HasLen, 2)
+	c.Assert(ends, test.DeepEquals, starts)
+	c.Assert(errs["r.expr(1)"], test.IsNil)
+	c.Assert(errs["r.expr(1).div(0)"], test.ErrorMatches, ".*Cannot divide by zero.*")
+}
+
+func (s *RethinkSuite) TestEvalAll(c *test.C) {
+	results, err := EvalAll(session, []Exp{Expr(1).Add(2), Expr("a").Add("b"), tbl4.Count().Ge(0)})
+	c.Assert(err, test.IsNil)
+	c.Assert(len(results), test.Equals, 3)
+	c.Assert(string(results[0]), test.Equals, "3")
+	c.Assert(string(results[1]), test.Equals, `"ab"`)
+	c.Assert(string(results[2]), test.Equals, "true")
+
+	_, err = EvalAll(session, []Exp{Expr(1), Expr(1).Add("a")})
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestMaterializedView(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1, "n": 1}, Map{"id": 2, "n": 2}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	view, err := NewMaterializedView(session, tbl4, "id")
+	c.Assert(err, test.IsNil)
+	c.Assert(view.Len(), test.Equals, 2)
+	row, ok := view.Get(1)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(row["n"], test.Equals, 1.0)
+
+	var response WriteResponse
+	err = tbl4.Get(1).Update(Map{"n": 10}).ReturnValues().Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	view.Apply(Change{
+		OldValue: response.OldValue.(map[string]interface{}),
+		NewValue: response.NewValue.(map[string]interface{}),
+	})
+	row, _ = view.Get(1)
+	c.Assert(row["n"], test.Equals, 10.0)
+
+	// changes read from a query, these are for different rows so the order
+	// they are read in does not matter
+	err = tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	changes := List{
+		Map{"id": "a", "old_val": Map{"id": 2}, "new_val": nil},
+		Map{"id": "b", "old_val": nil, "new_val": Map{"id": 3, "n": 3}},
+	}
+	err = tbl4.Insert(changes).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = view.Follow(tbl4.Run(session))
+	c.Assert(err, test.IsNil)
+	_, ok = view.Get(2)
+	c.Assert(ok, test.Equals, false)
+	snapshot := view.Snapshot()
+	c.Assert(len(snapshot), test.Equals, 2)
+
+	// changing the snapshot leaves the view alone
+	snapshot[0]["n"] = 100
+	row, _ = view.Get(1)
+	c.Assert(row["n"], test.Equals, 10.0)
+
+	// primary keys can be arrays
+	view.Apply(Change{NewValue: map[string]interface{}{"id": []interface{}{"a", 1.0}, "n": 4.0}})
+	row, ok = view.Get(List{"a", 1})
+	c.Assert(ok, test.Equals, true)
+	c.Assert(row["n"], test.Equals, 4.0)
+
+	// a change that cannot be decoded stops following, and closes the rows
+	err = tbl4.Insert(Map{"id": "c", "new_val": 5}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	rows := tbl4.Run(session)
+	err = view.Follow(rows)
+	c.Assert(err, test.NotNil)
+	c.Assert(rows.Next(), test.Equals, false)
+}
+
+func (s *RethinkSuite) TestErrorInfo(c *test.C) {
+	err := Table("table_that_doesnt_exist").Run(session).Err()
+	c.Assert(err, test.NotNil)
+	info, ok := GetErrorInfo(err)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(info.Token > 0, test.Equals, true)
+	c.Assert(info.Attempt, test.Equals, 1)
+	c.Assert(info.RemoteAddr, test.Matches, ".*:28015")
+	c.Assert(info.Elapsed > 0, test.Equals, true)
+
+	_, ok = GetErrorInfo(ErrRowCount{})
+	c.Assert(ok, test.Equals, false)
+}
+
+func (s *RethinkSuite) TestChanges(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	// the feed reads from its connection until it is closed, so give it one of
+	// its own
+	feedSession, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	defer feedSession.Close()
+
+	rows := tbl4.Changes().Run(feedSession)
+	c.Assert(rows.Err(), test.IsNil)
+	c.Assert(rows.Type(), test.Equals, ResultFeed)
+
+	err = tbl4.Insert(Map{"id": 1, "name": "Jubilee"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	c.Assert(rows.Next(), test.Equals, true)
+	var change Change
+	err = rows.Scan(&change)
+	c.Assert(err, test.IsNil)
+	c.Assert(change.OldValue, test.IsNil)
+	c.Assert(change.NewValue["name"], test.Equals, "Jubilee")
+
+	// closing stops a .Next() that is waiting for a change
+	done := make(chan bool)
+	go func() {
+		done <- rows.Next()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rows.Close(), test.IsNil)
+	c.Assert(<-done, test.Equals, false)
+	c.Assert(rows.Next(), test.Equals, false)
+}
+
+func (s *RethinkSuite) TestConnectDialer(c *test.C) {
+	var dialed []string
+	dialer := func(ctx gocontext.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		// the address is only a name, the dialer decides where it leads
+		return net.Dial("tcp", "localhost:28015")
+	}
+	sess, err := ConnectWithOpts(ConnectOpts{Address: "fake", Database: "test", Dialer: dialer})
+	c.Assert(err, test.IsNil)
+	defer sess.Close()
+	c.Assert(dialed, test.DeepEquals, []string{"tcp fake"})
+
+	var result int
+	err = Expr(1).Add(2).Run(sess).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, 3)
+
+	failing := func(ctx gocontext.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no route")
+	}
+	_, err = ConnectWithOpts(ConnectOpts{Address: "fake", Dialer: failing})
+	c.Assert(err, test.ErrorMatches, "no route")
+}
+
+func (s *RethinkSuite) TestRowsPeek(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	rows := tbl4.OrderBy("id").Run(session)
+	var row Map
+	c.Assert(rows.Peek(&row), test.IsNil)
+	c.Assert(row["id"], test.Equals, 1.0)
+	c.Assert(rows.Index(), test.Equals, -1)
+
+	c.Assert(rows.Next(), test.Equals, true)
+	c.Assert(rows.Peek(&row), test.IsNil)
+	c.Assert(row["id"], test.Equals, 2.0)
+	// peeking twice reads the same row
+	c.Assert(rows.Peek(&row), test.IsNil)
+	c.Assert(row["id"], test.Equals, 2.0)
+	// the current row stays where it was
+	c.Assert(rows.Scan(&row), test.IsNil)
+	c.Assert(row["id"], test.Equals, 1.0)
+	c.Assert(rows.Index(), test.Equals, 0)
+
+	c.Assert(rows.Next(), test.Equals, true)
+	c.Assert(rows.Scan(&row), test.IsNil)
+	c.Assert(row["id"], test.Equals, 2.0)
+	c.Assert(rows.Index(), test.Equals, 1)
+
+	c.Assert(rows.Peek(&row), test.Equals, ErrNoMoreRows{})
+	c.Assert(rows.Next(), test.Equals, false)
+	c.Assert(rows.Err(), test.IsNil)
+}
+
+func (s *RethinkSuite) TestManifestVerify(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	backup := `{"id": 1, "name": "Wolverine", "stats": {"strength": 8.0}}
+{"id": 2, "name": "Storm"}
+`
+	manifest := NewManifest("id")
+	_, err = ImportNDJSON(session, tbl4, strings.NewReader(backup), ImportOpts{Manifest: manifest})
+	c.Assert(err, test.IsNil)
+	c.Assert(len(manifest.Hashes), test.Equals, 2)
+	c.Assert(Verify(session, tbl4, manifest), test.IsNil)
+
+	dumped, err := TableManifest(session, tbl4, "id")
+	c.Assert(err, test.IsNil)
+	c.Assert(dumped, test.DeepEquals, manifest)
+
+	err = tbl4.Get(1).Update(Map{"name": "Logan"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Get(2).Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": "3"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	err = Verify(session, tbl4, manifest)
+	c.Assert(err, test.DeepEquals, ManifestError{
+		Missing:    []string{"2"},
+		Changed:    []string{"1"},
+		Unexpected: []string{`"3"`},
+	})
+
+	// numbers are compared by value, without rounding large integers
+	_, one, err := manifest.hashRow(Map{"id": 1, "n": 1})
+	c.Assert(err, test.IsNil)
+	_, oneFloat, err := manifest.hashRow(Map{"id": 1, "n": 1.0})
+	c.Assert(err, test.IsNil)
+	c.Assert(oneFloat, test.Equals, one)
+	_, big, err := manifest.hashRow(Map{"id": 1, "n": int64(1<<53 + 1)})
+	c.Assert(err, test.IsNil)
+	_, bigRounded, err := manifest.hashRow(Map{"id": 1, "n": int64(1 << 53)})
+	c.Assert(err, test.IsNil)
+	c.Assert(big, test.Not(test.Equals), bigRounded)
+}
+
+func (s *RethinkSuite) TestSoftDelete(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var response WriteResponse
+	err = tbl4.Get(1).SoftDelete().Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Replaced, test.Equals, 1)
+
+	var row struct {
+		Id        int
+		DeletedAt time.Time `json:"deleted_at"`
+	}
+	err = tbl4.Get(1).Run(session).One(&row)
+	c.Assert(err, test.IsNil)
+	c.Assert(time.Since(row.DeletedAt) < time.Minute, test.Equals, true)
+
+	var ids []int
+	err = tbl4.WithoutDeleted().Map(Row.Attr("id")).Run(session).All(&ids)
+	c.Assert(err, test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{2})
+	err = tbl4.OnlyDeleted().Map(Row.Attr("id")).Run(session).All(&ids)
+	c.Assert(err, test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{1})
+
+	err = tbl4.OnlyDeleted().RestoreDeleted().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	var count int
+	err = tbl4.WithoutDeleted().Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 2)
+}
+
+func (s *RethinkSuite) TestRetry(c *test.C) {
+	sess, err := ConnectWithOpts(ConnectOpts{Address: "localhost:28015", Database: "test", MaxRetries: 2})
+	c.Assert(err, test.IsNil)
+	defer sess.Close()
+
+	// drop the connection under the session
+	sess.conn.Conn.Close()
+	var result int
+	err = Expr(1).Add(2).Run(sess).One(&result)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, 3)
+
+	// writes are not run again
+	sess.conn.Conn.Close()
+	err = tbl4.Insert(Map{"id": 1}).Run(sess).Exec()
+	c.Assert(err, test.NotNil)
+
+	sess.SetMaxRetries(0)
+	sess.Reconnect()
+	sess.conn.Conn.Close()
+	err = Expr(1).Run(sess).Exec()
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestNilEncoding(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type Hero struct {
+		Id      int               `json:"id"`
+		Powers  []string          `json:"powers"`
+		Stats   map[string]int    `json:"stats"`
+		Photo   []byte            `json:"photo"`
+		Aliases []string          `json:"aliases,omitempty"`
+		Extra   map[string]string `json:"extra,omitempty"`
+	}
+	err = tbl4.Insert(Hero{Id: 1}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	SetNilEncoding(NilAsNull)
+	err = tbl4.Insert(Hero{Id: 2}).Run(session).Exec()
+	SetNilEncoding(NilAsEmpty)
+	c.Assert(err, test.IsNil)
+
+	var rows []map[string]interface{}
+	err = tbl4.OrderBy("id").Run(session).All(&rows)
+	c.Assert(err, test.IsNil)
+	c.Assert(rows, test.HasLen, 2)
+	c.Assert(rows[0], test.DeepEquals, map[string]interface{}{
+		"id":     1.0,
+		"powers": []interface{}{},
+		"stats":  map[string]interface{}{},
+		// binary data is decoded as base64
+		"photo": "",
+	})
+	c.Assert(rows[1], test.DeepEquals, map[string]interface{}{
+		"id":     2.0,
+		"powers": nil,
+		"stats":  nil,
+		"photo":  nil,
+	})
+
+	var typeName string
+	err = Expr([]byte(nil)).TypeOf().Run(session).One(&typeName)
+	c.Assert(err, test.IsNil)
+	c.Assert(typeName, test.Equals, "PTYPE<BINARY>")
+}
+
+func (s *RethinkSuite) TestDateHelpers(c *test.C) {
+	battle := epochTime(time.Date(2013, 5, 17, 14, 35, 12, 0, time.UTC))
+	pacific := time.FixedZone("", -7*60*60)
+
+	tests := []struct {
+		query    Exp
+		expected time.Time
+	}{
+		{battle.AddDuration(90 * time.Minute), time.Date(2013, 5, 17, 16, 5, 12, 0, time.UTC)},
+		{battle.AddDays(-20), time.Date(2013, 4, 27, 14, 35, 12, 0, time.UTC)},
+		{battle.StartOfDay("-07:00"), time.Date(2013, 5, 17, 0, 0, 0, 0, pacific)},
+		{battle.StartOfDay("+10:00"), time.Date(2013, 5, 18, 0, 0, 0, 0, time.FixedZone("", 10*60*60))},
+		{battle.TruncateTo("minute"), time.Date(2013, 5, 17, 14, 35, 0, 0, time.UTC)},
+		{battle.TruncateTo("hour"), time.Date(2013, 5, 17, 14, 0, 0, 0, time.UTC)},
+		{battle.TruncateTo("day"), time.Date(2013, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{battle.TruncateTo("month"), time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{battle.TruncateTo("year"), time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, t := range tests {
+		var result time.Time
+		err := t.query.Run(session).One(&result)
+		c.Assert(err, test.IsNil)
+		c.Assert(result.Equal(t.expected), test.Equals, true, test.Commentf("%v != %v", result, t.expected))
+	}
+
+	c.Assert(func() { battle.TruncateTo("week") }, test.PanicMatches, ".*unit must be one of.*")
+}
+
+func (s *RethinkSuite) TestWireCapture(c *test.C) {
+	var capture bytes.Buffer
+	SetWireCapture(&capture)
+	err := Expr(1).Run(session).Exec()
+	SetWireCapture(nil)
+	c.Assert(err, test.IsNil)
+
+	var directions []byte
+	for capture.Len() > 0 {
+		direction, err := capture.ReadByte()
+		c.Assert(err, test.IsNil)
+		directions = append(directions, direction)
+		var length uint32
+		err = binary.Read(&capture, binary.LittleEndian, &length)
+		c.Assert(err, test.IsNil)
+		data := capture.Next(int(length))
+		c.Assert(data, test.HasLen, int(length))
+
+		if direction == '>' {
+			query := &p.Query{}
+			c.Assert(proto.Unmarshal(data, query), test.IsNil)
+			c.Assert(query.GetType(), test.Equals, p.Query_START)
+		} else {
+			response := &p.Response{}
+			c.Assert(proto.Unmarshal(data, response), test.IsNil)
+			c.Assert(response.GetType(), test.Equals, p.Response_SUCCESS_ATOM)
+		}
+	}
+	c.Assert(string(directions), test.Equals, "><")
+}
+
+func (s *RethinkSuite) TestMaxRows(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}, Map{"id": 3}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var rows []Map
+	err = tbl4.RunWith(session, RunOpts{MaxRows: 2}).All(&rows)
+	c.Assert(err, test.Equals, ErrTooManyRows{Limit: 2})
+	c.Assert(rows, test.IsNil)
+
+	err = tbl4.RunWith(session, RunOpts{MaxRows: 3}).All(&rows)
+	c.Assert(err, test.IsNil)
+	c.Assert(rows, test.HasLen, 3)
+
+	var numbers []int
+	err = Expr(List{1, 2, 3}).RunWith(session, RunOpts{MaxRows: 2}).All(&numbers)
+	c.Assert(err, test.Equals, ErrTooManyRows{Limit: 2})
+}
+
+func (s *RethinkSuite) TestRowsProfile(c *test.C) {
+	rows := tbl4.Count().Run(session)
+	profile, err := rows.Profile()
+	c.Assert(err, test.IsNil)
+	c.Assert(profile, test.IsNil)
+
+	rows = tbl4.Count().RunWith(session, RunOpts{Profile: Bool(true)})
+	c.Assert(rows.Err(), test.IsNil)
+	profile, err = rows.Profile()
+	c.Assert(err, test.IsNil)
+	c.Assert(len(profile) > 0, test.Equals, true)
+	c.Assert(profile[0].Description, test.Not(test.Equals), "")
+
+	var count int
+	c.Assert(rows.One(&count), test.IsNil)
+}
+
+func (s *RethinkSuite) TestDecodeInto(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1, "name": "Rogue"}, Map{"id": 2, "name": "Gambit"}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var names []string
+	var count int
+	query := Expr(Map{
+		"names": tbl4.OrderBy("name").Map(Row.Attr("name")),
+		"count": tbl4.Count(),
+	})
+	err = query.Run(session).DecodeInto(map[string]interface{}{"names": &names, "count": &count})
+	c.Assert(err, test.IsNil)
+	c.Assert(names, test.DeepEquals, []string{"Gambit", "Rogue"})
+	c.Assert(count, test.Equals, 2)
+
+	err = query.Run(session).DecodeInto(map[string]interface{}{"missing": &count})
+	c.Assert(err, test.ErrorMatches, `.*no field "missing"`)
+
+	err = query.Run(session).DecodeInto(map[string]interface{}{"names": &count})
+	_, ok := err.(DecodeError)
+	c.Assert(ok, test.Equals, true)
+}
+
+func (s *RethinkSuite) TestRunWrite(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	response, err := tbl4.Insert(List{Map{"name": "Cyclops"}, Map{"id": 1}}).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 2)
+	c.Assert(response.GeneratedKeys, test.HasLen, 1)
+
+	response, err = tbl4.Insert(Map{"id": 1}).RunWrite(session)
+	c.Assert(err, test.FitsTypeOf, WriteError{})
+	c.Assert(response.Errors, test.Equals, 1)
+
+	response, err = tbl4.Get(2).Delete().RunWrite(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Skipped, test.Equals, 1)
+}
+
+func (s *RethinkSuite) TestRowsChan(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}, Map{"id": 3}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type row struct {
+		Id int
+	}
+	rows := make(chan row)
+	stop := tbl4.OrderBy("id").Run(session).Chan(rows)
+	var ids []int
+	for r := range rows {
+		ids = append(ids, r.Id)
+	}
+	c.Assert(stop(), test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{1, 2, 3})
+
+	// stopping early closes the channel
+	rows = make(chan row)
+	stop = tbl4.OrderBy("id").Run(session).Chan(rows)
+	c.Assert((<-rows).Id, test.Equals, 1)
+	c.Assert(stop(), test.IsNil)
+	_, open := <-rows
+	c.Assert(open, test.Equals, false)
+
+	// decode errors end the reading
+	names := make(chan string)
+	stop = tbl4.Run(session).Chan(names)
+	_, open = <-names
+	c.Assert(open, test.Equals, false)
+	_, ok := stop().(DecodeError)
+	c.Assert(ok, test.Equals, true)
+
+	c.Assert(func() { tbl4.Run(session).Chan(ids) }, test.PanicMatches, ".*needs a channel.*")
+}
+
+func (s *RethinkSuite) TestBuildErrorPath(c *test.C) {
+	err := tbl.Filter(func(row Exp) Exp {
+		return row.Attr("name").Add(Expr("!").Durability("soft"))
+	}).Check(session)
+	c.Assert(err, test.DeepEquals, BuildError{
+		Message: ".Durability() can only be used directly after a write such as .Insert()",
+		Path:    []string{"FILTER arg 1", "FUNC arg 1", "ADD arg 1"},
+	})
+	c.Assert(err, test.ErrorMatches, ".*, at FILTER arg 1 > FUNC arg 1 > ADD arg 1")
+
+	err = Expr(1).Durability("soft").Check(session)
+	c.Assert(err, test.DeepEquals, BuildError{
+		Message: ".Durability() can only be used directly after a write such as .Insert()",
+	})
+}
+
+func (s *RethinkSuite) TestValidate(c *test.C) {
+	c.Assert(tbl.Map(func(row Exp) Exp { return row.Attr("name") }).Validate(), test.IsNil)
+	c.Assert(tbl4.Get(1).Replace(nil).Validate(), test.IsNil)
+
+	err := tbl.Map(func(a, b Exp) Exp { return a }).Validate()
+	c.Assert(err, test.FitsTypeOf, BuildError{})
+	c.Assert(err.(BuildError).Path, test.DeepEquals, []string{"MAP arg 1"})
+	c.Assert(err, test.ErrorMatches, ".*should take 1 argument.*")
+
+	var mapping func(Exp) Exp
+	err = tbl.Map(mapping).Validate()
+	c.Assert(err, test.ErrorMatches, ".*a function is needed here, not a nil.*, at MAP arg 1")
+	err = tbl.Filter(func(age int) Exp { return Expr(true) }).Validate()
+	c.Assert(err, test.ErrorMatches, ".*argument 1 of type int, it should be r.Exp.*")
+
+	err = tbl4.Insert(Map{"id": 1, "done": make(chan int)}).Validate()
+	c.Assert(err, test.ErrorMatches, ".*type chan int cannot be sent.*, at INSERT arg 1")
+	err = Expr(map[int]string{1: "one"}).Validate()
+	c.Assert(err, test.ErrorMatches, ".*only maps with string keys.*")
+
+	// nil is only a mistake where a function is needed
+	var indexes []int
+	err = Expr(List{1, nil, 2, nil}).IndexesOf(nil).Run(session).All(&indexes)
+	c.Assert(err, test.IsNil)
+	c.Assert(indexes, test.DeepEquals, []int{1, 3})
+	err = Do(1, nil).Validate()
+	c.Assert(err, test.ErrorMatches, ".*a function is needed here, not nil, at FUNCALL arg 0")
+}
+
+func (s *RethinkSuite) TestString(c *test.C) {
+	query := tbl.Filter(Row.Attr("speed").Gt(5)).Count()
+	c.Assert(query.String(), test.Equals, `r.table("table1").filter(r.row.getField("speed").gt(5)).count()`)
+
+	query = Db("marvel").Table("heroes").GetAll("name", "Iron Man").Map(func(a Exp) Exp {
+		return Do(a.Attr("speed"), func(b Exp) Exp { return b.Add(a.Attr("strength")) })
+	})
+	c.Assert(fmt.Sprint(query), test.Equals, `r.db("marvel").table("heroes").getAll("Iron Man", {index: "name"})`+
+		`.map(func(var1) { return r.do(var1.getField("speed"), func(var2) { return var2.add(var1.getField("strength")) }) })`)
+
+	query = tbl4.Insert(Map{"id": 1, "tags": List{"a", Row.Attr("b")}}).Durability("soft")
+	c.Assert(query.String(), test.Equals, `r.table("table4").insert({"id": 1, "tags": ["a", r.row.getField("b")]}, {durability: "soft", upsert: false})`)
+
+	c.Assert(tbl.Map(nil).String(), test.Equals, "<rethinkdb: a function is needed here, not nil, at MAP arg 1>")
+}
+
+func (s *RethinkSuite) TestStrict(c *test.C) {
+	c.Assert(tbl4.Insert(Map{"id": 1}).Overwrite(true).Check(session), test.IsNil)
+
+	session.SetStrict(true)
+	defer session.SetStrict(false)
+
+	err := tbl4.Insert(Map{"id": 1}).Overwrite(true).Check(session)
+	c.Assert(err, test.ErrorMatches, `.*\.Overwrite\(\) sets upsert.*use \.InsertWith\(\).*`)
+	err = tbl4.InsertWith(InsertOpts{Conflict: "replace"}, Map{"id": 1}).Check(session)
+	c.Assert(err, test.IsNil)
+
+	// options chained after the legacy one are looked through
+	err = tbl4.Insert(Map{"id": 1}).Overwrite(true).Durability("soft").Check(session)
+	c.Assert(err, test.ErrorMatches, `.*\.Overwrite\(\).*`)
+
+	err = Expr(List{tbl4.GroupBy("name", Count())}).Check(session)
+	c.Assert(err, test.FitsTypeOf, BuildError{})
+	c.Assert(err.(BuildError).Path, test.DeepEquals, []string{"MAKE_ARRAY arg 0"})
+
+	err = tbl4.Get(1).UpdateWithOpts(Map{"a": 1}, UpdateOpts{ReturnValues: true}).Check(session)
+	c.Assert(err, test.IsNil)
+	err = tbl4.Get(1).Update(Map{"a": 1}).ReturnValues().Check(session)
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestGeo(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = EnsureIndexes(session, "table4", []IndexSpec{{Name: "location", Geo: true}})
+	c.Assert(err, test.IsNil)
+	defer tbl4.IndexDrop("location").Run(session).Exec()
+
+	hideouts := List{
+		Map{"id": 1, "location": Point(-73.99, 40.75)},
+		Map{"id": 2, "location": Point(-73.98, 40.76)},
+		Map{"id": 3, "location": Point(-122.4, 37.7)},
+	}
+	err = tbl4.Insert(hideouts...).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var distance float64
+	err = Point(0, 0).Distance(Point(0, 1)).Unit("km").Run(session).One(&distance)
+	c.Assert(err, test.IsNil)
+	c.Assert(distance > 110 && distance < 112, test.Equals, true)
+
+	var included bool
+	square := Polygon(List{0, 0}, List{0, 2}, List{2, 2}, List{2, 0})
+	err = square.Includes(Point(1, 1)).Run(session).One(&included)
+	c.Assert(err, test.IsNil)
+	c.Assert(included, test.Equals, true)
+	err = Line(Point(-1, 1), Point(3, 1)).Intersects(square).Run(session).One(&included)
+	c.Assert(err, test.IsNil)
+	c.Assert(included, test.Equals, true)
+
+	var ids []int
+	nearby := Circle(Point(-73.99, 40.75), 5).Unit("km")
+	err = tbl4.GetIntersecting("location", nearby).OrderBy("id").Map(Row.Attr("id")).Run(session).All(&ids)
+	c.Assert(err, test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{1, 2})
+
+	var nearest []struct {
+		Dist float64
+		Doc  struct{ Id int }
+	}
+	err = tbl4.GetNearest("location", Point(-122.5, 37.7)).MaxResults(2).Unit("km").Run(session).All(&nearest)
+	c.Assert(err, test.IsNil)
+	c.Assert(nearest, test.HasLen, 2)
+	c.Assert(nearest[0].Doc.Id, test.Equals, 3)
+	c.Assert(nearest[0].Dist < nearest[1].Dist, test.Equals, true)
+}
+
+func (s *RethinkSuite) TestRunShared(c *test.C) {
+	shared := NewSharedReads()
+	// takes long enough for the second query to start while it runs, and gives
+	// a different answer each time it is actually run
+	slowRandom := func() Exp {
+		return Js(`var start = Date.now(); while (Date.now() - start < 300) {}; Math.random()`)
+	}
+
+	otherSession, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	defer otherSession.Close()
+
+	first := make(chan float64)
+	go func() {
+		var value float64
+		slowRandom().RunShared(otherSession, shared).One(&value)
+		first <- value
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	var second float64
+	err = slowRandom().RunShared(session, shared).One(&second)
+	c.Assert(err, test.IsNil)
+	c.Assert(<-first, test.Equals, second)
+
+	// once the query has finished, it runs again
+	var third float64
+	err = slowRandom().RunShared(session, shared).One(&third)
+	c.Assert(err, test.IsNil)
+	c.Assert(third, test.Not(test.Equals), second)
+
+	// writes are not shared
+	err = tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	var response WriteResponse
+	err = tbl4.Insert(Map{"id": 1}).RunShared(session, shared).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 1)
+}
+
+func (s *RethinkSuite) TestSpillToDisk(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	var heroes List
+	for i := 0; i < 50; i++ {
+		heroes = append(heroes, Map{"id": i})
+	}
+	err = tbl4.Insert(heroes...).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	dir := c.MkDir()
+	rows := tbl4.OrderBy("id").RunWith(session, RunOpts{MaxBatchRows: 10})
+	c.Assert(rows.Next(), test.Equals, true)
+	c.Assert(rows.SpillToDisk(dir), test.IsNil)
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, test.IsNil)
+	c.Assert(files, test.HasLen, 1)
+
+	// the current row is kept and the rest come from the file
+	var hero Map
+	c.Assert(rows.Scan(&hero), test.IsNil)
+	c.Assert(hero["id"], test.Equals, float64(0))
+	var ids []int
+	for rows.Next() {
+		c.Assert(rows.Scan(&hero), test.IsNil)
+		ids = append(ids, int(hero["id"].(float64)))
+	}
+	c.Assert(rows.Err(), test.IsNil)
+	c.Assert(ids, test.HasLen, 49)
+	c.Assert(ids[48], test.Equals, 49)
+	c.Assert(rows.Index(), test.Equals, 49)
+
+	files, err = ioutil.ReadDir(dir)
+	c.Assert(err, test.IsNil)
+	c.Assert(files, test.HasLen, 0)
+}
+
+func (s *RethinkSuite) TestRandom(c *test.C) {
+	var uuids []string
+	err := Expr(List{UUID(), UUID()}).Run(session).One(&uuids)
+	c.Assert(err, test.IsNil)
+	c.Assert(uuids[0], test.Matches, "[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}")
+	c.Assert(uuids[0], test.Not(test.Equals), uuids[1])
+
+	for i := 0; i < 10; i++ {
+		var number float64
+		err = Random(1, 7).Run(session).One(&number)
+		c.Assert(err, test.IsNil)
+		c.Assert(number >= 1 && number < 7 && number == float64(int(number)), test.Equals, true)
+
+		err = Random(3).Run(session).One(&number)
+		c.Assert(err, test.IsNil)
+		c.Assert(number >= 0 && number < 3, test.Equals, true)
+
+		err = Random().Run(session).One(&number)
+		c.Assert(err, test.IsNil)
+		c.Assert(number >= 0 && number < 1, test.Equals, true)
+
+		err = RandomFloat(-1, 1).Run(session).One(&number)
+		c.Assert(err, test.IsNil)
+		c.Assert(number >= -1 && number < 1, test.Equals, true)
+	}
+}
+
+func (s *RethinkSuite) TestFederation(c *test.C) {
+	latency, err := session.Ping()
+	c.Assert(err, test.IsNil)
+	c.Assert(latency > 0, test.Equals, true)
+
+	primary, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	var farConn net.Conn
+	far, err := ConnectWithOpts(ConnectOpts{
+		Address:  "far",
+		Database: "test",
+		Dialer: func(ctx gocontext.Context, network, address string) (net.Conn, error) {
+			conn, err := net.Dial("tcp", "localhost:28015")
+			farConn = conn
+			return conn, err
+		},
+	})
+	c.Assert(err, test.IsNil)
+
+	federation, err := NewFederation("primary", map[string]*Session{"primary": primary, "far": far})
+	c.Assert(err, test.IsNil)
+	defer federation.Close()
+	status := federation.Status()
+	c.Assert(status, test.HasLen, 2)
+	c.Assert(status[0].Name, test.Equals, "far")
+	c.Assert(status[0].Healthy, test.Equals, true)
+	c.Assert(status[1].Primary, test.Equals, true)
+
+	// the far region goes down
+	farConn.Close()
+	federation.Probe()
+	status = federation.Status()
+	c.Assert(status[0].Healthy, test.Equals, false)
+	c.Assert(status[0].Err, test.NotNil)
+
+	err = tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	var response WriteResponse
+	err = federation.Run(tbl4.Insert(Map{"id": 1})).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 1)
+	var count int
+	err = federation.Run(tbl4.Count()).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	// and comes back
+	federation.Probe()
+	c.Assert(federation.Status()[0].Healthy, test.Equals, true)
+
+	_, err = NewFederation("nowhere", map[string]*Session{"primary": primary})
+	c.Assert(err, test.NotNil)
+}
+
+func (s *RethinkSuite) TestIndexSpecKeys(c *test.C) {
+	type hero struct {
+		Name   string   `json:"name"`
+		Powers []string `json:"powers"`
+	}
+	storm := hero{Name: "Storm", Powers: []string{"weather", "flight"}}
+
+	keys, err := IndexSpec{Name: "name"}.Keys(storm)
+	c.Assert(err, test.IsNil)
+	c.Assert(keys, test.DeepEquals, []interface{}{"Storm"})
+
+	keys, err = IndexSpec{Name: "powers", Multi: true}.Keys(storm)
+	c.Assert(err, test.IsNil)
+	c.Assert(keys, test.DeepEquals, []interface{}{"weather", "flight"})
+
+	initial := IndexSpec{Name: "initial", KeyFunc: func(row map[string]interface{}) interface{} {
+		return row["name"].(string)[:1]
+	}}
+	keys, err = initial.Keys(Map{"name": "Storm"})
+	c.Assert(err, test.IsNil)
+	c.Assert(keys, test.DeepEquals, []interface{}{"S"})
+
+	keys, err = IndexSpec{Name: "missing"}.Keys(storm)
+	c.Assert(err, test.IsNil)
+	c.Assert(keys, test.HasLen, 0)
+}
+
+func (s *RethinkSuite) TestEnsureIndexesDrift(c *test.C) {
+	err := EnsureIndexes(session, "table4", []IndexSpec{{Name: "powers"}})
+	c.Assert(err, test.IsNil)
+	defer tbl4.IndexDrop("powers").Run(session).Exec()
+
+	err = EnsureIndexes(session, "table4", []IndexSpec{{Name: "powers", Multi: true}})
+	c.Assert(err, test.FitsTypeOf, IndexDriftError{})
+	c.Assert(err.(IndexDriftError).Differences["powers"], test.Equals, "multi is false on the server")
+
+	// the index is left alone
+	err = EnsureIndexes(session, "table4", []IndexSpec{{Name: "powers"}})
+	c.Assert(err, test.IsNil)
+}
+
+func (s *RethinkSuite) TestIndexFunctionDrift(c *test.C) {
+	query := func(text string) *string { return &text }
+	specs := []IndexSpec{
+		{Name: "name"},
+		{Name: "awesomeness", Function: func(hero Exp) Exp {
+			return hero.Attr("speed").Mul(hero.Attr("strength"))
+		}},
+		{Name: "speed", Function: Row.Attr("speed").Add(1)},
+	}
+	// the way the server writes out the queries, with its own variables
+	statuses := []indexStatus{
+		{Index: "name", Query: query(`indexCreate('name', function(_var1) { return _var1("name"); })`)},
+		{Index: "awesomeness", Query: query(`indexCreate('awesomeness', function(var17) { return var17('speed').mul(var17("strength")); })`)},
+		{Index: "speed", Query: query(`indexCreate('speed', function(_var3) { return _var3("speed").add(1); }, {multi: false})`)},
+	}
+	c.Assert(checkIndexDrift("heroes", specs, statuses), test.IsNil)
+
+	statuses[1].Query = query(`indexCreate('awesomeness', function(var17) { return var17('speed').add(var17("strength")); })`)
+	err := checkIndexDrift("heroes", specs, statuses)
+	c.Assert(err, test.FitsTypeOf, IndexDriftError{})
+	c.Assert(err.(IndexDriftError).Differences, test.DeepEquals, map[string]string{
+		"awesomeness": `function is indexCreate('awesomeness', function(var17) { return var17('speed').add(var17("strength")); }) on the server`,
+	})
+
+	// older servers do not report the query
+	statuses[1].Query = nil
+	c.Assert(checkIndexDrift("heroes", specs, statuses), test.IsNil)
+}
+
+func (s *RethinkSuite) TestGroupedDecode(c *test.C) {
+	var counts map[int]int
+	err := gobj.Group("g1").Count().Run(session).One(&counts)
+	c.Assert(err, test.IsNil)
+	c.Assert(counts, test.DeepEquals, map[int]int{1: 3, 2: 2})
+
+	var byPair map[string]int
+	err = gobj.Group("g1", "g2").Count().Run(session).One(&byPair)
+	c.Assert(err, test.IsNil)
+	c.Assert(byPair, test.DeepEquals, map[string]int{"[1,1]": 1, "[1,2]": 2, "[2,3]": 2})
+
+	names := Expr(List{Map{"name": "Storm", "team": "X-Men"}, Map{"name": "Thor", "team": "Avengers"}})
+	var teams map[string][]string
+	err = names.Group("team").Map(Row.Attr("name")).Run(session).One(&teams)
+	c.Assert(err, test.IsNil)
+	c.Assert(teams, test.DeepEquals, map[string][]string{"X-Men": {"Storm"}, "Avengers": {"Thor"}})
+
+	var groups []GroupResult
+	err = gobj.Group("g1").Max("num").Run(session).All(&groups)
+	c.Assert(err, test.IsNil)
+	c.Assert(groups, test.HasLen, 2)
+	c.Assert(groups[1].Group, test.Equals, float64(2))
+	var row struct{ Num int }
+	c.Assert(groups[1].Decode(&row), test.IsNil)
+	c.Assert(row.Num, test.Equals, 100)
+}
+
+func (s *RethinkSuite) TestTablePrefix(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	session.SetTablePrefix("tab")
+	defer session.SetTablePrefix("")
+
+	var count int
+	err = Table("le4").Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	err = EnsureTable(session, TableSpec{Name: "le4"})
+	c.Assert(err, test.IsNil)
+
+	// system tables keep their names
+	_, err = Jobs(session)
+	c.Assert(err, test.IsNil)
+	query, err := session.getContext().buildProtobuf(Db("rethinkdb").Table("jobs"))
+	c.Assert(err, test.IsNil)
+	c.Assert(termString(query.GetQuery()), test.Equals, `TABLE(DB("rethinkdb"), "jobs")`)
+
+	session.SetTableResolver(func(name string) string {
+		if name == "heroes" {
+			return "table4"
+		}
+		return name
+	})
+	err = Table("heroes").Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+}
+
+func (s *RethinkSuite) TestTransact(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1, "balance": 100, "reserved": 0}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	reserve := func(doc map[string]interface{}) (interface{}, error) {
+		if doc["balance"].(float64) < 60 {
+			return nil, fmt.Errorf("insufficient funds")
+		}
+		doc["balance"] = doc["balance"].(float64) - 60
+		doc["reserved"] = doc["reserved"].(float64) + 60
+		return doc, nil
+	}
+	response, err := tbl4.Get(1).Transact(session, 3, reserve)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Replaced, test.Equals, 1)
+	_, err = tbl4.Get(1).Transact(session, 3, reserve)
+	c.Assert(err, test.ErrorMatches, "insufficient funds")
+
+	// another write lands between every read and write
+	calls := 0
+	_, err = tbl4.Get(1).Transact(session, 2, func(doc map[string]interface{}) (interface{}, error) {
+		calls++
+		err := tbl4.Get(1).Update(Map{"balance": Row.Attr("balance").Add(1)}).Run(session).Exec()
+		c.Assert(err, test.IsNil)
+		return doc, nil
+	})
+	c.Assert(err, test.Equals, TransactionConflictError{Attempts: 3})
+	c.Assert(calls, test.Equals, 3)
+
+	var doc map[string]int
+	err = tbl4.Get(1).Run(session).One(&doc)
+	c.Assert(err, test.IsNil)
+	c.Assert(doc, test.DeepEquals, map[string]int{"id": 1, "balance": 43, "reserved": 60})
+
+	_, err = tbl4.Get(2).Transact(session, 0, func(doc map[string]interface{}) (interface{}, error) {
+		c.Assert(doc, test.IsNil)
+		return Map{"id": 2}, nil
+	})
+	c.Assert(err, test.IsNil)
+}
+
+func (s *RethinkSuite) TestTimeRoundTrip(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type Battle struct {
+		Id     int        `json:"id"`
+		Start  time.Time  `json:"start"`
+		Finish *time.Time `json:"finish"`
+	}
+	pacific := time.FixedZone("", -7*60*60)
+	start := time.Date(2013, 5, 17, 14, 35, 12, 250e6, pacific)
+	finish := start.Add(90 * time.Minute).UTC()
+	err = tbl4.Insert(Battle{Id: 1, Start: start, Finish: &finish}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var types []string
+	err = Do(tbl4.Get(1), func(row Exp) Exp {
+		return Expr(List{row.Attr("start").TypeOf(), row.Attr("finish").TypeOf()})
+	}).Run(session).One(&types)
+	c.Assert(err, test.IsNil)
+	c.Assert(types, test.DeepEquals, []string{"PTYPE<TIME>", "PTYPE<TIME>"})
+
+	var battle Battle
+	err = tbl4.Get(1).Run(session).One(&battle)
+	c.Assert(err, test.IsNil)
+	c.Assert(battle.Start.Equal(start), test.Equals, true, test.Commentf("%v != %v", battle.Start, start))
+	_, offset := battle.Start.Zone()
+	c.Assert(offset, test.Equals, -7*60*60)
+	c.Assert(battle.Finish.Equal(finish), test.Equals, true)
+
+	var count int
+	err = tbl4.Filter(Row.Attr("start").Lt(Expr(Map{"when": finish}).Attr("when"))).Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+}
+
+func (s *RethinkSuite) TestBinary(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type File struct {
+		Id       int    `json:"id"`
+		Contents []byte `json:"contents"`
+	}
+	contents := []byte{0, 1, 2, 254, 255}
+	err = tbl4.Insert(File{Id: 1, Contents: contents}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var typeName string
+	err = tbl4.Get(1).Attr("contents").TypeOf().Run(session).One(&typeName)
+	c.Assert(err, test.IsNil)
+	c.Assert(typeName, test.Equals, "PTYPE<BINARY>")
+
+	var file File
+	err = tbl4.Get(1).Run(session).One(&file)
+	c.Assert(err, test.IsNil)
+	c.Assert(file.Contents, test.DeepEquals, contents)
+
+	var count int
+	err = Binary(contents).Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 5)
+
+	var hello []byte
+	err = Binary(Expr("hello")).Run(session).One(&hello)
+	c.Assert(err, test.IsNil)
+	c.Assert(string(hello), test.Equals, "hello")
+
+	// servers without binary data are sent base64 strings, as they used to be
+	file = File{Id: 2, Contents: contents}
+	old := context{serverVersion: serverVersion{1, 16, 0}}
+	query, err := old.buildProtobuf(Expr(List{file, Map{"contents": contents}}))
+	c.Assert(err, test.IsNil)
+	c.Assert(queryText(query.GetQuery()), test.Equals, `[{"contents":"AAEC/v8=","id":2},{"contents":"AAEC/v8="}]`)
+	opts := InsertOpts{Computed: map[string]func(doc Map) interface{}{
+		"size": func(doc Map) interface{} { return 5 },
+	}}
+	query, err = old.buildProtobuf(Table("files").InsertWith(opts, file))
+	c.Assert(err, test.IsNil)
+	c.Assert(queryText(query.GetQuery()), test.Equals, `r.table("files").insert({"contents": "AAEC/v8=", "id": 2, "size": 5}, {upsert: false})`)
+	query, err = context{}.buildProtobuf(Expr(Map{"contents": contents}))
+	c.Assert(err, test.IsNil)
+	c.Assert(queryText(query.GetQuery()), test.Equals, `{"contents": {"$reql_type$":"BINARY","data":"AAEC/v8="}}`)
+}
+
+func (s *RethinkSuite) TestTimeParts(c *test.C) {
+	pacific := time.FixedZone("", -7*60*60)
+	battle := Expr(time.Date(2013, 5, 19, 14, 35, 12, 500e6, pacific))
+
+	tests := []struct {
+		query    Exp
+		expected interface{}
+	}{
+		{battle.Year(), 2013},
+		{battle.Month(), 5},
+		{battle.Day(), 19},
+		{battle.DayOfWeek(), 7},
+		{battle.DayOfYear(), 139},
+		{battle.Hours(), 14},
+		{battle.Minutes(), 35},
+		{battle.Seconds(), 12.5},
+		{battle.TimeOfDay(), 14*60*60 + 35*60 + 12.5},
+		{battle.Timezone(), "-07:00"},
+		{battle.InTimezone("+00:00").Hours(), 21},
+		{battle.Date().TimeOfDay(), 0},
+		{battle.ToEpochTime(), 1368999312.5},
+		{battle.ToISO8601(), "2013-05-19T14:35:12.500-07:00"},
+		{battle.During(time.Date(2013, 5, 19, 0, 0, 0, 0, pacific), time.Date(2013, 5, 20, 0, 0, 0, 0, pacific)), true},
+		{battle.During(battle, battle.AddDays(1)), true},
+		{battle.During(battle, battle.AddDays(1)).LeftBound("open"), false},
+		{battle.During(battle.AddDays(-1), battle).RightBound("closed"), true},
+	}
+	for _, t := range tests {
+		var result interface{}
+		err := t.query.Run(session).One(&result)
+		c.Assert(err, test.IsNil)
+		expected := t.expected
+		if n, ok := expected.(int); ok {
+			expected = float64(n)
+		}
+		c.Assert(result, test.Equals, expected, test.Commentf("%v", t.query))
+	}
+}
+
+func (s *RethinkSuite) TestRowsReduce(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	docs := List{}
+	for i := 0; i < 10; i++ {
+		docs = append(docs, Map{"id": i, "num": i * i})
+	}
+	err = tbl4.Insert(docs).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	sum := func(acc interface{}, row json.RawMessage) (interface{}, error) {
+		var doc struct{ Num int }
+		if err := json.Unmarshal(row, &doc); err != nil {
+			return nil, err
+		}
+		return acc.(int) + doc.Num, nil
+	}
+	result, err := tbl4.RunWith(session, RunOpts{MaxBatchRows: 3}).Reduce(0, sum)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, 285)
+
+	result, err = Expr(List{Map{"num": 1}, Map{"num": 2}}).Run(session).Reduce(10, sum)
+	c.Assert(err, test.IsNil)
+	c.Assert(result, test.Equals, 13)
+
+	calls := 0
+	rows := tbl4.RunWith(session, RunOpts{MaxBatchRows: 3})
+	_, err = rows.Reduce(nil, func(acc interface{}, row json.RawMessage) (interface{}, error) {
+		calls++
+		return nil, fmt.Errorf("stop")
+	})
+	c.Assert(err, test.ErrorMatches, "stop")
+	c.Assert(calls, test.Equals, 1)
+	// the rest of the stream is not left open on the server
+	c.Assert(rows.complete, test.Equals, true)
+}
+
+func (s *RethinkSuite) TestInsertComputed(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type Hero struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	opts := InsertOpts{Computed: map[string]func(doc Map) interface{}{
+		"slug": func(doc Map) interface{} {
+			return strings.ToLower(strings.Replace(doc["name"].(string), " ", "-", -1))
+		},
+		"source": func(doc Map) interface{} { return "import" },
+	}}
+	rows := List{Hero{Id: "a", Name: "Iron Man"}, Map{"id": "b", "name": "Black Widow", "source": "manual"}}
+	err = tbl4.InsertWith(opts, rows).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var heroes []map[string]string
+	err = tbl4.OrderBy("id").Run(session).All(&heroes)
+	c.Assert(err, test.IsNil)
+	c.Assert(heroes, test.DeepEquals, []map[string]string{
+		{"id": "a", "name": "Iron Man", "slug": "iron-man", "source": "import"},
+		{"id": "b", "name": "Black Widow", "slug": "black-widow", "source": "import"},
+	})
+}
+
+func (s *RethinkSuite) TestTimeConstructors(c *test.C) {
+	pacific := time.FixedZone("", -7*60*60)
+	tests := []struct {
+		query    Exp
+		expected time.Time
+	}{
+		{Time(2013, 5, 17, "Z"), time.Date(2013, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{Time(2013, 5, 17, 14, 35, 12.5, "-07:00"), time.Date(2013, 5, 17, 14, 35, 12, 500e6, pacific)},
+		{EpochTime(1368801312), time.Date(2013, 5, 17, 14, 35, 12, 0, time.UTC)},
+		{ISO8601("2013-05-17T14:35:12-07:00"), time.Date(2013, 5, 17, 14, 35, 12, 0, pacific)},
+		{ISO8601WithOpts("2013-05-17T14:35:12", ISO8601Opts{DefaultTimezone: "-07:00"}), time.Date(2013, 5, 17, 14, 35, 12, 0, pacific)},
+	}
+	for _, t := range tests {
+		var result time.Time
+		err := t.query.Run(session).One(&result)
+		c.Assert(err, test.IsNil)
+		c.Assert(result.Equal(t.expected), test.Equals, true, test.Commentf("%v != %v", result, t.expected))
+	}
+
+	err := ISO8601("2013-05-17T14:35:12").Run(session).Err()
+	c.Assert(err, test.NotNil)
+
+	var same bool
+	err = Now().Eq(Now()).Run(session).One(&same)
+	c.Assert(err, test.IsNil)
+	c.Assert(same, test.Equals, true)
+
+	var now time.Time
+	err = Now().Run(session).One(&now)
+	c.Assert(err, test.IsNil)
+	c.Assert(time.Since(now) < time.Minute, test.Equals, true)
+}
+
+func (s *RethinkSuite) TestScanFields(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1, "name": "Storm", "strength": 7, "team": "X-Men"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type Hero struct {
+		Id       int    `json:"id"`
+		Name     string `json:"name"`
+		Strength int    `json:"strength"`
+	}
+	rows := tbl4.Pluck("id", "name", "team").Run(session)
+	c.Assert(rows.Next(), test.Equals, true)
+	hero := Hero{Strength: -1}
+	fields, err := rows.ScanFields(&hero)
+	c.Assert(err, test.IsNil)
+	c.Assert(fields, test.DeepEquals, []string{"id", "name"})
+	c.Assert(hero, test.Equals, Hero{Id: 1, Name: "Storm", Strength: -1})
+
+	rows = tbl4.Pluck("id", "team").Run(session)
+	c.Assert(rows.Next(), test.Equals, true)
+	var doc map[string]interface{}
+	fields, err = rows.ScanFields(&doc)
+	c.Assert(err, test.IsNil)
+	c.Assert(fields, test.DeepEquals, []string{"id", "team"})
+}
+
+func (s *RethinkSuite) TestOnConflict(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1, "name": "Thing", "strength": 9}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	var response WriteResponse
+	err = tbl4.Insert(Map{"id": 1, "strength": 10}).OnConflict("update").Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Replaced, test.Equals, 1)
+
+	var row map[string]interface{}
+	err = tbl4.Get(1).Run(session).One(&row)
+	c.Assert(err, test.IsNil)
+	c.Assert(row, test.DeepEquals, map[string]interface{}{"id": float64(1), "name": "Thing", "strength": float64(10)})
+
+	err = tbl4.InsertWith(InsertOpts{Conflict: "update"}, Map{"id": 1, "team": "FF"}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(Map{"id": 1}).OnConflict("replace").Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Get(1).Run(session).One(&row)
+	c.Assert(err, test.IsNil)
+	c.Assert(row, test.DeepEquals, map[string]interface{}{"id": float64(1)})
+
+	response, err = tbl4.Insert(Map{"id": 1}).OnConflict("error").RunWrite(session)
+	c.Assert(err, test.NotNil)
+	c.Assert(response.Errors, test.Equals, 1)
+
+	err = tbl4.Get(1).Update(Map{}).OnConflict("update").Check(session)
+	c.Assert(err, test.ErrorMatches, `.*\.OnConflict\(\) can only be used directly after \.Insert\(\).*`)
+}
+
+func (s *RethinkSuite) TestReturnChanges(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1, "team": "X-Men"}, Map{"id": 2, "team": "X-Men"}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type Hero struct {
+		Id   int    `json:"id"`
+		Team string `json:"team"`
+	}
+	var response WriteResponse
+	err = tbl4.Update(Map{"team": "X-Force"}).ReturnChanges().Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Changes, test.HasLen, 2)
+	for _, change := range response.Changes {
+		var before, after Hero
+		err = change.Unmarshal(&before, &after)
+		c.Assert(err, test.IsNil)
+		c.Assert(before.Team, test.Equals, "X-Men")
+		c.Assert(after, test.Equals, Hero{Id: before.Id, Team: "X-Force"})
+	}
+
+	opts := UpdateOpts{ReturnChanges: true}
+	err = tbl4.Get(3).ReplaceWithOpts(Map{"id": 3, "team": "Avengers"}, opts).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Changes, test.HasLen, 1)
+	before := Hero{Id: -1}
+	var after Hero
+	err = response.Changes[0].Unmarshal(&before, &after)
+	c.Assert(err, test.IsNil)
+	c.Assert(before, test.Equals, Hero{Id: -1})
+	c.Assert(after, test.Equals, Hero{Id: 3, Team: "Avengers"})
+}
+
+func (s *RethinkSuite) TestHandleFeed(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	feedSession, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	defer feedSession.Close()
+
+	var events []string
+	stop := fmt.Errorf("stop")
+	rows := tbl4.Changes().IncludeStates().Run(feedSession)
+	err = rows.HandleFeed(FeedHandler{
+		OnReady: func() {
+			events = append(events, "ready")
+			err := tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}}).Run(session).Exec()
+			c.Assert(err, test.IsNil)
+		},
+		OnChange: func(change RawChange) error {
+			var row struct{ Id int }
+			c.Assert(change.Unmarshal(nil, &row), test.IsNil)
+			events = append(events, fmt.Sprint("change ", row.Id))
+			if len(events) == 3 {
+				return stop
+			}
+			return nil
+		},
+		OnError: func(err error) {
+			events = append(events, "error")
+		},
+	})
+	c.Assert(err, test.Equals, stop)
+	c.Assert(events, test.HasLen, 3)
+	c.Assert(events[0], test.Equals, "ready")
+	// the rows are inserted together, so their changes can come in any order
+	if events[1] == "change 2" {
+		events[1], events[2] = events[2], events[1]
+	}
+	c.Assert(events[1:], test.DeepEquals, []string{"change 1", "change 2"})
+	// the feed is stopped once OnChange fails
+	c.Assert(rows.complete, test.Equals, true)
+	c.Assert(rows.Close(), test.IsNil)
+
+	err = Expr(1).Changes().IncludeStates().Run(feedSession).HandleFeed(FeedHandler{
+		OnError: func(err error) { events = append(events, "error") },
+	})
+	c.Assert(err, test.NotNil)
+	c.Assert(events[len(events)-1], test.Equals, "error")
+}
+
+func (s *RethinkSuite) TestSetClock(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	frozen := time.Date(2013, 5, 17, 14, 35, 12, 0, time.UTC)
+	SetClock(func() time.Time { return frozen })
+	defer SetClock(nil)
+	c.Assert(ClockNow(), test.Equals, frozen)
+
+	var now time.Time
+	err = Now().Run(session).One(&now)
+	c.Assert(err, test.IsNil)
+	c.Assert(now.Equal(frozen), test.Equals, true)
+	err = NowOffset(time.Hour).Run(session).One(&now)
+	c.Assert(err, test.IsNil)
+	c.Assert(now.Equal(frozen.Add(time.Hour)), test.Equals, true)
+
+	err = EnsureIndexes(session, "table4", []IndexSpec{{Name: "date"}})
+	c.Assert(err, test.IsNil)
+	defer tbl4.IndexDrop("date").Run(session).Exec()
+	err = tbl4.Insert(List{
+		Map{"id": 1, "date": frozen.Add(-time.Hour)},
+		Map{"id": 2, "date": frozen.Add(-48 * time.Hour)},
+	}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	var count int
+	err = tbl4.WithinLast("date", 24*time.Hour).Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+
+	SetClock(nil)
+	err = NowOffset(-time.Minute).Run(session).One(&now)
+	c.Assert(err, test.IsNil)
+	c.Assert(now.After(frozen), test.Equals, true)
+}
+
+func (s *RethinkSuite) TestReaper(c *test.C) {
+	err := tbl4.Delete().Run(session).Err()
+	c.Assert(err, test.IsNil)
+	err = EnsureIndexes(session, "table4", []IndexSpec{TTLIndex()})
+	c.Assert(err, test.IsNil)
+	defer tbl4.IndexDrop(TTLIndex().Name).Run(session).Exec()
+
+	rows := List{
+		Map{"id": 1, "expires_at": NowOffset(-time.Hour)},
+		Map{"id": 2, "expires_at": NowOffset(-time.Minute)},
+		Map{"id": 3, "expires_at": NowOffset(-time.Second)},
+		Map{"id": 4, "expires_at": ExpiresIn(time.Hour)},
+		Map{"id": 5},
+		// only times expire
+		Map{"id": 7, "expires_at": 0},
+		Map{"id": 8, "expires_at": false},
+	}
+	err = tbl4.Insert(rows).Run(session).Err()
+	c.Assert(err, test.IsNil)
+
+	var count int
+	err = tbl4.Expired().Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 3)
+
+	// a batch at a time
+	deleted, err := reapExpired(session, "table4", 2)
+	c.Assert(err, test.IsNil)
+	c.Assert(deleted, test.Equals, 3)
+
+	err = tbl4.Insert(Map{"id": 6, "expires_at": NowOffset(-time.Hour)}).Run(session).Err()
+	c.Assert(err, test.IsNil)
+
+	// the first pass is made straight away
+	reaper := NewReaper(session, "table4", time.Hour)
+	c.Assert(reaper.Stop(), test.IsNil)
+	c.Assert(reaper.Deleted(), test.Equals, 1)
+
+	var ids []int
+	err = tbl4.OrderBy("id").Map(Row.Attr("id")).Run(session).All(&ids)
+	c.Assert(err, test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{4, 5, 7, 8})
+}
+
+func (s *RethinkSuite) TestGetAllChunks(c *test.C) {
+	SetGetAllChunkSize(3)
+	defer SetGetAllChunkSize(1000)
+
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	keys := []interface{}{}
+	docs := List{}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, i)
+		docs = append(docs, Map{"id": i})
+	}
+	err = tbl4.Insert(docs).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	chunks, ok := splitGetAll(tbl4.GetAll("id", keys...))
+	c.Assert(ok, test.Equals, true)
+	c.Assert(chunks, test.HasLen, 4)
+
+	var rows []Map
+	err = tbl4.GetAll("id", keys...).Run(session).All(&rows)
+	c.Assert(err, test.IsNil)
+	c.Assert(rows, test.HasLen, 10)
+
+	// only a query that is just a .GetAll() is split
+	_, ok = splitGetAll(tbl4.GetAll("id", keys...).Count())
+	c.Assert(ok, test.Equals, false)
+}
+
+func (s *RethinkSuite) TestMergeCursors(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = tbl4.Insert(List{Map{"id": 1}, Map{"id": 2}, Map{"id": 3}}).Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	type row struct {
+		Id int `json:"id"`
+	}
+	var response []row
+	rows := MergeCursors(tbl4.GetAll("id", 3).Run(session), tbl4.GetAll("id", 100).Run(session), tbl4.GetAll("id", 1).Run(session))
+	err = rows.All(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response, test.DeepEquals, []row{{3}, {1}})
+
+	byId := func(rows *Rows) interface{} {
+		var r row
+		rows.Scan(&r)
+		return r.Id
+	}
+	rows = MergeCursorsOrdered(byId, tbl4.GetAll("id", 3).Run(session), tbl4.GetAll("id", 1, 100).Run(session), tbl4.GetAll("id", 2).Run(session))
+	err = rows.All(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response, test.DeepEquals, []row{{1}, {2}, {3}})
+}
+
+func (s *RethinkSuite) TestKillOnTimeout(c *test.C) {
+	sess, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	defer sess.Close()
+
+	sess.SetTimeout(time.Nanosecond)
+	sess.SetKillOnTimeout(true)
+	err = sess.Run(Js("while (true) {}")).Err()
+	c.Assert(isTimeout(err), test.Equals, true)
+}
+
+func (s *RethinkSuite) TestJobs(c *test.C) {
+	sess, err := Connect("localhost:28015", "test")
+	c.Assert(err, test.IsNil)
+	defer sess.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- sess.Run(JsWithTimeout("while (true) {}", 60)).Err()
+	}()
+
+	var job Job
+	for attempt := 0; attempt < 50 && job.Id == nil; attempt++ {
+		time.Sleep(100 * time.Millisecond)
+		jobs, err := Jobs(session)
+		c.Assert(err, test.IsNil)
+		for _, j := range jobs {
+			if j.Type == "query" && strings.Contains(j.Info.Query, "while (true)") {
+				job = j
+			}
+		}
+	}
+	c.Assert(job.Id, test.HasLen, 2)
+	c.Assert(job.Servers, test.Not(test.HasLen), 0)
+
+	err = KillJob(session, job.Id)
+	c.Assert(err, test.IsNil)
+	c.Assert(<-done, test.NotNil)
+
+	err = KillJob(session, job.Id)
+	c.Assert(err, test.ErrorMatches, ".*No job with id.*")
+}
+
+func (s *RethinkSuite) TestWriteDelay(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+
+	session.SetWriteDelay(time.Hour)
+	defer session.SetWriteDelay(0)
+	for i := 0; i < 5; i++ {
+		err = tbl4.Insert(Map{"id": i}).RunNoreply(session)
+		c.Assert(err, test.IsNil)
+	}
+	err = session.Flush()
+	c.Assert(err, test.IsNil)
+
+	// a query that waits for a response sends the buffered queries first
+	err = tbl4.Insert(Map{"id": 5}).RunNoreply(session)
+	c.Assert(err, test.IsNil)
+	err = session.NoreplyWait()
+	c.Assert(err, test.IsNil)
+
+	var count int
+	err = tbl4.Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 6)
+}
+
+func (s *RethinkSuite) TestWriteDelayTimeout(c *test.C) {
+	// nothing reads from the other end of the pipe, so writes block
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &connection{Conn: client, writer: bufio.NewWriter(client), flushDelay: time.Millisecond}
+	defer conn.Close()
+
+	err := conn.writeMessage([]byte("query"))
+	c.Assert(err, test.IsNil)
+	err = conn.flushLater(10 * time.Millisecond)
+	c.Assert(err, test.IsNil)
+	time.Sleep(100 * time.Millisecond)
+
+	// the delayed write timed out, and the error is returned by the next one
+	err = conn.writeMessage([]byte("query"))
+	netErr, ok := err.(net.Error)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(netErr.Timeout(), test.Equals, true)
+}
+
+func (s *RethinkSuite) TestUpsertByIndex(c *test.C) {
+	err := tbl4.Delete().Run(session).Exec()
+	c.Assert(err, test.IsNil)
+	err = EnsureIndexes(session, "table4", []IndexSpec{{Name: "name"}})
+	c.Assert(err, test.IsNil)
+	defer tbl4.IndexDrop("name").Run(session).Exec()
+
+	var response WriteResponse
+	err = tbl4.UpsertByIndex("name", "Storm", Map{"name": "Storm", "strength": 5}).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Inserted, test.Equals, 1)
+
+	err = tbl4.UpsertByIndex("name", "Storm", Map{"strength": 6}).Durability("soft").Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Replaced, test.Equals, 1)
+
+	var rows []Map
+	err = tbl4.Run(session).All(&rows)
+	c.Assert(err, test.IsNil)
+	c.Assert(rows, test.HasLen, 1)
+	c.Assert(rows[0]["strength"], test.Equals, float64(6))
+
+	query, err := session.getContext().buildProtobuf(tbl4.UpsertByIndex("name", "Storm", Map{}).Durability("soft"))
+	c.Assert(err, test.IsNil)
+	branch := query.GetQuery()
+	c.Assert(branch.GetType(), test.Equals, p.Term_BRANCH)
+	c.Assert(optargNames(branch.Args[1])["durability"], test.Equals, true)
+	c.Assert(optargNames(branch.Args[2])["durability"], test.Equals, true)
+}
+
+func (s *RethinkSuite) TestLoadExampleData(c *test.C) {
+	// loading twice replaces the rows instead of failing
+	for i := 0; i < 2; i++ {
+		err := LoadExampleData(session)
+		c.Assert(err, test.IsNil)
+	}
+	defer func() {
+		for _, name := range []string{"heroes", "villains", "lairs"} {
+			TableDrop(name).Run(session).Exec()
+		}
+	}()
+
+	var count int
+	err := Table("heroes").Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, len(exampleHeroes))
+
+	err = Table("villains").EqJoin("id", Table("lairs"), "villain_id").Count().Run(session).One(&count)
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, len(exampleLairs))
+}
+
+func (s *RethinkSuite) TestLint(c *test.C) {
+	rules := func(query Exp) []string {
+		findings, err := Lint(query)
+		c.Assert(err, test.IsNil)
+		var names []string
+		for _, finding := range findings {
+			names = append(names, finding.Rule)
+		}
+		return names
+	}
+
+	c.Assert(rules(tbl.OrderBy("num").Limit(3)), test.DeepEquals, []string{"orderby-limit"})
+	c.Assert(rules(tbl.UseIndex("num").OrderBy().Limit(3)), test.HasLen, 0)
+	c.Assert(rules(tbl.Filter(Map{"num": 1}).Count()), test.DeepEquals, []string{"filter-table"})
+	c.Assert(rules(tbl.Update(Js("({})"))), test.DeepEquals, []string{"update-js"})
+	c.Assert(rules(tbl.Update(Js("({})")).Atomic(false)), test.HasLen, 0)
+	c.Assert(rules(tbl.OrderBy("num").Count()), test.DeepEquals, []string{"count-after-fetch"})
+
+	var linted []LintFinding
+	SetLintHook(func(query Exp, findings []LintFinding) {
+		linted = append(linted, findings...)
+	})
+	defer SetLintHook(nil)
+	err := tbl.OrderBy("num").Limit(1).Run(session).Err()
+	c.Assert(err, test.IsNil)
+	c.Assert(linted, test.HasLen, 1)
+	c.Assert(linted[0].Path, test.DeepEquals, []string{"LIMIT"})
+}
+
+func (s *RethinkSuite) TestNestedPseudoTypes(c *test.C) {
+	when := time.Date(2013, 8, 14, 1, 32, 49, 923000000, time.UTC)
+	doc := Map{"battles": Expr(List{}).Append(Map{"when": epochTime(when)})}
+
+	var response struct {
+		Battles []struct {
+			When time.Time `json:"when"`
+		} `json:"battles"`
+	}
+	err := Expr(doc).Run(session).One(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Battles, test.HasLen, 1)
+	c.Assert(response.Battles[0].When.Equal(when), test.Equals, true)
+
+	// raw times are left alone
+	var raw Map
+	err = Expr(doc).RunWith(session, RunOpts{TimeFormat: "raw"}).One(&raw)
+	c.Assert(err, test.IsNil)
+	battle := raw["battles"].([]interface{})[0].(map[string]interface{})
+	c.Assert(battle["when"].(map[string]interface{})["$reql_type$"], test.Equals, "TIME")
+}
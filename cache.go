@@ -0,0 +1,241 @@
+package rethinkgo
+
+// Cache the results of read queries, keyed by the query and its options.
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"container/list"
+	"encoding/json"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache stores query results for .RunCached().  Values are opaque byte
+// slices, so any key-value store can be used as a backend.  Implementations
+// must be safe to use from multiple goroutines.
+type Cache interface {
+	// Get returns the value stored for the key, if it has not expired.
+	Get(key string) ([]byte, bool)
+	// Set stores a value for the key, which expires after ttl.
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// RunCached runs a query like .Run(), but first looks for the results in the
+// cache.  If they are not found, all of the results are read from the server
+// and stored in the cache for the given ttl.  Queries are identical if they
+// produce the same terms and options, including the session's default
+// RunOpts, even if they were built separately.
+//
+// Writes and changefeeds are always run without the cache.
+//
+// Example usage:
+//
+//  cache := r.NewLRUCache(100)
+//  var count int
+//  err := r.Table("heroes").Count().RunCached(session, cache, time.Minute).One(&count)
+func (e Exp) RunCached(session *Session, cache Cache, ttl time.Duration) *Rows {
+	queryProto, err := session.buildQuery(e, RunOpts{})
+	if err != nil {
+		return &Rows{lasterr: err}
+	}
+	if !canRetry(queryProto) || containsTermType(queryProto.GetQuery(), p.Term_CHANGES) {
+		return session.Run(e)
+	}
+	key, err := sharedKey(queryProto)
+	if err != nil {
+		return &Rows{lasterr: err}
+	}
+	format := session.defaultRunOpts.pseudoTypeFormat()
+
+	if data, ok := cache.Get(key); ok {
+		response := &p.Response{}
+		if err := proto.Unmarshal(data, response); err == nil {
+			return &Rows{
+				buffer:       response.Response,
+				complete:     true,
+				responseType: response.GetType(),
+				format:       format,
+			}
+		}
+		// ignore anything we can't read and run the query again
+	}
+
+	rows := session.Run(e)
+	if rows.Err() != nil {
+		return rows
+	}
+
+	// read all the results so we can cache them
+	var buffer []*p.Datum
+	for rows.Next() {
+		buffer = append(buffer, rows.current)
+	}
+	if rows.Err() != nil {
+		return rows
+	}
+
+	responseType := rows.responseType
+	if responseType == p.Response_SUCCESS_PARTIAL {
+		responseType = p.Response_SUCCESS_SEQUENCE
+	}
+	response := &p.Response{
+		Type:     responseType.Enum(),
+		Response: buffer,
+	}
+	if data, err := proto.Marshal(response); err == nil {
+		cache.Set(key, data, ttl)
+	}
+
+	return &Rows{
+		buffer:       buffer,
+		complete:     true,
+		responseType: responseType,
+		format:       format,
+	}
+}
+
+// canonicalTerm copies a term, sorting optargs by key and renumbering
+// variables in the order they appear, since variable numbers are different
+// each time a query is built.  `variables` maps the original numbers to the
+// new ones.
+func canonicalTerm(term *p.Term, variables map[float64]float64) *p.Term {
+	result := &p.Term{
+		Type:  term.Type,
+		Datum: term.Datum,
+	}
+
+	switch term.GetType() {
+	case p.Term_FUNC:
+		// the first argument is the list of parameters
+		if len(term.Args) > 0 {
+			for _, param := range term.Args[0].Args {
+				number := param.GetDatum().GetRNum()
+				variables[number] = float64(len(variables) + 1)
+			}
+		}
+	case p.Term_VAR:
+		if len(term.Args) == 1 {
+			number := variables[variableNumber(term.Args[0])]
+			return &p.Term{
+				Type: term.Type,
+				Args: []*p.Term{numberTerm(number)},
+			}
+		}
+	}
+
+	for i, arg := range term.Args {
+		if term.GetType() == p.Term_FUNC && i == 0 {
+			var params []*p.Term
+			for _, param := range arg.Args {
+				params = append(params, numberTerm(variables[param.GetDatum().GetRNum()]))
+			}
+			result.Args = append(result.Args, &p.Term{Type: arg.Type, Args: params})
+			continue
+		}
+		result.Args = append(result.Args, canonicalTerm(arg, variables))
+	}
+
+	optargs := append([]*p.Term_AssocPair{}, term.Optargs...)
+	sort.Sort(assocPairsByKey(optargs))
+	for _, optarg := range optargs {
+		result.Optargs = append(result.Optargs, &p.Term_AssocPair{
+			Key: optarg.Key,
+			Val: canonicalTerm(optarg.Val, variables),
+		})
+	}
+	return result
+}
+
+// variableNumber returns the number of a variable from the argument of a VAR
+// term, which may be sent as a JSON term.
+func variableNumber(term *p.Term) float64 {
+	if term.GetType() == p.Term_JSON && len(term.Args) == 1 {
+		var number float64
+		json.Unmarshal([]byte(term.Args[0].GetDatum().GetRStr()), &number)
+		return number
+	}
+	return term.GetDatum().GetRNum()
+}
+
+func numberTerm(number float64) *p.Term {
+	return &p.Term{
+		Type: p.Term_DATUM.Enum(),
+		Datum: &p.Datum{
+			Type: p.Datum_R_NUM.Enum(),
+			RNum: proto.Float64(number),
+		},
+	}
+}
+
+type assocPairsByKey []*p.Term_AssocPair
+
+func (a assocPairsByKey) Len() int           { return len(a) }
+func (a assocPairsByKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a assocPairsByKey) Less(i, j int) bool { return a[i].GetKey() < a[j].GetKey() }
+
+// lruCache is the in-memory Cache returned by NewLRUCache().
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used at the front
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache creates an in-memory Cache that holds up to `size` results,
+// discarding the least recently used results when it is full.
+//
+// Example usage:
+//
+//  cache := r.NewLRUCache(100)
+func NewLRUCache(size int) Cache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *lruCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,877 @@
+package rethinkgo
+
+// Convert Go values to and from JSON.  Most of the work is done by the "json"
+// module, the functions in this file only take over for types that use
+// features that module does not know about, such as the options specified with
+// `rethinkdb:""` struct tags.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cipher encrypts and decrypts the values of struct fields tagged with
+// `rethinkdb:",encrypted"`.  The field is serialized to JSON, passed to
+// Encrypt() and stored on the server as a base64 encoded string.  When
+// scanning rows, the process is reversed with Decrypt().
+//
+// Example usage:
+//
+//  type User struct {
+//      Name string `json:"name"`
+//      SSN  string `json:"ssn" rethinkdb:",encrypted"`
+//  }
+//
+//  r.SetCipher(myCipher)
+//  err := r.Table("users").Insert(User{"Jean Grey", "078-05-1120"}).Run(session).Exec()
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+var fieldCipher Cipher
+
+// SetCipher sets the Cipher used for struct fields tagged with
+// `rethinkdb:",encrypted"`.  Queries that use such fields return an error if
+// no Cipher has been set.
+//
+// Example usage:
+//
+//  r.SetCipher(myCipher)
+func SetCipher(c Cipher) {
+	fieldCipher = c
+}
+
+// fieldInfo describes a single struct field as seen by the json module.
+type fieldInfo struct {
+	name      string // name of the field in JSON
+	index     []int  // index sequence for reflect.Value.FieldByIndex()
+	typ       reflect.Type
+	encrypted bool
+	enum      bool // stored by name, see RegisterEnum()
+}
+
+// special is true if the field cannot be handled by the json module alone.
+func (f fieldInfo) special() bool {
+	return f.encrypted || f.enum || needsEncode(f.typ) || needsDecode(f.typ)
+}
+
+// codec flags describe the features used anywhere inside of a type
+const (
+	flagTagged        = 1 << iota // a struct field has `rethinkdb:""` options
+	flagInterface                 // contains an interface type
+	flagDiscriminated             // contains an interface type with methods
+	flagRegistered                // contains a type added with RegisterType()
+	flagTime                      // contains a time.Time, sent as a TIME pseudo-type
+	flagBinary                    // contains a []byte, sent as a BINARY pseudo-type
+	flagNilable                   // contains a slice or map, see SetNilEncoding()
+)
+
+var codecCache = struct {
+	sync.RWMutex
+	flags  map[reflect.Type]int
+	fields map[reflect.Type][]fieldInfo
+}{
+	flags:  map[reflect.Type]int{},
+	fields: map[reflect.Type][]fieldInfo{},
+}
+
+var (
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// hasCustomJson is true if the type handles its own JSON conversion, in which
+// case we leave it alone.
+func hasCustomJson(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+	return t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonUnmarshalerType)
+}
+
+// codecFlags returns the flags for a type, computing them if needed.
+func codecFlags(t reflect.Type) int {
+	codecCache.RLock()
+	flags, ok := codecCache.flags[t]
+	codecCache.RUnlock()
+	if ok {
+		return flags
+	}
+
+	codecCache.Lock()
+	defer codecCache.Unlock()
+	return computeCodecFlags(t)
+}
+
+// computeCodecFlags must be called with codecCache locked.
+func computeCodecFlags(t reflect.Type) int {
+	if flags, ok := codecCache.flags[t]; ok {
+		return flags
+	}
+	// guard against recursive types, they will get the flags of the rest of
+	// the type
+	codecCache.flags[t] = 0
+
+	flags := 0
+	if t == timeType || t == reflect.PtrTo(timeType) {
+		flags |= flagTime
+	} else if isBytes(t) {
+		flags |= flagBinary
+	} else if !hasCustomJson(t) {
+		switch t.Kind() {
+		case reflect.Interface:
+			flags |= flagInterface
+			if t.NumMethod() > 0 {
+				flags |= flagDiscriminated
+			}
+		case reflect.Ptr, reflect.Array:
+			flags |= computeCodecFlags(t.Elem())
+		case reflect.Slice, reflect.Map:
+			flags |= flagNilable | computeCodecFlags(t.Elem())
+		case reflect.Struct:
+			if _, ok := registeredName(t); ok {
+				flags |= flagRegistered
+			}
+			for _, f := range computeStructFields(t) {
+				if f.encrypted || f.enum {
+					flags |= flagTagged
+				}
+				flags |= computeCodecFlags(f.typ)
+			}
+		}
+	}
+	codecCache.flags[t] = flags
+	return flags
+}
+
+// needsEncode is true if values of the type have to be converted by
+// encodeValue() before being given to the json module.  Interfaces always
+// need to be checked, as we don't know what they might contain.  Slices and
+// maps are only checked when nil ones are sent as empty ones, which the json
+// module does not do.
+func needsEncode(t reflect.Type) bool {
+	flags := flagTagged | flagInterface | flagRegistered | flagTime | flagBinary
+	if nilEncoding == NilAsEmpty {
+		flags |= flagNilable
+	}
+	return codecFlags(t)&flags != 0
+}
+
+// needsDecode is true if the type can't be decoded with the json module alone.
+func needsDecode(t reflect.Type) bool {
+	return codecFlags(t)&(flagTagged|flagDiscriminated) != 0
+}
+
+// structFields returns the fields of a struct type that appear in JSON.
+func structFields(t reflect.Type) []fieldInfo {
+	codecCache.RLock()
+	fields, ok := codecCache.fields[t]
+	codecCache.RUnlock()
+	if ok {
+		return fields
+	}
+
+	codecCache.Lock()
+	defer codecCache.Unlock()
+	return computeStructFields(t)
+}
+
+// computeStructFields must be called with codecCache locked.
+func computeStructFields(t reflect.Type) []fieldInfo {
+	if fields, ok := codecCache.fields[t]; ok {
+		return fields
+	}
+
+	var fields []fieldInfo
+	seen := map[string]int{}
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			jsonTag := sf.Tag.Get("json")
+			if jsonTag == "-" {
+				continue
+			}
+			name := strings.Split(jsonTag, ",")[0]
+			fieldIndex := append(append([]int{}, index...), i)
+
+			// embedded structs have their fields promoted, unless they have been
+			// given a name in JSON
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct && name == "" {
+				walk(sf.Type, fieldIndex)
+				continue
+			}
+			if sf.PkgPath != "" {
+				// unexported
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+
+			field := fieldInfo{
+				name:  name,
+				index: fieldIndex,
+				typ:   sf.Type,
+			}
+			for _, option := range strings.Split(sf.Tag.Get("rethinkdb"), ",")[1:] {
+				switch option {
+				case "encrypted":
+					field.encrypted = true
+				case "string":
+					field.enum = true
+				}
+			}
+
+			// the least nested field wins, the same as the json module
+			if j, ok := seen[name]; ok {
+				if len(fields[j].index) > len(field.index) {
+					fields[j] = field
+				}
+				continue
+			}
+			seen[name] = len(fields)
+			fields = append(fields, field)
+		}
+	}
+	walk(t, nil)
+
+	codecCache.fields[t] = fields
+	return fields
+}
+
+// fieldByName finds the field for a JSON key, falling back to a case
+// insensitive match like the json module does.
+func fieldByName(fields []fieldInfo, name string) (fieldInfo, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return fieldInfo{}, false
+}
+
+///////////////////
+// Type registry //
+///////////////////
+
+var typeRegistry = struct {
+	sync.RWMutex
+	field string
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}{
+	field: "type",
+	types: map[string]reflect.Type{},
+	names: map[reflect.Type]string{},
+}
+
+// RegisterType associates a name with a struct type, so that documents can be
+// decoded into interface types.  When decoding into an interface (other than
+// interface{}), the type field of the document (see SetTypeField()) is looked
+// up in the registry and a value of the registered type is created.  If
+// `value` is a pointer, the interface is set to a pointer to the new value.
+//
+// Documents created from a registered type have the type field added
+// automatically if the struct does not set it itself.
+//
+// Example usage:
+//
+//  type Character interface {
+//      Name() string
+//  }
+//
+//  r.RegisterType("hero", Hero{})
+//  r.RegisterType("villain", &Villain{})
+//
+//  var characters []Character
+//  err := r.Table("characters").Run(session).All(&characters)
+func RegisterType(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	structType := t
+	if structType != nil && structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType == nil || structType.Kind() != reflect.Struct {
+		panic("rethinkdb: RegisterType() requires a struct or pointer to a struct")
+	}
+
+	typeRegistry.Lock()
+	typeRegistry.types[name] = t
+	typeRegistry.names[structType] = name
+	typeRegistry.Unlock()
+
+	// registering a type changes how it is encoded
+	codecCache.Lock()
+	codecCache.flags = map[reflect.Type]int{}
+	codecCache.Unlock()
+}
+
+// SetTypeField sets the name of the document field that holds the registered
+// name of the document's type, the default is "type".
+//
+// Example usage:
+//
+//  r.SetTypeField("kind")
+func SetTypeField(field string) {
+	typeRegistry.Lock()
+	typeRegistry.field = field
+	typeRegistry.Unlock()
+}
+
+func registeredName(t reflect.Type) (string, bool) {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	name, ok := typeRegistry.names[t]
+	return name, ok
+}
+
+func registeredType(name string) (reflect.Type, bool) {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	t, ok := typeRegistry.types[name]
+	return t, ok
+}
+
+func typeField() string {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	return typeRegistry.field
+}
+
+//////////////
+// Encoding //
+//////////////
+
+// encodeValue converts v into something that can be passed to json.Marshal(),
+// applying any struct tag options along the way.
+func encodeValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return encodeReflect(reflect.ValueOf(v))
+}
+
+func encodeReflect(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if !needsEncode(v.Type()) {
+		return v.Interface(), nil
+	}
+	if v.Type() == timeType {
+		return encodeTime(v.Interface().(time.Time)), nil
+	}
+	if isBytes(v.Type()) {
+		if v.IsNil() && nilEncoding == NilAsNull {
+			return nil, nil
+		}
+		return encodeBinary(v.Bytes()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeReflect(v.Elem())
+	case reflect.Struct:
+		return encodeStruct(v)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			if nilEncoding == NilAsNull {
+				return nil, nil
+			}
+			return []interface{}{}, nil
+		}
+		array := make([]interface{}, v.Len())
+		for i := range array {
+			item, err := encodeReflect(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			array[i] = item
+		}
+		return array, nil
+	case reflect.Map:
+		if v.IsNil() && nilEncoding == NilAsNull {
+			return nil, nil
+		}
+		object := map[string]interface{}{}
+		for _, key := range v.MapKeys() {
+			name, err := mapKeyString(key)
+			if err != nil {
+				return nil, err
+			}
+			item, err := encodeReflect(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			object[name] = item
+		}
+		return object, nil
+	}
+	return v.Interface(), nil
+}
+
+// encodeStruct lets the json module convert the struct, then replaces any
+// fields that need special treatment.
+func encodeStruct(v reflect.Value) (interface{}, error) {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var encoded map[string]json.RawMessage
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	object := map[string]interface{}{}
+	for key, value := range encoded {
+		object[key] = value
+	}
+
+	for _, f := range structFields(v.Type()) {
+		if _, ok := encoded[f.name]; !ok || !f.special() {
+			// omitted, or already handled by the json module
+			continue
+		}
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
+
+		var value interface{}
+		if f.encrypted {
+			value, err = encryptField(fv)
+		} else if f.enum {
+			value, err = encodeEnum(fv)
+		} else {
+			value, err = encodeReflect(fv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %v", f.name, err)
+		}
+		object[f.name] = value
+	}
+
+	if name, ok := registeredName(v.Type()); ok {
+		field := typeField()
+		if _, ok := object[field]; !ok {
+			object[field] = name
+		}
+	}
+	return object, nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex() but returns false instead
+// of panicking when passing through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func mapKeyString(key reflect.Value) (string, error) {
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported map key type: %v", key.Type())
+}
+
+// encodeTime converts a time to the server's TIME pseudo-type, keeping the
+// offset of its location.
+func encodeTime(t time.Time) map[string]interface{} {
+	_, offset := t.Zone()
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return map[string]interface{}{
+		"$reql_type$": "TIME",
+		"epoch_time":  float64(t.Unix()) + float64(t.Nanosecond())/1e9,
+		"timezone":    fmt.Sprintf("%v%02d:%02d", sign, offset/3600, offset/60%60),
+	}
+}
+
+// isBytes is true for []byte and other byte slices that the json module
+// converts to base64 strings.
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && !hasCustomJson(t)
+}
+
+// encodeBinary converts bytes to the server's BINARY pseudo-type.
+func encodeBinary(data []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"$reql_type$": "BINARY",
+		"data":        base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// binaryData returns the base64 string of a BINARY pseudo-type made by
+// encodeBinary().
+func binaryData(value interface{}) (string, bool) {
+	v, ok := value.(map[string]interface{})
+	if !ok || len(v) != 2 || v["$reql_type$"] != "BINARY" {
+		return "", false
+	}
+	data, ok := v["data"].(string)
+	return data, ok
+}
+
+// withoutBinary replaces the BINARY pseudo-types in an encoded value with
+// their base64 strings, which is how the json module sends a []byte.
+func withoutBinary(value interface{}) interface{} {
+	if data, ok := binaryData(value); ok {
+		return data
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := map[string]interface{}{}
+		for key, item := range v {
+			object[key] = withoutBinary(item)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, item := range v {
+			array[i] = withoutBinary(item)
+		}
+		return array
+	}
+	return value
+}
+
+func encryptField(v reflect.Value) (interface{}, error) {
+	if fieldCipher == nil {
+		return nil, errors.New("no Cipher set for encrypted field, use r.SetCipher()")
+	}
+	plaintext, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := fieldCipher.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+//////////////
+// Decoding //
+//////////////
+
+// decodeJson is used in place of json.Unmarshal() to decode rows from the
+// server.
+func decodeJson(data []byte, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || !needsDecode(v.Type()) {
+		return json.Unmarshal(data, dest)
+	}
+	return decodeReflect(data, v.Elem())
+}
+
+func isJsonNull(data []byte) bool {
+	return strings.TrimSpace(string(data)) == "null"
+}
+
+// decodeReflect decodes data into v, which must be settable.
+func decodeReflect(data []byte, v reflect.Value) error {
+	if !needsDecode(v.Type()) {
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if isJsonNull(data) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeReflect(data, v.Elem())
+	case reflect.Struct:
+		return decodeStruct(data, v)
+	case reflect.Interface:
+		return decodeInterface(data, v)
+	case reflect.Slice, reflect.Array:
+		if isJsonNull(data) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(items), len(items)))
+		}
+		for i, item := range items {
+			if i >= v.Len() {
+				break
+			}
+			if err := decodeReflect(item, v.Index(i)); err != nil {
+				return withPath(err, fmt.Sprintf(".%v", i))
+			}
+		}
+		return nil
+	case reflect.Map:
+		if isJsonNull(data) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for name, item := range items {
+			key, err := mapKeyValue(name, v.Type().Key())
+			if err != nil {
+				return withPath(err, "."+name)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeReflect(item, elem); err != nil {
+				return withPath(err, "."+name)
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	}
+	return json.Unmarshal(data, v.Addr().Interface())
+}
+
+// decodeStruct gives the json module all the fields it can handle, then
+// decodes the rest of them individually.
+func decodeStruct(data []byte, v reflect.Value) error {
+	if isJsonNull(data) {
+		return nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	fields := structFields(v.Type())
+	plain := map[string]json.RawMessage{}
+	special := map[string]fieldInfo{}
+	for key, value := range object {
+		if f, ok := fieldByName(fields, key); ok && f.special() {
+			special[key] = f
+		} else {
+			plain[key] = value
+		}
+	}
+
+	plainData, err := json.Marshal(plain)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(plainData, v.Addr().Interface()); err != nil {
+		return err
+	}
+
+	for key, f := range special {
+		value := object[key]
+		if f.encrypted {
+			if value, err = decryptField(value); err != nil {
+				return withPath(err, "."+f.name)
+			}
+		}
+		if f.enum {
+			if err := decodeEnum(value, allocFieldByIndex(v, f.index)); err != nil {
+				return withPath(err, "."+f.name)
+			}
+			continue
+		}
+		if err := decodeReflect(value, allocFieldByIndex(v, f.index)); err != nil {
+			return withPath(err, "."+f.name)
+		}
+	}
+	return nil
+}
+
+// decodeInterface uses the type registry to find out what concrete type to
+// decode an object into.
+func decodeInterface(data []byte, v reflect.Value) error {
+	if isJsonNull(data) {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("rethinkdb: only objects can be decoded into %v: %v", v.Type(), err)
+	}
+
+	field := typeField()
+	var name string
+	if raw, ok := object[field]; ok {
+		json.Unmarshal(raw, &name)
+	}
+	t, ok := registeredType(name)
+	if !ok {
+		return fmt.Errorf("rethinkdb: no type registered for %v %q, use r.RegisterType()", field, name)
+	}
+	if !t.AssignableTo(v.Type()) {
+		return fmt.Errorf("rethinkdb: type %v registered as %q does not implement %v", t, name, v.Type())
+	}
+
+	value := reflect.New(t).Elem()
+	if err := decodeReflect(data, value); err != nil {
+		return err
+	}
+	v.Set(value)
+	return nil
+}
+
+// decodePathError records where in a document a decoding error happened.
+type decodePathError struct {
+	path string // e.g. ".powers.2.name"
+	err  error
+}
+
+func (e decodePathError) Error() string {
+	return fmt.Sprintf("%v: %v", strings.TrimPrefix(e.path, "."), e.err)
+}
+
+// withPath adds an element to the front of the path of a decoding error.
+func withPath(err error, element string) error {
+	if pathErr, ok := err.(decodePathError); ok {
+		return decodePathError{element + pathErr.path, pathErr.err}
+	}
+	return decodePathError{element, err}
+}
+
+// splitDecodeError separates a decoding error into the path of the value that
+// failed, if known, and the underlying error.
+func splitDecodeError(err error) (path string, cause error) {
+	cause = err
+	if pathErr, ok := err.(decodePathError); ok {
+		path, cause = pathErr.path, pathErr.err
+	}
+	// the json module knows which field it was decoding
+	if typeErr, ok := cause.(*json.UnmarshalTypeError); ok {
+		if typeErr.Field != "" {
+			path += "." + typeErr.Field
+		}
+		if numErr, ok := numberError(typeErr); ok {
+			cause = numErr
+		}
+	}
+	return strings.TrimPrefix(path, "."), cause
+}
+
+// numberError explains why the json module could not store a number in a
+// numeric type, which it reports the same way as a value of the wrong type.
+func numberError(typeErr *json.UnmarshalTypeError) (NumberError, bool) {
+	text := strings.TrimPrefix(typeErr.Value, "number ")
+	if text == typeErr.Value || typeErr.Type == nil {
+		return NumberError{}, false
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		// too big even for a float64
+		return NumberError{Value: text, Type: typeErr.Type, Reason: "it is out of range"}, true
+	}
+
+	reason := "it is out of range"
+	switch typeErr.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if number != math.Trunc(number) {
+			reason = "it has a fractional part"
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if number != math.Trunc(number) {
+			reason = "it has a fractional part"
+		} else if number < 0 {
+			reason = "it is negative"
+		}
+	case reflect.Float32:
+	default:
+		return NumberError{}, false
+	}
+	return NumberError{Value: text, Type: typeErr.Type, Reason: reason}, true
+}
+
+// allocFieldByIndex is like reflect.Value.FieldByIndex() but allocates any nil
+// embedded pointers it passes through.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func mapKeyValue(name string, keyType reflect.Type) (reflect.Value, error) {
+	switch keyType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(name).Convert(keyType), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(name, 10, keyType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(n).Convert(keyType), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(name, 10, keyType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(n).Convert(keyType), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported map key type: %v", keyType)
+}
+
+func decryptField(data json.RawMessage) (json.RawMessage, error) {
+	if isJsonNull(data) {
+		return data, nil
+	}
+	if fieldCipher == nil {
+		return nil, errors.New("no Cipher set for encrypted field, use r.SetCipher()")
+	}
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return fieldCipher.Decrypt(ciphertext)
+}
@@ -2,6 +2,7 @@ package rethinkgo
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	gocontext "context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"bufio"
 	p "github.com/christopherhesse/rethinkgo/ql2"
+	"sync"
 	"time"
 )
 
@@ -17,12 +19,43 @@ type connection struct {
 	// embed the net.Conn type, so that we can effectively define new methods on
 	// it (interfaces do not allow that)
 	net.Conn
+	// number of noreply queries sent since the last NOREPLY_WAIT
+	pendingNoreply int
+
+	// queries are written to a buffer and sent when flushed, so that several
+	// of them can go out in a single write
+	writeMutex sync.Mutex
+	writer     *bufio.Writer
+	// how long to wait for more noreply queries before sending them, or zero
+	// to send them straight away
+	flushDelay time.Duration
+	flushTimer *time.Timer
+	// error from a delayed flush, returned by the next write
+	flushErr error
 }
 
 var debugMode bool = false
 
-func serverConnect(address string, authkey string) (*connection, error) {
-	conn, err := net.Dial("tcp", address)
+// writer that raw frames are copied to, see SetWireCapture()
+var (
+	wireCaptureMutex sync.Mutex
+	wireCapture      io.Writer
+)
+
+// Dialer opens the network connection to a server, see ConnectOpts.
+type Dialer func(ctx gocontext.Context, network, address string) (net.Conn, error)
+
+// defaultDialer connects over TCP.
+func defaultDialer(ctx gocontext.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func serverConnect(dialer Dialer, address string, authkey string) (*connection, error) {
+	if dialer == nil {
+		dialer = defaultDialer
+	}
+	conn, err := dialer(gocontext.Background(), "tcp", address)
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +86,7 @@ func serverConnect(address string, authkey string) (*connection, error) {
 		return nil, fmt.Errorf("Failed to connect to server: %v", response)
 	}
 
-	return &connection{conn}, nil
+	return &connection{Conn: conn, writer: bufio.NewWriter(conn)}, nil
 }
 
 // SetDebug causes all queries sent to the server and responses received to be
@@ -66,18 +99,108 @@ func SetDebug(debug bool) {
 	debugMode = debug
 }
 
+// SetWireCapture copies every query and response frame sent on any session to
+// `w`, exactly as they appear on the wire, so that protocol problems can be
+// reported with a capture.  Each frame is preceded by a single byte, '>' for
+// a frame sent to the server and '<' for one received from it, and is made up
+// of the message length as a 4 byte little-endian number followed by the
+// serialized protocol buffer.  Set to nil to stop capturing.
+//
+// Example usage:
+//
+//  file, err := os.Create("rethinkdb.capture")
+//  r.SetWireCapture(file)
+func SetWireCapture(w io.Writer) {
+	wireCaptureMutex.Lock()
+	defer wireCaptureMutex.Unlock()
+	wireCapture = w
+}
+
+// captureFrame writes a frame to the wire capture, if there is one.  Errors
+// are ignored, capturing should not break the connection.
+func captureFrame(direction byte, data []byte) {
+	wireCaptureMutex.Lock()
+	defer wireCaptureMutex.Unlock()
+	if wireCapture == nil {
+		return
+	}
+	frame := make([]byte, 5, 5+len(data))
+	frame[0] = direction
+	binary.LittleEndian.PutUint32(frame[1:], uint32(len(data)))
+	wireCapture.Write(append(frame, data...))
+}
+
 // writeMessage writes a byte array to the stream preceeded by the length in
-// bytes.
+// bytes.  The message is buffered until .flush() is called.
 func (c *connection) writeMessage(data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.flushErr != nil {
+		err := c.flushErr
+		c.flushErr = nil
+		return err
+	}
+
+	captureFrame('>', data)
 	messageLength := uint32(len(data))
-	if err := binary.Write(c, binary.LittleEndian, messageLength); err != nil {
+	if err := binary.Write(c.writer, binary.LittleEndian, messageLength); err != nil {
 		return err
 	}
 
-	_, err := c.Write(data)
+	_, err := c.writer.Write(data)
 	return err
 }
 
+// flush sends any buffered messages to the server.
+func (c *connection) flush() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+	return c.writer.Flush()
+}
+
+// flushLater sends buffered messages after the flush delay, so that any
+// messages written in the meantime go out together.  The delayed write fails
+// if it takes longer than `timeout`, unless that is zero.
+func (c *connection) flushLater(timeout time.Duration) error {
+	if c.flushDelay == 0 {
+		return c.flush()
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.flushTimer == nil {
+		c.flushTimer = time.AfterFunc(c.flushDelay, func() {
+			c.writeMutex.Lock()
+			defer c.writeMutex.Unlock()
+
+			c.flushTimer = nil
+			// the deadline set by the query that wrote the messages has been
+			// cleared by now, so a stuck server would block here forever
+			if timeout != 0 {
+				c.SetWriteDeadline(time.Now().Add(timeout))
+				defer c.SetWriteDeadline(time.Time{})
+			}
+			if err := c.writer.Flush(); err != nil {
+				c.flushErr = err
+			}
+		})
+	}
+	return nil
+}
+
+// Close sends any buffered messages and closes the connection.
+func (c *connection) Close() error {
+	c.flush()
+	return c.Conn.Close()
+}
+
 // writeQuery writes a protobuf message to the connection.
 func (c *connection) writeQuery(protobuf *p.Query) error {
 	data, err := proto.Marshal(protobuf)
@@ -101,6 +224,7 @@ func (c *connection) readMessage() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	captureFrame('<', buffer)
 	return buffer, nil
 }
 
@@ -121,6 +245,9 @@ func (c *connection) executeQueryProtobuf(protobuf *p.Query) (responseProto *p.R
 	if err = c.writeQuery(protobuf); err != nil {
 		return
 	}
+	if err = c.flush(); err != nil {
+		return
+	}
 
 	for {
 		responseProto, err = c.readResponse()
@@ -137,10 +264,67 @@ func (c *connection) executeQueryProtobuf(protobuf *p.Query) (responseProto *p.R
 	return
 }
 
+// executeQueries sends several queries to the server before reading any of the
+// responses, so that the server can work on all of them at the same time.  The
+// responses are returned in a map keyed by token.
+func (c *connection) executeQueries(queryProtos []*p.Query, timeout time.Duration) (map[int64]*p.Response, error) {
+	if timeout == 0 {
+		c.SetDeadline(time.Time{})
+	} else {
+		c.SetDeadline(time.Now().Add(timeout))
+	}
+	defer c.SetDeadline(time.Time{})
+
+	pending := map[int64]bool{}
+	var maxToken int64
+	for _, queryProto := range queryProtos {
+		if debugMode {
+			fmt.Printf("rethinkdb: queryProto:\n%v", protobufToString(queryProto, 1))
+		}
+		if err := c.writeQuery(queryProto); err != nil {
+			return nil, err
+		}
+		pending[queryProto.GetToken()] = true
+		if queryProto.GetToken() > maxToken {
+			maxToken = queryProto.GetToken()
+		}
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	responses := map[int64]*p.Response{}
+	for len(pending) > 0 {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+
+		token := r.GetToken()
+		if pending[token] {
+			responses[token] = r
+			delete(pending, token)
+		} else if token > maxToken {
+			return nil, errors.New("rethinkdb: The server returned a response for a protobuf that was not submitted by us")
+		}
+	}
+	return responses, nil
+}
+
 // executeQuery is an internal function, shared by Rows iterator and the normal
 // Run() call. Runs a protocol buffer formatted query, returns a list of strings
 // and a status code.
 func (c *connection) executeQuery(queryProto *p.Query, timeout time.Duration) (result []*p.Datum, responseType p.Response_ResponseType, err error) {
+	r, err := c.sendQuery(queryProto, timeout)
+	if err != nil {
+		return
+	}
+	return parseResponse(r)
+}
+
+// sendQuery runs a query and returns the response without looking at it, a
+// lower level function used by .executeQuery()
+func (c *connection) sendQuery(queryProto *p.Query, timeout time.Duration) (*p.Response, error) {
 	if debugMode {
 		fmt.Printf("rethinkdb: queryProto:\n%v", protobufToString(queryProto, 1))
 	}
@@ -158,17 +342,29 @@ func (c *connection) executeQuery(queryProto *p.Query, timeout time.Duration) (r
 
 	// reset the deadline for the connection
 	c.SetDeadline(time.Time{})
+	return r, err
+}
 
-	if err != nil {
-		return
+// errorInfo describes a query run on the connection, for errors returned by
+// the server.
+func (c *connection) errorInfo(token int64, start time.Time) ErrorInfo {
+	info := ErrorInfo{Token: token, Attempt: 1, Elapsed: time.Since(start)}
+	if addr := c.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
 	}
+	return info
+}
+
+// parseResponse gets the results from a response, or the error if the response
+// is an error.
+func parseResponse(r *p.Response) (result []*p.Datum, responseType p.Response_ResponseType, err error) {
 	if debugMode {
 		fmt.Printf("rethinkdb: responseProto:\n%v", protobufToString(r, 1))
 	}
 
 	responseType = r.GetType()
 	switch responseType {
-	case p.Response_SUCCESS_ATOM, p.Response_SUCCESS_SEQUENCE, p.Response_SUCCESS_PARTIAL:
+	case p.Response_SUCCESS_ATOM, p.Response_SUCCESS_SEQUENCE, p.Response_SUCCESS_PARTIAL, p.Response_WAIT_COMPLETE, p.Response_SERVER_INFO:
 		result = r.Response
 	default:
 		// some sort of error
@@ -0,0 +1,345 @@
+package rethinkgo
+
+// Parts of, comparisons between and arithmetic on the server's time values.
+
+import (
+	"fmt"
+	"time"
+)
+
+// clock replaces the server's time, see SetClock()
+var clock func() time.Time
+
+// SetClock makes the driver use `now` for the current time instead of the
+// server's and the system's clocks, so that tests can control time.  r.Now()
+// is sent as the time `now` returns when the query is built, and helpers such
+// as .WithinLast() and ClockNow() use it as well.  nil goes back to the real
+// clocks.
+//
+// Example usage:
+//
+//  frozen := time.Date(2013, 5, 17, 14, 35, 12, 0, time.UTC)
+//  r.SetClock(func() time.Time { return frozen })
+//  defer r.SetClock(nil)
+func SetClock(now func() time.Time) {
+	clock = now
+}
+
+// ClockNow returns the current time from the clock set with SetClock(), or
+// the system's clock.  Use it for timestamps made by the client, for example
+// in InsertOpts.Computed, so that they follow the same clock as the driver.
+//
+// Example usage:
+//
+//  opts := r.InsertOpts{Computed: map[string]func(doc r.Map) interface{}{
+//      "created_at": func(doc r.Map) interface{} { return r.ClockNow() },
+//  }}
+func ClockNow() time.Time {
+	if clock != nil {
+		return clock()
+	}
+	return time.Now()
+}
+
+// Now returns the time the query started running on the server.  Every use of
+// it in a query returns the same time, unless a clock has been set with
+// SetClock().
+//
+// Example usage:
+//
+//  r.Table("heroes").Get(id).Update(r.Map{"last_seen": r.Now()})
+func Now() Exp {
+	return nullaryOperator(nowKind)
+}
+
+// NowOffset returns the time the query started running on the server, moved
+// by a duration, as .AddDuration() does.
+//
+// Example usage:
+//
+//  // sessions that have not been used in the last half hour
+//  r.Table("sessions").Filter(r.Row.Attr("last_used").Lt(r.NowOffset(-30 * time.Minute)))
+func NowOffset(d time.Duration) Exp {
+	return Now().AddDuration(d)
+}
+
+// Time creates a time from its parts on the server, given as year, month,
+// day and timezone, or as year, month, day, hours, minutes, seconds and
+// timezone.  The timezone is an offset such as "-07:00", and seconds may have
+// a fractional part.  For times known in advance, a time.Time can be used
+// directly instead.
+//
+// Example usage:
+//
+//  r.Time(2013, 5, 17, "Z")
+//  r.Time(r.Row.Attr("year"), 1, 1, 12, 30, 0, "+01:00")
+func Time(parts ...interface{}) Exp {
+	return Exp{kind: timeKind, args: parts}
+}
+
+// EpochTime creates a time, in UTC, from a number of seconds since the UNIX
+// epoch.
+//
+// Example usage:
+//
+//  r.Table("logins").Map(r.EpochTime(r.Row.Attr("timestamp")))
+func EpochTime(seconds interface{}) Exp {
+	return naryOperator(epochTimeKind, seconds)
+}
+
+// ISO8601Opts holds the options for r.ISO8601WithOpts().
+type ISO8601Opts struct {
+	// Timezone for strings that do not include one, an offset such as
+	// "-07:00".  Without it, such strings are an error.
+	DefaultTimezone string
+}
+
+// ISO8601 parses a time from an ISO 8601 string, such as
+// "2013-05-17T14:35:12-07:00", on the server.
+//
+// Example usage:
+//
+//  var response time.Time
+//  err := r.ISO8601("2013-05-17T14:35:12-07:00").Run(session).One(&response)
+func ISO8601(date interface{}) Exp {
+	return naryOperator(iso8601Kind, date)
+}
+
+// ISO8601WithOpts is like r.ISO8601(), with options given in a struct.
+//
+// Example usage:
+//
+//  opts := r.ISO8601Opts{DefaultTimezone: "-07:00"}
+//  r.Table("imports").Map(r.ISO8601WithOpts(r.Row.Attr("date"), opts))
+func ISO8601WithOpts(date interface{}, opts ISO8601Opts) Exp {
+	e := ISO8601(date)
+	if opts.DefaultTimezone != "" {
+		e = naryOperator(defaultTimezoneKind, e, opts.DefaultTimezone)
+	}
+	return e
+}
+
+// During returns true if a time is between `start` and `end`, including
+// `start` but not `end` unless changed with .LeftBound() and .RightBound().
+//
+// Example usage:
+//
+//  // battles that started in May 2013
+//  from := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+//  to := time.Date(2013, 6, 1, 0, 0, 0, 0, time.UTC)
+//  r.Table("battles").Filter(r.Row.Attr("started").During(from, to))
+func (e Exp) During(start, end interface{}) Exp {
+	return naryOperator(duringKind, e, start, end)
+}
+
+// InTimezone returns the same time in another timezone, which is an offset
+// such as "-07:00".
+//
+// Example usage:
+//
+//  var response time.Time
+//  err := r.Expr(t).InTimezone("+09:00").Run(session).One(&response)
+func (e Exp) InTimezone(timezone interface{}) Exp {
+	return naryOperator(inTimezoneKind, e, timezone)
+}
+
+// Timezone returns the timezone of a time, as an offset such as "-07:00".
+//
+// Example usage:
+//
+//  var response string
+//  err := r.Table("battles").Get(id).Attr("started").Timezone().Run(session).One(&response)
+func (e Exp) Timezone() Exp {
+	return naryOperator(timezoneKind, e)
+}
+
+// Date returns midnight at the start of the day a time falls on, in the
+// time's timezone.
+//
+// Example usage:
+//
+//  // battles that started today
+//  r.Table("battles").Filter(r.Row.Attr("started").Date().Eq(r.Now().Date()))
+func (e Exp) Date() Exp {
+	return naryOperator(dateKind, e)
+}
+
+// TimeOfDay returns the number of seconds since midnight, in the time's
+// timezone.
+//
+// Example usage:
+//
+//  // battles that started in the morning
+//  r.Table("battles").Filter(r.Row.Attr("started").TimeOfDay().Lt(12 * 60 * 60))
+func (e Exp) TimeOfDay() Exp {
+	return naryOperator(timeOfDayKind, e)
+}
+
+// Year returns the year of a time, in the time's timezone.
+//
+// Example usage:
+//
+//  r.Table("battles").Filter(r.Row.Attr("started").Year().Eq(2013))
+func (e Exp) Year() Exp {
+	return naryOperator(yearKind, e)
+}
+
+// Month returns the month of a time, from 1 for January up to 12 for
+// December, in the time's timezone.
+//
+// Example usage:
+//
+//  r.Table("battles").Filter(r.Row.Attr("started").Month().Eq(int(time.May)))
+func (e Exp) Month() Exp {
+	return naryOperator(monthKind, e)
+}
+
+// Day returns the day of the month of a time, starting at 1, in the time's
+// timezone.
+//
+// Example usage:
+//
+//  r.Table("battles").Filter(r.Row.Attr("started").Day().Eq(17))
+func (e Exp) Day() Exp {
+	return naryOperator(dayKind, e)
+}
+
+// DayOfWeek returns the day of the week of a time, from 1 for Monday up to 7
+// for Sunday, in the time's timezone.  Unlike time.Weekday, Sunday is 7 and
+// not 0.
+//
+// Example usage:
+//
+//  // battles that started at the weekend
+//  r.Table("battles").Filter(r.Row.Attr("started").DayOfWeek().Ge(6))
+func (e Exp) DayOfWeek() Exp {
+	return naryOperator(dayOfWeekKind, e)
+}
+
+// DayOfYear returns the day of the year of a time, starting at 1 for January
+// 1st, in the time's timezone.
+//
+// Example usage:
+//
+//  r.Table("battles").Filter(r.Row.Attr("started").DayOfYear().Eq(1))
+func (e Exp) DayOfYear() Exp {
+	return naryOperator(dayOfYearKind, e)
+}
+
+// Hours returns the hour of a time, from 0 up to 23, in the time's timezone.
+//
+// Example usage:
+//
+//  r.Table("battles").Filter(r.Row.Attr("started").Hours().Ge(22))
+func (e Exp) Hours() Exp {
+	return naryOperator(hoursKind, e)
+}
+
+// Minutes returns the minutes into the hour of a time, from 0 up to 59.
+//
+// Example usage:
+//
+//  r.Table("battles").Filter(r.Row.Attr("started").Minutes().Eq(0))
+func (e Exp) Minutes() Exp {
+	return naryOperator(minutesKind, e)
+}
+
+// Seconds returns the seconds into the minute of a time, from 0 up to, but
+// not including, 60, with the milliseconds as a fractional part.
+//
+// Example usage:
+//
+//  var response float64
+//  err := r.Table("battles").Get(id).Attr("started").Seconds().Run(session).One(&response)
+func (e Exp) Seconds() Exp {
+	return naryOperator(secondsKind, e)
+}
+
+// ToEpochTime returns a time as the number of seconds since the UNIX epoch,
+// with the milliseconds as a fractional part.
+//
+// Example usage:
+//
+//  var response float64
+//  err := r.Table("battles").Get(id).Attr("started").ToEpochTime().Run(session).One(&response)
+func (e Exp) ToEpochTime() Exp {
+	return naryOperator(toEpochTimeKind, e)
+}
+
+// ToISO8601 returns a time as an ISO 8601 string, such as
+// "2013-05-17T14:35:12.000-07:00".
+//
+// Example usage:
+//
+//  var response string
+//  err := r.Table("battles").Get(id).Attr("started").ToISO8601().Run(session).One(&response)
+func (e Exp) ToISO8601() Exp {
+	return naryOperator(toIso8601Kind, e)
+}
+
+// AddDuration moves a time forward by a duration, or back if it is negative.
+// The server keeps times to the millisecond, so smaller parts of the duration
+// are lost.
+//
+// Example usage:
+//
+//  // battles that ended less than an hour after they started
+//  r.Table("battles").Filter(r.Row.Attr("ended").Lt(r.Row.Attr("started").AddDuration(time.Hour)))
+func (e Exp) AddDuration(d time.Duration) Exp {
+	return e.Add(d.Seconds())
+}
+
+// AddDays moves a time forward by a number of days, or back if it is negative.
+// Days are counted as 24 hours, so the time of day can change when a daylight
+// saving change falls in between.
+//
+// Example usage:
+//
+//  r.Table("subscriptions").Get(id).Update(r.Map{"expires": r.Row.Attr("expires").AddDays(30)})
+func (e Exp) AddDays(days int) Exp {
+	return e.AddDuration(time.Duration(days) * 24 * time.Hour)
+}
+
+// StartOfDay returns midnight at the start of the day a time falls on, in the
+// given timezone, which is an offset such as "-07:00".  The result is in that
+// timezone as well.
+//
+// Example usage:
+//
+//  // days on which battles started, in California
+//  r.Table("battles").Map(r.Row.Attr("started").StartOfDay("-08:00")).Distinct()
+func (e Exp) StartOfDay(timezone string) Exp {
+	return naryOperator(dateKind, naryOperator(inTimezoneKind, e, timezone))
+}
+
+// TruncateTo rounds a time down to the start of the "minute", "hour", "day",
+// "month" or "year" it falls in, keeping its timezone.  Any other unit
+// panics.
+//
+// Example usage:
+//
+//  // hours in which someone logged in
+//  r.Table("logins").Map(r.Row.Attr("time").TruncateTo("hour")).Distinct()
+func (e Exp) TruncateTo(unit string) Exp {
+	part := func(kind expressionKind) Exp {
+		return naryOperator(kind, e)
+	}
+	year, month, day := part(yearKind), part(monthKind), part(dayKind)
+	hours, minutes := part(hoursKind), part(minutesKind)
+	switch unit {
+	case "minute":
+	case "hour":
+		minutes = Expr(0)
+	case "day":
+		return naryOperator(dateKind, e)
+	case "month":
+		day = Expr(1)
+		hours, minutes = Expr(0), Expr(0)
+	case "year":
+		month, day = Expr(1), Expr(1)
+		hours, minutes = Expr(0), Expr(0)
+	default:
+		panic(fmt.Sprintf("rethinkdb: .TruncateTo() unit must be one of minute, hour, day, month or year, not %q", unit))
+	}
+	return naryOperator(timeKind, year, month, day, hours, minutes, 0, part(timezoneKind))
+}
@@ -6,9 +6,18 @@ import (
 	"strings"
 )
 
-func datumMarshal(v interface{}) (*p.Term, error) {
+// datumMarshal converts a value to a term.  Unless `binary` is set, binary data
+// is sent as base64 strings, for servers that have no BINARY pseudo-type.
+func datumMarshal(v interface{}, binary bool) (*p.Term, error) {
 	// convert arbitrary types to a datum tree using the json module
-	data, err := json.Marshal(v)
+	value, err := encodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if !binary {
+		value = withoutBinary(value)
+	}
+	data, err := json.Marshal(value)
 	if err != nil {
 		return nil, err
 	}
@@ -37,10 +46,16 @@ func datumUnmarshal(datum *p.Datum, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, v)
+	return decodeJson(data, v)
 }
 
+// datumToJson converts a datum to JSON, converting any pseudo-types in it
+// such as times into plain JSON values.
 func datumToJson(datum *p.Datum) ([]byte, error) {
+	return datumToJsonFormat(datum, pseudoTypeFormat{})
+}
+
+func datumToJsonFormat(datum *p.Datum, format pseudoTypeFormat) ([]byte, error) {
 	switch datum.GetType() {
 	case p.Datum_R_NULL:
 		return json.Marshal(nil)
@@ -53,7 +68,7 @@ func datumToJson(datum *p.Datum) ([]byte, error) {
 	case p.Datum_R_ARRAY:
 		items := []string{}
 		for _, d := range datum.GetRArray() {
-			item, err := datumToJson(d)
+			item, err := datumToJsonFormat(d, format)
 			if err != nil {
 				return nil, err
 			}
@@ -61,6 +76,9 @@ func datumToJson(datum *p.Datum) ([]byte, error) {
 		}
 		return []byte("[" + strings.Join(items, ",") + "]"), nil
 	case p.Datum_R_OBJECT:
+		if data, ok, err := pseudoTypeToJson(datum, format); ok {
+			return data, err
+		}
 		pairs := []string{}
 		for _, assoc := range datum.GetRObject() {
 			raw_key := assoc.GetKey()
@@ -71,7 +89,7 @@ func datumToJson(datum *p.Datum) ([]byte, error) {
 			if err != nil {
 				return nil, err
 			}
-			val, err := datumToJson(raw_val)
+			val, err := datumToJsonFormat(raw_val, format)
 			if err != nil {
 				return nil, err
 			}
@@ -0,0 +1,63 @@
+package rethinkgo
+
+// Check that functions only use terms the server considers deterministic, so
+// that writes using them do not need .Atomic(false).
+
+import (
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"strings"
+)
+
+// nonDeterministicTerms lists the terms that may give a different result each
+// time they are run, along with why.
+var nonDeterministicTerms = map[p.Term_TermType]string{
+	p.Term_JAVASCRIPT: "runs javascript",
+	p.Term_DB:         "reads a database",
+	p.Term_TABLE:      "reads a table",
+	p.Term_DB_LIST:    "lists databases",
+	p.Term_TABLE_LIST: "lists tables",
+	p.Term_SAMPLE:     "picks random rows",
+	p.Term_RANDOM:     "picks a random number",
+	p.Term_UUID:       "generates a random UUID",
+}
+
+// Deterministic marks a function, or an expression using r.Row, as one that must
+// be deterministic.  If it is not, running the query fails with an error
+// naming the offending term, instead of the server refusing to run the write
+// unless it is marked with .Atomic(false).
+//
+// Example usage:
+//
+//  addStrength := func(row r.Exp) r.Exp {
+//      return r.Expr(r.Map{"strength": row.Attr("strength").Add(1)})
+//  }
+//  err := r.Table("heroes").Update(r.Deterministic(addStrength)).Run(session).Exec()
+func Deterministic(f interface{}) Exp {
+	return naryOperator(deterministicKind, f)
+}
+
+// CheckDeterministic returns an error if a function, or an expression using
+// r.Row, uses terms that the server considers non-deterministic, such as
+// r.Js() or reading from a table.
+//
+// Example usage:
+//
+//  if err := r.CheckDeterministic(mapping); err != nil {
+//      query = query.Atomic(false)
+//  }
+func CheckDeterministic(f interface{}) error {
+	_, err := context{}.buildProtobuf(funcWrapper(Deterministic(f), -1))
+	return err
+}
+
+// checkDeterministic panics if a term is not deterministic, otherwise it
+// returns the term unchanged.
+func checkDeterministic(term *p.Term) *p.Term {
+	walkTerm(term, nil, func(t *p.Term, path []string) {
+		if reason, ok := nonDeterministicTerms[t.GetType()]; ok {
+			panic(fmt.Sprintf("function is not deterministic, %v %v at %v", t.GetType(), reason, strings.Join(path, " > ")))
+		}
+	})
+	return term
+}
@@ -0,0 +1,122 @@
+package rethinkgo
+
+// Store integer enums by name, so that the values in the database do not
+// change meaning when the constants are renumbered.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var enumRegistry = struct {
+	sync.RWMutex
+	names  map[reflect.Type]map[int64]string
+	values map[reflect.Type]map[string]int64
+}{
+	names:  map[reflect.Type]map[int64]string{},
+	values: map[reflect.Type]map[string]int64{},
+}
+
+// RegisterEnum registers the values of an integer enum type, named by their
+// String() methods.  Struct fields of that type tagged with
+// `rethinkdb:",string"` are stored as the name of their value instead of the
+// number.  When decoding, numbers are still accepted, so that rows written
+// before the tag was added can be read.
+//
+// Example usage:
+//
+//  type Alignment int
+//
+//  const (
+//      Good Alignment = iota
+//      Evil
+//  )
+//
+//  func (a Alignment) String() string { ... }
+//
+//  type Character struct {
+//      Name      string    `json:"name"`
+//      Alignment Alignment `json:"alignment" rethinkdb:",string"`
+//  }
+//
+//  r.RegisterEnum(Good, Evil)
+func RegisterEnum(values ...fmt.Stringer) {
+	enumRegistry.Lock()
+	defer enumRegistry.Unlock()
+	for _, value := range values {
+		v := reflect.ValueOf(value)
+		n, ok := enumNumber(v)
+		if !ok {
+			panic(fmt.Sprintf("rethinkdb: RegisterEnum() requires integer values, got %v", v.Type()))
+		}
+		if enumRegistry.names[v.Type()] == nil {
+			enumRegistry.names[v.Type()] = map[int64]string{}
+			enumRegistry.values[v.Type()] = map[string]int64{}
+		}
+		name := value.String()
+		enumRegistry.names[v.Type()][n] = name
+		enumRegistry.values[v.Type()][name] = n
+	}
+}
+
+// enumNumber returns the value of an integer as an int64.
+func enumNumber(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// encodeEnum returns the registered name of an enum value.
+func encodeEnum(v reflect.Value) (interface{}, error) {
+	n, ok := enumNumber(v)
+	if !ok {
+		return nil, fmt.Errorf("fields tagged with \",string\" must be integers, not %v", v.Type())
+	}
+	enumRegistry.RLock()
+	names, registered := enumRegistry.names[v.Type()]
+	name, ok := names[n]
+	enumRegistry.RUnlock()
+	if !registered {
+		return nil, fmt.Errorf("enum type %v is not registered, use r.RegisterEnum()", v.Type())
+	}
+	if !ok {
+		return nil, fmt.Errorf("no name registered for %v value %v", v.Type(), n)
+	}
+	return name, nil
+}
+
+// decodeEnum sets an enum from its registered name, or from a number.
+func decodeEnum(data []byte, v reflect.Value) error {
+	if isJsonNull(data) {
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		// stored before the field was tagged
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+
+	enumRegistry.RLock()
+	values, registered := enumRegistry.values[v.Type()]
+	n, ok := values[name]
+	enumRegistry.RUnlock()
+	if !registered {
+		return fmt.Errorf("enum type %v is not registered, use r.RegisterEnum()", v.Type())
+	}
+	if !ok {
+		return fmt.Errorf("unknown %v name %q", v.Type(), name)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(n)
+	default:
+		v.SetUint(uint64(n))
+	}
+	return nil
+}
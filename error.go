@@ -3,6 +3,10 @@ package rethinkgo
 import (
 	"fmt"
 	p "github.com/christopherhesse/rethinkgo/ql2"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 func formatError(message string, response *p.Response) string {
@@ -38,6 +42,7 @@ func getBacktraceFrames(response *p.Response) []string {
 //   err := r.Table("heroes").ArrayToStream().ArrayToStream().Run(session).Err()
 type ErrBadQuery struct {
 	response *p.Response
+	Info     ErrorInfo
 }
 
 func (e ErrBadQuery) Error() string {
@@ -53,6 +58,7 @@ func (e ErrBadQuery) Error() string {
 //   err := r.RuntimeError("error time!").Run(session).Err()
 type ErrRuntime struct {
 	response *p.Response
+	Info     ErrorInfo
 }
 
 func (e ErrRuntime) Error() string {
@@ -63,12 +69,59 @@ func (e ErrRuntime) Error() string {
 // library, for instance a malformed protocol buffer.
 type ErrBrokenClient struct {
 	response *p.Response
+	Info     ErrorInfo
 }
 
 func (e ErrBrokenClient) Error() string {
 	return formatError("Whoops, looks like there's a bug in this client library, please report it at https://github.com/christopherhesse/rethinkgo/issues/new", e.response)
 }
 
+// ErrorInfo describes the query that an error from the server was returned
+// for, so that it can be matched up with the server's logs.
+//
+// Example usage:
+//
+//  err := r.Table("table_that_doesnt_exist").Run(session).Err()
+//  if info, ok := r.GetErrorInfo(err); ok {
+//      log.Printf("query %v to %v failed after %v: %v", info.Token, info.RemoteAddr, info.Elapsed, err)
+//  }
+type ErrorInfo struct {
+	Token      int64         // token of the query on its connection
+	Attempt    int           // 1 for the first time the query was run, higher if it was retried
+	RemoteAddr string        // address of the server, e.g. "10.0.0.2:28015"
+	Elapsed    time.Duration // time from sending the query to getting the error
+}
+
+// GetErrorInfo returns the ErrorInfo of an error returned by the server.
+func GetErrorInfo(err error) (ErrorInfo, bool) {
+	switch e := err.(type) {
+	case ErrBadQuery:
+		return e.Info, true
+	case ErrRuntime:
+		return e.Info, true
+	case ErrBrokenClient:
+		return e.Info, true
+	}
+	return ErrorInfo{}, false
+}
+
+// withErrorInfo sets the ErrorInfo of an error returned by the server, other
+// errors are returned unchanged.
+func withErrorInfo(err error, info ErrorInfo) error {
+	switch e := err.(type) {
+	case ErrBadQuery:
+		e.Info = info
+		return e
+	case ErrRuntime:
+		e.Info = info
+		return e
+	case ErrBrokenClient:
+		e.Info = info
+		return e
+	}
+	return err
+}
+
 // ErrWrongResponseType is returned when .Exec(), .One(). or .All() have
 // been used, but the expected response type does not match the type we got
 // from the server.
@@ -84,3 +137,159 @@ type ErrWrongResponseType struct {
 func (e ErrWrongResponseType) Error() string {
 	return "rethinkdb: Wrong response type, you may have used the wrong one of: .Exec(), .One(), .All()"
 }
+
+// ErrRowCount is returned by .One() when it is used on a sequence that does
+// not have exactly one row.
+//
+// Example usage:
+//
+//  var hero interface{}
+//  err := r.Table("heroes").Filter(r.Map{"name": "Nobody"}).Run(session).One(&hero)
+type ErrRowCount struct {
+	Count int // number of rows found, reading stops at 2
+}
+
+func (e ErrRowCount) Error() string {
+	if e.Count == 0 {
+		return "rethinkdb: .One() expected one row, but the sequence was empty"
+	}
+	return "rethinkdb: .One() expected one row, but the sequence had more than one"
+}
+
+// BuildError is returned when a query cannot be converted into a form that can
+// be sent to the server, for example because an option is used in the wrong
+// place, or a term needs a newer server.
+//
+// Example usage:
+//
+//  err := r.Table("heroes").Filter(func(row r.Exp) r.Exp {
+//      return row.Attr("name").Durability("soft")
+//  }).Check(session)
+//  // err.Path is ["FILTER arg 1", "FUNC arg 1"]
+type BuildError struct {
+	Message string
+	// Terms leading from the root of the query to the part that could not be
+	// converted, each with the position of the next one in it.
+	Path []string
+}
+
+func (e BuildError) Error() string {
+	if len(e.Path) == 0 {
+		return "rethinkdb: " + e.Message
+	}
+	return fmt.Sprintf("rethinkdb: %v, at %v", e.Message, strings.Join(e.Path, " > "))
+}
+
+// ErrTooManyRows is returned by .All() when the query returns more rows than
+// RunOpts.MaxRows allows.
+//
+// Example usage:
+//
+//  var heroes []Hero
+//  err := r.Table("heroes").RunWith(session, r.RunOpts{MaxRows: 10000}).All(&heroes)
+type ErrTooManyRows struct {
+	Limit int // the MaxRows that was exceeded
+}
+
+func (e ErrTooManyRows) Error() string {
+	return fmt.Sprintf("rethinkdb: Query returned more than the limit of %v rows", e.Limit)
+}
+
+// ErrNoMoreRows is returned by .Peek() when there is no row after the current
+// one.
+type ErrNoMoreRows struct{}
+
+func (e ErrNoMoreRows) Error() string {
+	return "rethinkdb: .Peek() found no more rows"
+}
+
+// IndexDriftError is returned by EnsureIndexes() when indexes that already
+// exist were created with a different Function, Multi or Geo option than
+// their IndexSpec.  The indexes are left as they are.
+type IndexDriftError struct {
+	Table string
+	// what differs for each index, keyed by the index name, e.g.
+	// "multi is false on the server"
+	Differences map[string]string
+}
+
+func (e IndexDriftError) Error() string {
+	var names []string
+	for name := range e.Differences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var differences []string
+	for _, name := range names {
+		differences = append(differences, fmt.Sprintf("%v: %v", name, e.Differences[name]))
+	}
+	return fmt.Sprintf("rethinkdb: Indexes on table %v differ from their specs, %v", e.Table, strings.Join(differences, "; "))
+}
+
+// WriteError indicates that some of the documents in a write query could not
+// be written, see WriteResponse.Err().
+type WriteError struct {
+	FirstError string // the message for the first document that failed
+	Errors     int    // the number of documents that failed
+}
+
+func (e WriteError) Error() string {
+	if e.Errors == 1 {
+		return fmt.Sprintf("rethinkdb: Write failed: %v", e.FirstError)
+	}
+	return fmt.Sprintf("rethinkdb: %v writes failed, first error: %v", e.Errors, e.FirstError)
+}
+
+// DecodeError is returned by .Scan(), .One() and .All() when a row from the
+// server could not be decoded into the destination.
+//
+// Example usage:
+//
+//  var heroes []Hero
+//  err := r.Table("heroes").Run(session).All(&heroes)
+//  if decodeErr, ok := err.(r.DecodeError); ok {
+//      fmt.Println("bad field:", decodeErr.Path, "in row:", decodeErr.Data)
+//  }
+type DecodeError struct {
+	Type reflect.Type // the type of the destination
+	Data string       // the JSON of the row, truncated if it is long
+	Path string       // the field that failed, e.g. "powers.2.name", if known
+	Err  error        // the underlying error
+}
+
+// maxDecodeErrorData is the most JSON that is kept in a DecodeError
+const maxDecodeErrorData = 256
+
+func newDecodeError(dest interface{}, data []byte, err error) DecodeError {
+	path, cause := splitDecodeError(err)
+	snippet := string(data)
+	if len(snippet) > maxDecodeErrorData {
+		snippet = snippet[:maxDecodeErrorData] + "..."
+	}
+	return DecodeError{
+		Type: reflect.TypeOf(dest),
+		Data: snippet,
+		Path: path,
+		Err:  cause,
+	}
+}
+
+// NumberError is the Err of a DecodeError when a number from the server does
+// not fit in the numeric type it is decoded into, because it is out of range,
+// has a fractional part, or is negative and the type is unsigned.
+type NumberError struct {
+	Value  string       // the number as sent by the server, e.g. "1.5"
+	Type   reflect.Type // the type it was decoded into
+	Reason string       // why it does not fit, e.g. "it has a fractional part"
+}
+
+func (e NumberError) Error() string {
+	return fmt.Sprintf("cannot store %v in %v, %v", e.Value, e.Type, e.Reason)
+}
+
+func (e DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("rethinkdb: Could not decode row into %v: %v, row: %v", e.Type, e.Err, e.Data)
+	}
+	return fmt.Sprintf("rethinkdb: Could not decode %v of row into %v: %v, row: %v", e.Path, e.Type, e.Err, e.Data)
+}
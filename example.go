@@ -0,0 +1,78 @@
+package rethinkgo
+
+// The heroes, villains and lairs used in the examples in the documentation.
+
+import (
+	"time"
+)
+
+// exampleHeroes are loaded into the "heroes" table by LoadExampleData()
+var exampleHeroes = List{
+	Map{"id": "edc3a46b-95a0-4f64-9d1c-0dd7d83c4bcd", "name": "Doctor Strange", "real_name": "Stephen Vincent Strange", "strength": 3, "durability": 6, "intelligence": 4, "energy": 7, "fighting": 7, "speed": 5},
+	Map{"id": "59d1ad55-a61e-49d9-a375-0fb014b0e6ea", "name": "Storm", "real_name": "Ororo Munroe", "strength": 2, "durability": 3, "intelligence": 5, "energy": 6, "fighting": 5, "speed": 5},
+	Map{"id": "1a760d0b-57ef-42a8-9fec-c3a1f34930aa", "name": "Iron Man", "real_name": "Anthony Edward \"Tony\" Stark", "strength": 6, "durability": 6, "intelligence": 6, "energy": 6, "fighting": 3, "speed": 5},
+	Map{"id": "8f3c5b6e-0d5a-4c1e-9b1e-6a0f3e1c2d41", "name": "Elektra", "real_name": "Elektra Natchios", "strength": 4, "durability": 2, "intelligence": 4, "energy": 3, "fighting": 7, "speed": 6},
+	Map{"id": "3e2f7a1c-5b4d-4e8f-a6c2-9d1b0e7f4a52", "name": "Thor", "real_name": "Thor Odinson", "strength": 7, "durability": 6, "intelligence": 2, "energy": 6, "fighting": 4, "speed": 7},
+	Map{"id": "b7d4e2a9-1c3f-4a6b-8e5d-2f9c0a1b3e63", "name": "Nightcrawler", "real_name": "Kurt Wagner", "strength": 2, "durability": 3, "intelligence": 3, "energy": 2, "fighting": 4, "speed": 7},
+	Map{"id": "c5a9f1e3-7d2b-4f0a-b3c6-4e8d1a2f5b74", "name": "Professor X", "real_name": "Charles Francis Xavier", "strength": 1, "durability": 1, "intelligence": 6, "energy": 5, "fighting": 3, "speed": 1},
+	Map{"id": "d1e6b3f7-2a9c-4d5e-9f0b-7c3a2e4d6f85", "name": "Northstar", "real_name": "Jean-Paul Beaubier", "strength": 2, "durability": 3, "intelligence": 2, "energy": 3, "fighting": 4, "speed": 7},
+	Map{"id": "e9f2c4a6-3b1d-4e7f-a0c5-8d2b4f6e1a96", "name": "Archangel", "real_name": "Warren Kenneth Worthington III", "strength": 2, "durability": 4, "intelligence": 2, "energy": 1, "fighting": 4, "speed": 3},
+	Map{"id": "f4a7d9c2-6e3b-4f1a-b8d0-1e5c7a9b3d07", "name": "Thing", "real_name": "Benjamin Jacob Grimm", "strength": 6, "durability": 6, "intelligence": 3, "energy": 1, "fighting": 5, "speed": 2},
+}
+
+// exampleVillains are loaded into the "villains" table by LoadExampleData()
+var exampleVillains = List{
+	Map{"id": "c0d1b94f-b07e-40c3-a1db-448e645daedc", "name": "Magneto", "real_name": "Max Eisenhardt", "strength": 2, "durability": 6, "intelligence": 6, "energy": 6, "fighting": 3, "speed": 4},
+	Map{"id": "ab140a9c-63d1-455e-862e-045ad7f57ae3", "name": "Sabretooth", "real_name": "Victor Creed", "strength": 4, "durability": 4, "intelligence": 2, "energy": 1, "fighting": 7, "speed": 2},
+	Map{"id": "5b8e2d4f-9a1c-4e3b-a7f6-0c2d8e1b4a18", "name": "Doctor Doom", "real_name": "Victor von Doom", "strength": 4, "durability": 6, "intelligence": 6, "energy": 6, "fighting": 4, "speed": 5},
+	Map{"id": "6c9f3e5a-0b2d-4f4c-b8a7-1d3e9f2c5b29", "name": "Galactus", "real_name": "Galan", "strength": 7, "durability": 7, "intelligence": 7, "energy": 7, "fighting": 3, "speed": 7},
+	Map{"id": "7d0a4f6b-1c3e-4a5d-9b8c-2e4f0a3d6c3a", "name": "Omega Red", "real_name": "Arkady Rossovich", "strength": 4, "durability": 5, "intelligence": 2, "energy": 5, "fighting": 6, "speed": 3},
+}
+
+// exampleLairs are loaded into the "lairs" table by LoadExampleData(), their
+// primary key is "villain_id"
+var exampleLairs = List{
+	Map{"villain_id": "c0d1b94f-b07e-40c3-a1db-448e645daedc", "lair": "Asteroid M"},
+	Map{"villain_id": "5b8e2d4f-9a1c-4e3b-a7f6-0c2d8e1b4a18", "lair": "Castle Doom"},
+	Map{"villain_id": "6c9f3e5a-0b2d-4f4c-b8a7-1d3e9f2c5b29", "lair": "Taa II"},
+}
+
+// LoadExampleData creates the "heroes", "villains" and "lairs" tables used in
+// the examples in this documentation in the session's database, and fills
+// them with data, so that the examples can be run as they are.  Rows that are
+// already there are replaced, other rows are left alone.
+//
+// Example usage:
+//
+//  err := r.LoadExampleData(session)
+//  var response []interface{}
+//  err = r.Table("villains").EqJoin("id", r.Table("lairs"), "villain_id").Run(session).All(&response)
+func LoadExampleData(session *Session) error {
+	tables := []struct {
+		spec TableSpec
+		rows List
+	}{
+		{TableSpec{Name: "heroes"}, exampleHeroes},
+		{TableSpec{Name: "villains"}, exampleVillains},
+		{TableSpec{Name: "lairs", PrimaryKey: "villain_id"}, exampleLairs},
+	}
+
+	for _, table := range tables {
+		if err := EnsureTable(session, table.spec); err != nil {
+			return err
+		}
+		if err := Table(table.spec.Name).WaitReady(session, 30*time.Second); err != nil {
+			return err
+		}
+
+		var response WriteResponse
+		query := Table(table.spec.Name).Insert(table.rows).overwrite(true)
+		if err := query.Run(session).One(&response); err != nil {
+			return err
+		}
+		if err := response.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package rethinkgo
+
+// Run queries on clusters in several regions, reading from the closest one.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Federation holds sessions to clusters in several regions.  Reads run on the
+// healthy region with the lowest latency, measured with Session.Ping(), and
+// writes always run on the primary region.  Like a Session, a Federation
+// should not be shared between goroutines.
+type Federation struct {
+	primary *federatedRegion
+	// all of the regions, including the primary, sorted by name
+	regions []*federatedRegion
+	// how often the regions are pinged, see SetProbeInterval()
+	probeInterval time.Duration
+	lastProbe     time.Time
+}
+
+// federatedRegion is one of the regions of a Federation.
+type federatedRegion struct {
+	name    string
+	session *Session
+	healthy bool
+	// round trip of the last successful ping
+	latency time.Duration
+	// why the region is not healthy
+	err error
+}
+
+// RegionStatus describes one of the regions of a Federation, see
+// Federation.Status().
+type RegionStatus struct {
+	Name    string
+	Primary bool
+	Healthy bool
+	// Round trip time of the last successful probe.
+	Latency time.Duration
+	// Error that made the region unhealthy, if it is.
+	Err error
+}
+
+var defaultProbeInterval = 10 * time.Second
+
+// NewFederation creates a Federation from sessions connected to each region,
+// keyed by the name of the region.  Writes are sent to the region named
+// `primary`.  All of the regions are probed before it returns.
+//
+// Example usage:
+//
+//  us, err := r.Connect("us-east.db.example.com:28015", "test")
+//  eu, err := r.Connect("eu-west.db.example.com:28015", "test")
+//  federation, err := r.NewFederation("us-east", map[string]*r.Session{"us-east": us, "eu-west": eu})
+//  rows := federation.Run(r.Table("heroes"))
+func NewFederation(primary string, sessions map[string]*Session) (*Federation, error) {
+	if sessions[primary] == nil {
+		return nil, fmt.Errorf("rethinkdb: No session for the primary region %q", primary)
+	}
+
+	var names []string
+	for name := range sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f := &Federation{probeInterval: defaultProbeInterval}
+	for _, name := range names {
+		region := &federatedRegion{name: name, session: sessions[name], healthy: true}
+		if name == primary {
+			f.primary = region
+		}
+		f.regions = append(f.regions, region)
+	}
+	f.Probe()
+	return f, nil
+}
+
+// SetProbeInterval sets how often the regions are pinged to measure their
+// latency and find out whether they are healthy.  Probes are made before
+// running a query when the last one is older than the interval, so idle
+// federations do not ping.  The default is 10 seconds.
+//
+// Example usage:
+//
+//  federation.SetProbeInterval(time.Minute)
+func (f *Federation) SetProbeInterval(interval time.Duration) {
+	f.probeInterval = interval
+}
+
+// Probe pings every region now, reconnecting to regions that are not
+// healthy first.
+//
+// Example usage:
+//
+//  federation.Probe()
+func (f *Federation) Probe() {
+	for _, region := range f.regions {
+		region.probe()
+	}
+	f.lastProbe = time.Now()
+}
+
+// probe pings the region, updating its latency and health.
+func (region *federatedRegion) probe() {
+	if !region.healthy {
+		if err := region.session.Reconnect(); err != nil {
+			region.err = err
+			return
+		}
+	}
+	latency, err := region.session.Ping()
+	if err != nil {
+		region.healthy, region.err = false, err
+		return
+	}
+	region.healthy, region.latency, region.err = true, latency, nil
+}
+
+// Status returns the state of each region as of the last probe, sorted by
+// name.
+//
+// Example usage:
+//
+//  for _, region := range federation.Status() {
+//      fmt.Println(region.Name, region.Healthy, region.Latency)
+//  }
+func (f *Federation) Status() []RegionStatus {
+	var status []RegionStatus
+	for _, region := range f.regions {
+		status = append(status, RegionStatus{
+			Name:    region.name,
+			Primary: region == f.primary,
+			Healthy: region.healthy,
+			Latency: region.latency,
+			Err:     region.err,
+		})
+	}
+	return status
+}
+
+// Run runs a query on the federation, see RunWith().
+func (f *Federation) Run(query Exp) *Rows {
+	return f.RunWith(query, RunOpts{})
+}
+
+// RunWith runs a query with options on the federation.  Queries that write,
+// or change tables, databases or indexes, run on the primary region.  Other
+// queries run on the healthy region with the lowest latency, and if that fails
+// with a network error, the region is marked unhealthy and the query is run on
+// the next one.
+//
+// Example usage:
+//
+//  rows := federation.RunWith(r.Table("heroes"), r.RunOpts{UseOutdated: r.Bool(true)})
+func (f *Federation) RunWith(query Exp, opts RunOpts) *Rows {
+	if time.Since(f.lastProbe) >= f.probeInterval {
+		f.Probe()
+	}
+
+	queryProto, err := f.primary.session.buildQuery(query, opts)
+	if err != nil {
+		return &Rows{lasterr: err}
+	}
+	if !canRetry(queryProto) {
+		return f.primary.session.RunWith(query, opts)
+	}
+
+	var rows *Rows
+	for _, region := range f.byLatency() {
+		rows = region.session.RunWith(query, opts)
+		if !isNetworkError(rows.Err()) {
+			return rows
+		}
+		region.healthy, region.err = false, rows.Err()
+	}
+	if rows == nil {
+		return &Rows{lasterr: fmt.Errorf("rethinkdb: No healthy region to run the query on")}
+	}
+	return rows
+}
+
+// byLatency returns the healthy regions, closest first.
+func (f *Federation) byLatency() []*federatedRegion {
+	var regions []*federatedRegion
+	for _, region := range f.regions {
+		if region.healthy {
+			regions = append(regions, region)
+		}
+	}
+	sort.Stable(regionsByLatency(regions))
+	return regions
+}
+
+type regionsByLatency []*federatedRegion
+
+func (a regionsByLatency) Len() int           { return len(a) }
+func (a regionsByLatency) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a regionsByLatency) Less(i, j int) bool { return a[i].latency < a[j].latency }
+
+// Close closes the sessions to all of the regions, returning the first error.
+//
+// Example usage:
+//
+//  err := federation.Close()
+func (f *Federation) Close() error {
+	var firstErr error
+	for _, region := range f.regions {
+		if err := region.session.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
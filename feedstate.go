@@ -0,0 +1,103 @@
+package rethinkgo
+
+// Handle the state documents that changefeeds send with .IncludeStates()
+// separately from the changes themselves.
+
+import (
+	p "github.com/christopherhesse/rethinkgo/ql2"
+)
+
+// FeedHandler holds the callbacks used by .HandleFeed().  Callbacks that are
+// nil are skipped.
+type FeedHandler struct {
+	// Called when the feed starts sending the initial values of the rows,
+	// before any changes.
+	OnInitializing func()
+	// Called once the feed is sending changes as they happen.
+	OnReady func()
+	// Called with each change, or initial value, that the feed sends.
+	// Returning an error stops reading the feed.
+	OnChange func(change RawChange) error
+	// Called with the error that ended the feed, if it failed.
+	OnError func(err error)
+}
+
+// IncludeStates makes the .Changes() it follows also send documents saying
+// what state the feed is in, see .HandleFeed().  This needs server >= 2.0.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Changes().IncludeStates().Run(feedSession)
+func (e Exp) IncludeStates() Exp {
+	return naryOperator(includeStatesKind, e)
+}
+
+// HandleFeed reads a changefeed until it ends, calling the handler's
+// callbacks for the state documents sent by feeds with .IncludeStates(), and
+// for each change.  The state callbacks are only called when the state
+// changes.  It returns the error from OnChange that stopped it, after closing
+// the feed, or the error that ended the feed, which is also passed to
+// OnError.  Use .Close() from another goroutine to stop it.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Changes().IncludeStates().Run(feedSession)
+//  err := rows.HandleFeed(r.FeedHandler{
+//      OnReady: func() { log.Println("watching heroes") },
+//      OnChange: func(change r.RawChange) error {
+//          var hero Hero
+//          if err := change.Unmarshal(nil, &hero); err != nil {
+//              return err
+//          }
+//          return index.Update(hero)
+//      },
+//      OnError: func(err error) { log.Println("hero feed failed:", err) },
+//  })
+func (rows *Rows) HandleFeed(handler FeedHandler) error {
+	state := ""
+	for rows.Next() {
+		if next, ok := feedState(rows.current); ok {
+			if next != state {
+				state = next
+				switch {
+				case state == "initializing" && handler.OnInitializing != nil:
+					handler.OnInitializing()
+				case state == "ready" && handler.OnReady != nil:
+					handler.OnReady()
+				}
+			}
+			continue
+		}
+
+		var change RawChange
+		if err := rows.Scan(&change); err != nil {
+			rows.Close()
+			return err
+		}
+		if handler.OnChange != nil {
+			if err := handler.OnChange(change); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+	}
+
+	err := rows.Err()
+	if err != nil && handler.OnError != nil {
+		handler.OnError(err)
+	}
+	return err
+}
+
+// feedState returns the state from a state document of a changefeed, which
+// has a "state" field and nothing else.
+func feedState(datum *p.Datum) (string, bool) {
+	pairs := datum.GetRObject()
+	if datum.GetType() != p.Datum_R_OBJECT || len(pairs) != 1 || pairs[0].GetKey() != "state" {
+		return "", false
+	}
+	if pairs[0].GetVal().GetType() != p.Datum_R_STR {
+		return "", false
+	}
+	return pairs[0].GetVal().GetRStr(), true
+}
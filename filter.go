@@ -0,0 +1,209 @@
+package rethinkgo
+
+// Compile the comparison operators that can be used in the maps passed to
+// .Filter() into predicate functions.
+
+import (
+	"sort"
+	"strings"
+)
+
+var filterOperators = map[string]func(Exp, interface{}) Exp{
+	"==": Exp.Eq,
+	"!=": Exp.Ne,
+	"<":  Exp.Lt,
+	"<=": Exp.Le,
+	">":  Exp.Gt,
+	">=": Exp.Ge,
+	"in": func(attribute Exp, values interface{}) Exp {
+		return Expr(values).Contains(attribute)
+	},
+}
+
+// Matcher is a comparison that can be used as a value in a .Filter() map, it
+// is created with r.Gt(), r.In() etc.
+type Matcher struct {
+	operator string
+	value    interface{}
+}
+
+// Gt matches attributes greater than the value when used in a .Filter() map.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").Filter(r.Map{"strength": r.Gt(5)}).Run(session).All(&response)
+func Gt(value interface{}) Matcher {
+	return Matcher{">", value}
+}
+
+// Gte matches attributes greater than or equal to the value when used in a
+// .Filter() map.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").Filter(r.Map{"strength": r.Gte(5)}).Run(session).All(&response)
+func Gte(value interface{}) Matcher {
+	return Matcher{">=", value}
+}
+
+// Lt matches attributes less than the value when used in a .Filter() map.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").Filter(r.Map{"strength": r.Lt(5)}).Run(session).All(&response)
+func Lt(value interface{}) Matcher {
+	return Matcher{"<", value}
+}
+
+// Lte matches attributes less than or equal to the value when used in a
+// .Filter() map.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").Filter(r.Map{"strength": r.Lte(5)}).Run(session).All(&response)
+func Lte(value interface{}) Matcher {
+	return Matcher{"<=", value}
+}
+
+// Ne matches attributes that are not equal to the value when used in a
+// .Filter() map.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").Filter(r.Map{"name": r.Ne("Wolverine")}).Run(session).All(&response)
+func Ne(value interface{}) Matcher {
+	return Matcher{"!=", value}
+}
+
+// In matches attributes that are equal to one of the values when used in a
+// .Filter() map.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").Filter(r.Map{"name": r.In("Wolverine", "Cyclops")}).Run(session).All(&response)
+func In(values ...interface{}) Matcher {
+	return Matcher{"in", List(values)}
+}
+
+// filterComparison is a single comparison found in a filter map, such as
+// "age >=": 21
+type filterComparison struct {
+	attribute string
+	operator  string
+	value     interface{}
+}
+
+// splitFilterKey splits a key such as "age >=" into the attribute and the
+// operator, if there is one.
+func splitFilterKey(key string) (attribute, operator string) {
+	i := strings.LastIndex(key, " ")
+	if i == -1 {
+		return key, ""
+	}
+	operator = key[i+1:]
+	if _, ok := filterOperators[operator]; !ok {
+		return key, ""
+	}
+	return strings.TrimSpace(key[:i]), operator
+}
+
+// operatorMap returns the value as a map if it is a nested operator map such
+// as r.Map{">=": 18, "<": 65}, where every key is an operator.
+func operatorMap(value interface{}) (map[string]interface{}, bool) {
+	var m map[string]interface{}
+	switch v := value.(type) {
+	case Map:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return nil, false
+	}
+	if len(m) == 0 {
+		return nil, false
+	}
+	for key := range m {
+		if _, ok := filterOperators[key]; !ok {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// splitFilterMap separates the comparisons in a filter map from the keys that
+// should be matched against the row as usual.
+func splitFilterMap(m map[string]interface{}) (plain Map, comparisons []filterComparison) {
+	plain = Map{}
+	for key, value := range m {
+		attribute, operator := splitFilterKey(key)
+		if matcher, ok := value.(Matcher); ok {
+			comparisons = append(comparisons, filterComparison{key, matcher.operator, matcher.value})
+		} else if operator != "" {
+			comparisons = append(comparisons, filterComparison{attribute, operator, value})
+		} else if operators, ok := operatorMap(value); ok {
+			for operator, operand := range operators {
+				comparisons = append(comparisons, filterComparison{key, operator, operand})
+			}
+		} else {
+			plain[key] = value
+		}
+	}
+
+	// keep the generated query the same from run to run
+	sort.Sort(filterComparisons(comparisons))
+	return plain, comparisons
+}
+
+type filterComparisons []filterComparison
+
+func (c filterComparisons) Len() int      { return len(c) }
+func (c filterComparisons) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c filterComparisons) Less(i, j int) bool {
+	if c[i].attribute != c[j].attribute {
+		return c[i].attribute < c[j].attribute
+	}
+	return c[i].operator < c[j].operator
+}
+
+// compileFilter turns a filter map that uses comparison operators into a
+// filter on the plain keys followed by a predicate function for the
+// comparisons.  Other operands are returned unchanged.
+func compileFilter(e Exp, operand interface{}) Exp {
+	var m map[string]interface{}
+	switch v := operand.(type) {
+	case Map:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return naryOperator(filterKind, e, funcWrapper(operand, 1))
+	}
+
+	plain, comparisons := splitFilterMap(m)
+	if len(comparisons) == 0 {
+		return naryOperator(filterKind, e, funcWrapper(operand, 1))
+	}
+
+	if len(plain) > 0 {
+		e = naryOperator(filterKind, e, funcWrapper(plain, 1))
+	}
+	predicate := func(row Exp) Exp {
+		var result Exp
+		for i, c := range comparisons {
+			test := filterOperators[c.operator](row.Attr(c.attribute), c.value)
+			if i == 0 {
+				result = test
+			} else {
+				result = result.And(test)
+			}
+		}
+		return result
+	}
+	return naryOperator(filterKind, e, funcWrapper(predicate, 1))
+}
@@ -0,0 +1,28 @@
+//go:build go1.18
+// +build go1.18
+
+package rethinkgo
+
+// Typed alternatives to .One() and .All() for Go versions with generics.
+
+// One gets the first result from a query response, decoded as a T.
+//
+// Example usage:
+//
+//  hero, err := r.One[Hero](r.Table("heroes").Get("Storm").Run(session))
+func One[T any](rows *Rows) (T, error) {
+	var result T
+	err := rows.One(&result)
+	return result, err
+}
+
+// All fetches all the results from a query response, decoded as a slice of T.
+//
+// Example usage:
+//
+//  heroes, err := r.All[Hero](r.Table("heroes").Run(session))
+func All[T any](rows *Rows) ([]T, error) {
+	var results []T
+	err := rows.All(&results)
+	return results, err
+}
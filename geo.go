@@ -0,0 +1,154 @@
+package rethinkgo
+
+// Geospatial values and queries, see
+// http://www.rethinkdb.com/docs/geo-support/ for how the server handles them.
+//
+// Geometry values are returned as GeoJSON objects, which can be decoded into a
+// struct such as:
+//
+//  type Geometry struct {
+//      Type        string      `json:"type"`
+//      Coordinates interface{} `json:"coordinates"`
+//  }
+
+// Point creates a point from a longitude and a latitude, in degrees.
+//
+// Example usage:
+//
+//  r.Table("hideouts").Insert(r.Map{"name": "Baxter Building", "location": r.Point(-73.99, 40.75)})
+func Point(longitude, latitude interface{}) Exp {
+	return naryOperator(pointKind, longitude, latitude)
+}
+
+// Line creates a line from two or more points, each either an r.Point() or a
+// [longitude, latitude] pair.
+//
+// Example usage:
+//
+//  r.Line(r.Point(-122.4, 37.7), r.Point(-117.2, 32.7))
+//  r.Line(r.List{-122.4, 37.7}, r.List{-117.2, 32.7})
+func Line(points ...interface{}) Exp {
+	return Exp{kind: lineKind, args: points}
+}
+
+// Polygon creates a polygon from three or more points, each either an
+// r.Point() or a [longitude, latitude] pair.  The polygon is closed by joining
+// the last point to the first.
+//
+// Example usage:
+//
+//  r.Polygon(r.Point(-122.4, 37.7), r.Point(-122.4, 37.8), r.Point(-122.3, 37.8))
+func Polygon(points ...interface{}) Exp {
+	return Exp{kind: polygonKind, args: points}
+}
+
+// Circle creates a polygon that approximates a circle with the given center
+// point and radius, in meters unless changed with .Unit().
+//
+// Example usage:
+//
+//  r.Circle(r.Point(-73.99, 40.75), 5).Unit("km")
+func Circle(center, radius interface{}) Exp {
+	return naryOperator(circleKind, center, radius)
+}
+
+// Distance returns the distance between two geometries, at least one of which
+// must be a point, in meters unless changed with .Unit().
+//
+// Example usage:
+//
+//  var response float64
+//  err := r.Point(-122.4, 37.7).Distance(r.Point(-117.2, 32.7)).Unit("mi").Run(session).One(&response)
+func (e Exp) Distance(other interface{}) Exp {
+	return naryOperator(distanceKind, e, other)
+}
+
+// Intersects returns true if two geometries share at least one point.  On a
+// sequence, it returns the geometries in it that intersect the given one.
+//
+// Example usage:
+//
+//  // hideouts within 5km
+//  r.Table("hideouts").Filter(r.Row.Attr("location").Intersects(r.Circle(point, 5).Unit("km")))
+func (e Exp) Intersects(geometry interface{}) Exp {
+	return naryOperator(intersectsKind, e, geometry)
+}
+
+// Includes returns true if a polygon completely contains another geometry.
+// On a sequence of polygons, it returns the ones that contain the given
+// geometry.
+//
+// Example usage:
+//
+//  r.Table("districts").Filter(r.Row.Attr("area").Includes(r.Point(-73.99, 40.75)))
+func (e Exp) Includes(geometry interface{}) Exp {
+	return naryOperator(includesKind, e, geometry)
+}
+
+// GetIntersecting gets all rows whose value for a geospatial index intersects
+// the given geometry, see IndexSpec.Geo.  An empty index name uses the one
+// given to .UseIndex().
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("hideouts").GetIntersecting("location", r.Circle(point, 5).Unit("km")).Run(session).All(&response)
+func (e Exp) GetIntersecting(index string, geometry interface{}) Exp {
+	return naryOperator(getIntersectingKind, e, geometry, index)
+}
+
+// GetNearest gets the rows whose value for a geospatial index is closest to
+// the given point, nearest first, see IndexSpec.Geo.  An empty index name uses
+// the one given to .UseIndex().  Each result is an object with the distance
+// to the row, in meters unless changed with .Unit(), and the row itself.  By
+// default at most 100 rows within 100km are returned, this can be changed with
+// .MaxResults() and .MaxDist().
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("hideouts").GetNearest("location", r.Point(-73.99, 40.75)).MaxResults(5).Run(session).All(&response)
+//
+// Example response:
+//
+//  [
+//    {
+//      "dist": 321.5,
+//      "doc": {"name": "Baxter Building", "location": {...}}
+//    },
+//    ...
+//  ]
+func (e Exp) GetNearest(index string, point interface{}) Exp {
+	return naryOperator(getNearestKind, e, point, index)
+}
+
+// Unit sets the unit of distance for the .Distance(), r.Circle() or
+// .GetNearest() it follows, one of "m" (the default), "km", "mi", "nm" or
+// "ft".
+//
+// Example usage:
+//
+//  r.Point(-122.4, 37.7).Distance(r.Point(-117.2, 32.7)).Unit("km")
+func (e Exp) Unit(unit string) Exp {
+	return naryOperator(unitKind, e, unit)
+}
+
+// MaxDist sets the largest distance from the point for rows returned by the
+// .GetNearest() it follows, in the same unit as the results.
+//
+// Example usage:
+//
+//  r.Table("hideouts").GetNearest("location", point).Unit("km").MaxDist(10)
+func (e Exp) MaxDist(distance interface{}) Exp {
+	return naryOperator(maxDistKind, e, distance)
+}
+
+// MaxResults sets the largest number of rows returned by the .GetNearest() it
+// follows.
+//
+// Example usage:
+//
+//  r.Table("hideouts").GetNearest("location", point).MaxResults(10)
+func (e Exp) MaxResults(count int) Exp {
+	return naryOperator(maxResultsKind, e, count)
+}
@@ -0,0 +1,60 @@
+package rethinkgo
+
+// Let applications instrument the queries run on a session.
+
+import (
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"time"
+)
+
+// QueryHook is told about each query run on a session, see SetQueryHook().
+// The query is passed as ReQL-like text, as shown by Exp.String(), and the
+// token is the one the query was sent to the server with, which is the same
+// in both calls.
+type QueryHook interface {
+	// OnQueryStart is called just before the query is sent to the server.
+	OnQueryStart(token int64, query string)
+	// OnQueryEnd is called once the first response to the query has been
+	// received, or for noreply queries once the query has been sent, with how
+	// long that took and the error the query failed with, if any.
+	OnQueryEnd(token int64, query string, duration time.Duration, err error)
+}
+
+// SetQueryHook sets the hook that is called when each query run on the session
+// starts and ends, for metrics or structured logging.  Queries that cannot be
+// built are not sent, so the hook is not called for them.  Set the hook to nil
+// to stop calling it.
+//
+// Example usage:
+//
+//  type queryMetrics struct{}
+//
+//  func (queryMetrics) OnQueryStart(token int64, query string) {}
+//
+//  func (queryMetrics) OnQueryEnd(token int64, query string, duration time.Duration, err error) {
+//      metrics.RecordQuery(query, duration, err)
+//  }
+//
+//  sess.SetQueryHook(queryMetrics{})
+func (s *Session) SetQueryHook(hook QueryHook) {
+	s.queryHook = hook
+}
+
+// queryStarted calls the query hook for a query that is about to be sent, and
+// returns the text of the query for queryEnded().
+func (s *Session) queryStarted(queryProto *p.Query) string {
+	if s.queryHook == nil {
+		return ""
+	}
+	query := queryText(queryProto.GetQuery())
+	s.queryHook.OnQueryStart(queryProto.GetToken(), query)
+	return query
+}
+
+// queryEnded calls the query hook for a query that has finished, see
+// queryStarted().
+func (s *Session) queryEnded(queryProto *p.Query, query string, duration time.Duration, err error) {
+	if s.queryHook != nil {
+		s.queryHook.OnQueryEnd(queryProto.GetToken(), query, duration, err)
+	}
+}
@@ -0,0 +1,216 @@
+package rethinkgo
+
+// Load rows into a table from CSV or newline-delimited JSON.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ImportOpts holds the options for ImportCSV() and ImportNDJSON().
+type ImportOpts struct {
+	// Column holding the primary key of each row, it is renamed to PrimaryKey.
+	// Leave empty to use the rows as they are.
+	KeyColumn string
+	// Name of the table's primary key, defaults to "id".
+	PrimaryKey string
+	// Number of rows inserted with each query, defaults to 200.
+	BatchSize int
+	// Replace existing rows with the same primary key instead of failing.
+	Overwrite bool
+	// CSV columns that should always be strings, even if they look like
+	// numbers or booleans.
+	StringColumns []string
+	// When set, each imported row is hashed into the manifest, so the table
+	// can be checked with Verify() later.
+	Manifest *Manifest
+}
+
+func (opts ImportOpts) withDefaults() ImportOpts {
+	if opts.PrimaryKey == "" {
+		opts.PrimaryKey = "id"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 200
+	}
+	return opts
+}
+
+// ImportCSV inserts the rows of a CSV file into a table.  The first line of the
+// file must contain the column names.  Values that look like numbers or
+// booleans are converted to those types, except in StringColumns and for
+// numbers with leading zeros, such as zip codes.  Empty values are left out.
+//
+// The responses from the server are added together, use .Err() on the result
+// to see if any rows could not be inserted.
+//
+// Example usage:
+//
+//  file, err := os.Open("heroes.csv")
+//  response, err := r.ImportCSV(session, r.Table("heroes"), file, r.ImportOpts{KeyColumn: "name"})
+//  fmt.Println("inserted", response.Inserted, "rows")
+func ImportCSV(session *Session, table Exp, reader io.Reader, opts ImportOpts) (WriteResponse, error) {
+	opts = opts.withDefaults()
+	stringColumns := map[string]bool{}
+	for _, column := range opts.StringColumns {
+		stringColumns[column] = true
+	}
+
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if err != nil {
+		return WriteResponse{}, fmt.Errorf("rethinkdb: Could not read CSV header: %v", err)
+	}
+
+	imp := newImporter(session, table, opts)
+	for line := 2; ; line++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp.response, fmt.Errorf("rethinkdb: Could not read CSV line %v: %v", line, err)
+		}
+
+		row := Map{}
+		for i, value := range record {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			if stringColumns[header[i]] {
+				row[header[i]] = value
+			} else {
+				row[header[i]] = inferCSVValue(value)
+			}
+		}
+		if err := imp.add(row); err != nil {
+			return imp.response, err
+		}
+	}
+	return imp.response, imp.flush()
+}
+
+// ImportNDJSON inserts rows from a stream of JSON objects, one per line.
+// Blank lines are skipped.
+//
+// Example usage:
+//
+//  file, err := os.Open("heroes.json")
+//  response, err := r.ImportNDJSON(session, r.Table("heroes"), file, r.ImportOpts{BatchSize: 500})
+func ImportNDJSON(session *Session, table Exp, reader io.Reader, opts ImportOpts) (WriteResponse, error) {
+	opts = opts.withDefaults()
+	imp := newImporter(session, table, opts)
+
+	bufferedReader := bufio.NewReader(reader)
+	for line := 1; ; line++ {
+		text, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return imp.response, err
+		}
+		if err == io.EOF && text == "" {
+			break
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		var row Map
+		decoder := json.NewDecoder(strings.NewReader(text))
+		// keep large integers intact
+		decoder.UseNumber()
+		if err := decoder.Decode(&row); err != nil {
+			return imp.response, fmt.Errorf("rethinkdb: Could not parse JSON on line %v: %v", line, err)
+		}
+		if err := imp.add(row); err != nil {
+			return imp.response, err
+		}
+	}
+	return imp.response, imp.flush()
+}
+
+// csvNumberPattern matches plain decimal numbers, leaving out values such as
+// "NaN", "Inf", hex floats and "1_000" that strconv would also accept.
+var csvNumberPattern = regexp.MustCompile(`^-?[0-9]*\.?[0-9]+([eE][-+]?[0-9]+)?$`)
+
+// inferCSVValue converts a CSV value to a number or boolean if it looks like
+// one.
+func inferCSVValue(value string) interface{} {
+	switch value {
+	case "true", "TRUE", "True":
+		return true
+	case "false", "FALSE", "False":
+		return false
+	}
+
+	// leading zeros are usually significant, e.g. "02139"
+	digits := strings.TrimPrefix(value, "-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return value
+	}
+	if !csvNumberPattern.MatchString(value) {
+		return value
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
+
+// importer collects rows into batches and inserts them.
+type importer struct {
+	session  *Session
+	table    Exp
+	opts     ImportOpts
+	batch    List
+	response WriteResponse
+}
+
+func newImporter(session *Session, table Exp, opts ImportOpts) *importer {
+	return &importer{session: session, table: table, opts: opts}
+}
+
+func (imp *importer) add(row Map) error {
+	if imp.opts.KeyColumn != "" && imp.opts.KeyColumn != imp.opts.PrimaryKey {
+		if key, ok := row[imp.opts.KeyColumn]; ok {
+			row[imp.opts.PrimaryKey] = key
+			delete(row, imp.opts.KeyColumn)
+		}
+	}
+
+	if imp.opts.Manifest != nil {
+		if err := imp.opts.Manifest.Add(row); err != nil {
+			return err
+		}
+	}
+
+	imp.batch = append(imp.batch, row)
+	if len(imp.batch) >= imp.opts.BatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *importer) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+
+	var response WriteResponse
+	query := imp.table.Insert(imp.batch).overwrite(imp.opts.Overwrite)
+	if err := query.Run(imp.session).One(&response); err != nil {
+		return err
+	}
+	imp.response.add(response)
+	imp.batch = nil
+	return nil
+}
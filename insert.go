@@ -0,0 +1,195 @@
+package rethinkgo
+
+// Inserts with options that are applied by the driver before the rows are
+// sent to the server.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// InsertOpts holds the options for .InsertWith().
+type InsertOpts struct {
+	// Generates the primary key of each row that does not have one, instead of
+	// leaving it to the server, e.g. to use ULIDs.  It is called with the row
+	// as it was passed to .InsertWith(), an empty result leaves the row
+	// without a key.
+	KeyFunc func(doc interface{}) string
+	// Name of the table's primary key, defaults to "id".
+	PrimaryKey string
+	// What to do when a row has the same primary key as an existing one,
+	// either "error" (the default) to fail that row, "replace" to overwrite
+	// the existing row, or "update" to merge the row into the existing one,
+	// which needs server >= 2.0, see .OnConflict().
+	Conflict string
+	// Fields set on every row, keyed by field name, each to the result of
+	// calling its function with the row, e.g. timestamps, slugs or copies of
+	// fields from related rows.  The row is given as a map with the field names
+	// it is stored with, after KeyFunc has been applied.  Fields are computed
+	// in order of their names and replace any value the row already has.
+	Computed map[string]func(doc Map) interface{}
+}
+
+func (opts InsertOpts) withDefaults() InsertOpts {
+	if opts.PrimaryKey == "" {
+		opts.PrimaryKey = "id"
+	}
+	if opts.Conflict == "" {
+		opts.Conflict = "error"
+	}
+	return opts
+}
+
+// InsertWith is like .Insert(), but with options that are applied by the
+// driver, such as generating primary keys and computed fields.  Rows that are
+// query expressions are sent as they are.
+//
+// Example usage:
+//
+//  opts := r.InsertOpts{KeyFunc: func(doc interface{}) string { return ulid.Make().String() }}
+//  var response r.WriteResponse
+//  err := r.Table("heroes").InsertWith(opts, r.Map{"name": "Thing"}).Run(session).One(&response)
+//
+//  opts := r.InsertOpts{Computed: map[string]func(doc r.Map) interface{}{
+//      "created_at": func(doc r.Map) interface{} { return r.ClockNow() },
+//      "slug":       func(doc r.Map) interface{} { return slugify(doc["name"].(string)) },
+//  }}
+//  err := r.Table("heroes").InsertWith(opts, hero).Run(session).Exec()
+func (e Exp) InsertWith(opts InsertOpts, rows ...interface{}) Exp {
+	opts = opts.withDefaults()
+
+	var overwrite bool
+	switch opts.Conflict {
+	case "error":
+	case "replace":
+		overwrite = true
+	case "update":
+	default:
+		panic(fmt.Sprintf("rethinkdb: unknown InsertOpts.Conflict %q, use \"error\", \"replace\" or \"update\"", opts.Conflict))
+	}
+
+	if opts.KeyFunc != nil {
+		keyed := make([]interface{}, len(rows))
+		for i, row := range rows {
+			keyed[i] = opts.addKeys(row)
+		}
+		rows = keyed
+	}
+	if len(opts.Computed) > 0 {
+		computed := make([]interface{}, len(rows))
+		for i, row := range rows {
+			computed[i] = opts.addComputed(row)
+		}
+		rows = computed
+	}
+	if opts.Conflict == "update" {
+		return e.Insert(rows...).OnConflict("update")
+	}
+	return e.Insert(rows...).overwrite(overwrite)
+}
+
+// addKeys gives a row, or each row in a list, a primary key from KeyFunc if it
+// does not already have one.
+func (opts InsertOpts) addKeys(row interface{}) interface{} {
+	switch v := row.(type) {
+	case Exp:
+		return v
+	case List:
+		return List(opts.addKeysToList(v))
+	case []interface{}:
+		return opts.addKeysToList(v)
+	}
+
+	doc, ok := toDocument(row)
+	if !ok {
+		return row
+	}
+	if _, ok := doc[opts.PrimaryKey]; ok {
+		return row
+	}
+	key := opts.KeyFunc(row)
+	if key == "" {
+		return row
+	}
+	doc[opts.PrimaryKey] = key
+	return doc
+}
+
+func (opts InsertOpts) addKeysToList(rows []interface{}) []interface{} {
+	keyed := make([]interface{}, len(rows))
+	for i, row := range rows {
+		keyed[i] = opts.addKeys(row)
+	}
+	return keyed
+}
+
+// addComputed sets the Computed fields of a row, or of each row in a list.
+func (opts InsertOpts) addComputed(row interface{}) interface{} {
+	switch v := row.(type) {
+	case Exp:
+		return v
+	case List:
+		return List(opts.addComputedToList(v))
+	case []interface{}:
+		return opts.addComputedToList(v)
+	}
+
+	doc, ok := toDocument(row)
+	if !ok {
+		return row
+	}
+	var fields []string
+	for field := range opts.Computed {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		doc[field] = opts.Computed[field](doc)
+	}
+	return doc
+}
+
+func (opts InsertOpts) addComputedToList(rows []interface{}) []interface{} {
+	computed := make([]interface{}, len(rows))
+	for i, row := range rows {
+		computed[i] = opts.addComputed(row)
+	}
+	return computed
+}
+
+// toDocument returns a copy of a row as a map that can be changed without
+// affecting the caller's value.  Values other than maps are converted through
+// their encoding, so structs keep the field names they are stored with.
+func toDocument(row interface{}) (Map, bool) {
+	doc := Map{}
+	switch v := row.(type) {
+	case Map:
+		for key, value := range v {
+			doc[key] = value
+		}
+		return doc, true
+	case map[string]interface{}:
+		for key, value := range v {
+			doc[key] = value
+		}
+		return doc, true
+	}
+
+	value, err := encodeValue(row)
+	if err != nil {
+		return nil, false
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	// keep numbers exactly as they were encoded
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false
+	}
+	return doc, true
+}
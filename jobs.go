@@ -0,0 +1,151 @@
+package rethinkgo
+
+// List and stop the jobs running on the server, including queries once the
+// client has given up waiting for them.
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	"net"
+	"time"
+)
+
+// jobsServerVersion is the first server version with the rethinkdb.jobs table.
+var jobsServerVersion = serverVersion{2, 0, 0}
+
+// killTimeout limits how long we wait for the server to kill a query.
+const killTimeout = 5 * time.Second
+
+// SetKillOnTimeout makes the session try to stop a query on the server when it
+// times out, see .SetTimeout().  Without this the server keeps running the
+// query even though nobody will read the result.
+//
+// The query is found in the rethinkdb.jobs system table by the address of the
+// session's connection and deleted from it, using a separate connection.  This
+// is best-effort, and needs server >= 2.0 and a user that can write to the
+// system tables.
+//
+// Example usage:
+//
+//  sess.SetTimeout(10 * time.Second)
+//  sess.SetKillOnTimeout(true)
+func (s *Session) SetKillOnTimeout(kill bool) {
+	s.killOnTimeout = kill
+}
+
+// isTimeout is true for errors caused by a connection deadline.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// checkTimeout kills the session's queries on the server if err is a timeout
+// and the session is set to do so.
+func (s *Session) checkTimeout(err error) {
+	if !s.killOnTimeout || !isTimeout(err) {
+		return
+	}
+	if s.version.known() && s.version.less(jobsServerVersion) {
+		return
+	}
+	addr, ok := s.conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	s.killQueriesFrom(addr)
+}
+
+// killQueriesFrom deletes the jobs for all queries sent from a client address.
+// Errors are ignored, since the query may have finished in the meantime.
+func (s *Session) killQueriesFrom(addr *net.TCPAddr) {
+	query := Db("rethinkdb").Table("jobs").Filter(func(job Exp) Exp {
+		info := job.Attr("info")
+		return job.Attr("type").Eq("query").
+			And(info.Attr("client_address").Eq(addr.IP.String())).
+			And(info.Attr("client_port").Eq(addr.Port))
+	}).Delete()
+
+	queryProto, err := s.getContext().buildProtobuf(query)
+	if err != nil {
+		return
+	}
+	conn, err := s.dial()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	queryProto.Token = proto.Int64(1)
+	conn.executeQuery(queryProto, killTimeout)
+}
+
+// Job is a row of the rethinkdb.jobs system table, see Jobs().
+type Job struct {
+	// Type and UUID of the job, e.g. ["query", "72789a0b-..."], used to
+	// kill it with KillJob().
+	Id []string `json:"id"`
+	// One of "query", "disk_compaction", "index_construction" or "backfill".
+	Type        string   `json:"type"`
+	DurationSec float64  `json:"duration_sec"`
+	Servers     []string `json:"servers"` // names of the servers running it
+	Info        JobInfo  `json:"info"`
+}
+
+// JobInfo holds the details of a Job, which fields are set depends on its
+// type.
+type JobInfo struct {
+	// queries
+	ClientAddress string `json:"client_address"`
+	ClientPort    int    `json:"client_port"`
+	Query         string `json:"query"` // the query, in the server's own syntax
+	User          string `json:"user"`
+	// index construction and backfills
+	Db       string  `json:"db"`
+	Table    string  `json:"table"`
+	Index    string  `json:"index"`
+	Progress float64 `json:"progress"`
+}
+
+// Duration returns how long the job has been running.
+func (j Job) Duration() time.Duration {
+	return time.Duration(j.DurationSec * float64(time.Second))
+}
+
+// Jobs returns the jobs running on the server, read from the rethinkdb.jobs
+// system table, longest running first.  This needs server >= 2.0.
+//
+// Example usage:
+//
+//  jobs, err := r.Jobs(session)
+//  for _, job := range jobs {
+//      if job.Type == "query" && job.Duration() > time.Minute {
+//          fmt.Println("slow query from", job.Info.ClientAddress, job.Info.Query)
+//      }
+//  }
+func Jobs(session *Session) ([]Job, error) {
+	var jobs []Job
+	query := Db("rethinkdb").Table("jobs").OrderBy(Desc("duration_sec"))
+	if err := query.Run(session).All(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// KillJob stops a job on the server by deleting it from the rethinkdb.jobs
+// system table, given the Id from a Job returned by Jobs().  Queries are
+// stopped with an error for the client that ran them.  This needs a user that
+// can write to the system tables.
+//
+// Example usage:
+//
+//  err := r.KillJob(session, job.Id)
+func KillJob(session *Session, id []string) error {
+	response, err := Db("rethinkdb").Table("jobs").Get(id).Delete().RunWrite(session)
+	if err != nil {
+		return err
+	}
+	if response.Deleted == 0 {
+		return fmt.Errorf("rethinkdb: No job with id %v, it may have finished", id)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package rethinkgo
+
+// Look for common mistakes in queries that make them slower than they need to
+// be.
+
+import (
+	"encoding/json"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+)
+
+// LintFinding describes a possible problem found in a query by Lint().
+type LintFinding struct {
+	Rule    string   // short name of the rule, e.g. "orderby-limit"
+	Message string   // what is wrong and how to fix it
+	Path    []string // term types from the root of the query down to the problem
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%v: %v (at %v)", f.Rule, f.Message, f.Path)
+}
+
+// lintRule checks a single term, returning a message if it breaks the rule.
+type lintRule struct {
+	name  string
+	check func(term *p.Term) string
+}
+
+var lintRules = []lintRule{
+	{"filter-table", func(term *p.Term) string {
+		if term.GetType() == p.Term_FILTER && firstArgType(term) == p.Term_TABLE {
+			return "Filter() reads every row of the table, use GetAll() or Between() on an index if the table is large"
+		}
+		return ""
+	}},
+	{"orderby-limit", func(term *p.Term) string {
+		if term.GetType() != p.Term_LIMIT || firstArgType(term) != p.Term_ORDERBY {
+			return ""
+		}
+		if _, ok := termOptarg(term.Args[0], "index"); ok {
+			return ""
+		}
+		return "OrderBy() sorts every row before Limit() takes a few of them, order by an index instead"
+	}},
+	{"update-js", func(term *p.Term) string {
+		if term.GetType() != p.Term_UPDATE && term.GetType() != p.Term_REPLACE {
+			return ""
+		}
+		if nonAtomic, ok := termOptarg(term, "non_atomic"); ok && nonAtomic == true {
+			return ""
+		}
+		for _, arg := range term.Args[1:] {
+			if containsTermType(arg, p.Term_JAVASCRIPT) {
+				return "JavaScript cannot be run atomically, the write will fail unless it is followed by .Atomic(false)"
+			}
+		}
+		return ""
+	}},
+	{"count-after-fetch", func(term *p.Term) string {
+		if term.GetType() != p.Term_COUNT || len(term.Args) != 1 {
+			return ""
+		}
+		switch firstArgType(term) {
+		case p.Term_ORDERBY, p.Term_COERCE_TO:
+			return "Count() after OrderBy() or CoerceTo() loads every document first, count the sequence directly"
+		}
+		return ""
+	}},
+}
+
+// Lint looks for patterns in a query that are likely to make it slow or make
+// it fail, such as sorting a whole table to take the first few rows.  It does
+// not run the query.  The findings are only suggestions, some of them may be
+// fine for small tables.
+//
+// Example usage:
+//
+//  query := r.Table("heroes").OrderBy("strength").Limit(3)
+//  findings, err := r.Lint(query)
+//  for _, finding := range findings {
+//      fmt.Println(finding)
+//  }
+func Lint(query Exp) ([]LintFinding, error) {
+	queryProto, err := context{}.buildProtobuf(query)
+	if err != nil {
+		return nil, err
+	}
+	return lintTerm(queryProto.GetQuery()), nil
+}
+
+// LintHook is called by SetLintHook() with the findings for a query.
+type LintHook func(query Exp, findings []LintFinding)
+
+var lintHook LintHook
+
+// SetLintHook makes every query that is run check itself with Lint() first,
+// calling the hook if there are any findings.  The query is run either way.
+// Set to nil to disable, which is the default.
+//
+// Example usage:
+//
+//  r.SetLintHook(func(query r.Exp, findings []r.LintFinding) {
+//      for _, finding := range findings {
+//          log.Println("rethinkdb lint:", finding)
+//      }
+//  })
+func SetLintHook(hook LintHook) {
+	lintHook = hook
+}
+
+// runLintHook calls the lint hook, if there is one, for a query that is about
+// to be run.
+func runLintHook(query Exp, queryProto *p.Query) {
+	if lintHook == nil {
+		return
+	}
+	if findings := lintTerm(queryProto.GetQuery()); len(findings) > 0 {
+		lintHook(query, findings)
+	}
+}
+
+func lintTerm(root *p.Term) []LintFinding {
+	var findings []LintFinding
+	walkTerm(root, nil, func(term *p.Term, path []string) {
+		for _, rule := range lintRules {
+			if message := rule.check(term); message != "" {
+				findings = append(findings, LintFinding{
+					Rule:    rule.name,
+					Message: message,
+					Path:    append([]string{}, path...),
+				})
+			}
+		}
+	})
+	return findings
+}
+
+// walkTerm calls fn for the term and all the terms inside it, along with the
+// types of the terms leading to each one.
+func walkTerm(term *p.Term, path []string, fn func(term *p.Term, path []string)) {
+	if term == nil {
+		return
+	}
+	path = append(path, term.GetType().String())
+	fn(term, path)
+	for _, arg := range term.Args {
+		walkTerm(arg, path, fn)
+	}
+	for _, optarg := range term.Optargs {
+		walkTerm(optarg.Val, path, fn)
+	}
+}
+
+func firstArgType(term *p.Term) p.Term_TermType {
+	if len(term.Args) == 0 {
+		return 0
+	}
+	return term.Args[0].GetType()
+}
+
+func containsTermType(term *p.Term, termType p.Term_TermType) bool {
+	found := false
+	walkTerm(term, nil, func(t *p.Term, path []string) {
+		if t.GetType() == termType {
+			found = true
+		}
+	})
+	return found
+}
+
+// termOptarg returns the value of an optarg if it is a constant.
+func termOptarg(term *p.Term, key string) (interface{}, bool) {
+	for _, optarg := range term.Optargs {
+		if optarg.GetKey() != key {
+			continue
+		}
+		val := optarg.Val
+		switch {
+		case val.GetType() == p.Term_DATUM:
+			var value interface{}
+			if datumUnmarshal(val.Datum, &value) == nil {
+				return value, true
+			}
+		case val.GetType() == p.Term_JSON && len(val.Args) == 1:
+			var value interface{}
+			if json.Unmarshal([]byte(val.Args[0].GetDatum().GetRStr()), &value) == nil {
+				return value, true
+			}
+		}
+		// present, but not a constant
+		return nil, true
+	}
+	return nil, false
+}
@@ -0,0 +1,207 @@
+package rethinkgo
+
+// Hashes of the rows in a backup, to check that a restore reproduced them.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Manifest holds a hash of each row of a table, keyed by primary key.  It can
+// be saved as JSON next to a backup, and checked against the table after the
+// backup is restored with Verify().
+type Manifest struct {
+	// Name of the table's primary key.
+	PrimaryKey string `json:"primary_key"`
+	// SHA-256 of each row, keyed by the JSON encoding of its primary key.
+	Hashes map[string]string `json:"hashes"`
+}
+
+// NewManifest creates an empty manifest for a table with the given primary
+// key, "id" if it is empty.
+//
+// Example usage:
+//
+//  manifest := r.NewManifest("name")
+//  response, err := r.ImportNDJSON(session, r.Table("heroes"), file, r.ImportOpts{Manifest: manifest})
+func NewManifest(primaryKey string) *Manifest {
+	if primaryKey == "" {
+		primaryKey = "id"
+	}
+	return &Manifest{PrimaryKey: primaryKey, Hashes: map[string]string{}}
+}
+
+// TableManifest hashes every row currently in a table.
+//
+// Example usage:
+//
+//  manifest, err := r.TableManifest(session, r.Table("heroes"), "id")
+//  data, err := json.Marshal(manifest)
+func TableManifest(session *Session, table Exp, primaryKey string) (*Manifest, error) {
+	manifest := NewManifest(primaryKey)
+	err := forEachRawRow(session, table, func(row interface{}) error {
+		return manifest.Add(row)
+	})
+	return manifest, err
+}
+
+// Add hashes a row and records it in the manifest.
+func (m *Manifest) Add(row interface{}) error {
+	key, hash, err := m.hashRow(row)
+	if err != nil {
+		return err
+	}
+	m.Hashes[key] = hash
+	return nil
+}
+
+// hashRow returns the manifest key of a row and its hash.  The row is put
+// through JSON twice so that equal documents hash the same no matter how
+// their numbers were written or which Go types hold them.
+func (m *Manifest) hashRow(row interface{}) (key string, hash string, err error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return "", "", err
+	}
+	var document map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return "", "", fmt.Errorf("rethinkdb: Row is not an object: %v", err)
+	}
+	canonicalNumbers(document)
+	value, ok := document[m.PrimaryKey]
+	if !ok {
+		return "", "", fmt.Errorf("rethinkdb: Row has no primary key %q", m.PrimaryKey)
+	}
+
+	keyData, err := json.Marshal(value)
+	if err != nil {
+		return "", "", err
+	}
+	// maps are encoded with their keys sorted
+	canonical, err := json.Marshal(document)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return string(keyData), hex.EncodeToString(sum[:]), nil
+}
+
+// ManifestError is returned by Verify() when a table does not match its
+// manifest.  Each list holds the JSON encoded primary keys of the rows.
+type ManifestError struct {
+	Missing    []string // rows in the manifest that are not in the table
+	Changed    []string // rows that are in both, but differ
+	Unexpected []string // rows in the table that are not in the manifest
+}
+
+func (e ManifestError) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%v missing (first %v)", len(e.Missing), e.Missing[0]))
+	}
+	if len(e.Changed) > 0 {
+		problems = append(problems, fmt.Sprintf("%v changed (first %v)", len(e.Changed), e.Changed[0]))
+	}
+	if len(e.Unexpected) > 0 {
+		problems = append(problems, fmt.Sprintf("%v unexpected (first %v)", len(e.Unexpected), e.Unexpected[0]))
+	}
+	return "rethinkdb: Table does not match manifest, rows " + strings.Join(problems, ", ")
+}
+
+// Verify checks that the rows in a table are exactly those recorded in the
+// manifest, a ManifestError lists the rows that are not.
+//
+// Example usage:
+//
+//  err := r.Verify(session, r.Table("heroes"), manifest)
+//  if e, ok := err.(r.ManifestError); ok {
+//      fmt.Println("rows not restored:", e.Missing)
+//  }
+func Verify(session *Session, table Exp, manifest *Manifest) error {
+	seen := map[string]bool{}
+	var result ManifestError
+	err := forEachRawRow(session, table, func(row interface{}) error {
+		key, hash, err := manifest.hashRow(row)
+		if err != nil {
+			return err
+		}
+		seen[key] = true
+		expected, ok := manifest.Hashes[key]
+		if !ok {
+			result.Unexpected = append(result.Unexpected, key)
+		} else if expected != hash {
+			result.Changed = append(result.Changed, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key := range manifest.Hashes {
+		if !seen[key] {
+			result.Missing = append(result.Missing, key)
+		}
+	}
+	if len(result.Missing) == 0 && len(result.Changed) == 0 && len(result.Unexpected) == 0 {
+		return nil
+	}
+	sort.Strings(result.Missing)
+	sort.Strings(result.Changed)
+	sort.Strings(result.Unexpected)
+	return result
+}
+
+// forEachRawRow calls f with each row of a table, leaving times as the
+// server's objects so they hash the same as in a JSON backup.
+func forEachRawRow(session *Session, table Exp, f func(row interface{}) error) error {
+	rows := table.RunWith(session, RunOpts{TimeFormat: "raw"})
+	for rows.Next() {
+		var row interface{}
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// canonicalNumbers replaces the json.Numbers in a decoded document with one
+// spelling per value, so that 1, 1.0 and 1e0 hash the same while integers too
+// large for a float64 keep all of their digits.
+func canonicalNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return v
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f)
+		}
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = canonicalNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = canonicalNumbers(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,151 @@
+package rethinkgo
+
+// Combine the results of several queries into a single Rows iterator.
+
+import (
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"reflect"
+)
+
+// mergeSource is one of the iterators read by a merged Rows.
+type mergeSource struct {
+	rows *Rows
+	// rows is positioned on a row that has not been returned yet
+	ready bool
+	// merge key of that row
+	key interface{}
+}
+
+// MergeCursors combines several iterators into one that returns all the rows
+// of the first, then all the rows of the second and so on.  This is useful
+// when the same query has been run in parts, for instance on several shards
+// or several servers.  If any of the iterators has an error, the merged one
+// returns it when it is reached.
+//
+// Example usage:
+//
+//  rows := r.MergeCursors(
+//      r.Table("heroes_a_m").Run(session),
+//      r.Table("heroes_n_z").Run(session),
+//  )
+//  var heroes []interface{}
+//  err := rows.All(&heroes)
+func MergeCursors(rows ...*Rows) *Rows {
+	return mergeRows(nil, rows)
+}
+
+// MergeCursorsOrdered combines several iterators that are each sorted by the
+// same key into one that returns all of their rows in order.  `key` is called
+// once for each row, with the iterator positioned on that row, and should
+// read the key with .Scan().  Keys may be strings or numbers.  Rows with equal
+// keys are returned in the order of the iterators.
+//
+// Example usage:
+//
+//  byName := func(rows *r.Rows) interface{} {
+//      var hero struct{ Name string }
+//      rows.Scan(&hero)
+//      return hero.Name
+//  }
+//  rows := r.MergeCursorsOrdered(byName,
+//      r.Table("heroes_east").OrderBy("name").Run(session),
+//      r.Table("heroes_west").OrderBy("name").Run(session),
+//  )
+func MergeCursorsOrdered(key func(rows *Rows) interface{}, rows ...*Rows) *Rows {
+	return mergeRows(key, rows)
+}
+
+func mergeRows(key func(*Rows) interface{}, rows []*Rows) *Rows {
+	var sources []*mergeSource
+	for _, r := range rows {
+		sources = append(sources, &mergeSource{rows: r})
+	}
+	return &Rows{
+		sources:      sources,
+		mergeKey:     key,
+		responseType: p.Response_SUCCESS_SEQUENCE,
+	}
+}
+
+// nextFromSources moves to the next row of the merged iterators.
+func (rows *Rows) nextFromSources() bool {
+	// make sure every source is positioned on a row, dropping the ones that
+	// have run out
+	var remaining []*mergeSource
+	for i, source := range rows.sources {
+		if !source.ready {
+			// the source may wait for the server, and .Close() closes the
+			// sources, so it must be able to run in the meantime
+			rows.mu.Unlock()
+			ok := source.rows.Next()
+			rows.mu.Lock()
+			if !ok {
+				if source.rows.Err() != nil {
+					rows.lasterr = source.rows.Err()
+					return false
+				}
+				continue
+			}
+			source.ready = true
+			if rows.mergeKey != nil {
+				source.key = rows.mergeKey(source.rows)
+			}
+		}
+		remaining = append(remaining, source)
+
+		if rows.mergeKey == nil {
+			// without a key, read the sources one after another
+			remaining = append(remaining, rows.sources[i+1:]...)
+			break
+		}
+	}
+	rows.sources = remaining
+
+	if len(rows.sources) == 0 {
+		rows.closed = true
+		return false
+	}
+
+	next := rows.sources[0]
+	if rows.mergeKey != nil {
+		for _, source := range rows.sources[1:] {
+			if lessMergeKey(source.key, next.key) {
+				next = source
+			}
+		}
+	}
+	next.ready = false
+	rows.current = next.rows.current
+	rows.count++
+	return true
+}
+
+// lessMergeKey compares two merge keys.  Numbers of any type are compared by
+// value, keys of different kinds are ordered by kind.
+func lessMergeKey(a, b interface{}) bool {
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return x < y
+		}
+	}
+	x, xok := mergeKeyNumber(a)
+	y, yok := mergeKeyNumber(b)
+	if xok && yok {
+		return x < y
+	}
+	return fmt.Sprintf("%T", a) < fmt.Sprintf("%T", b)
+}
+
+func mergeKeyNumber(key interface{}) (float64, bool) {
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
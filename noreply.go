@@ -0,0 +1,156 @@
+package rethinkgo
+
+// Run queries without waiting for their responses, and keep track of how many
+// of them the server may still be working on.
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"time"
+)
+
+// RunNoreply sends a query to the server without waiting for the response, so
+// the results and any errors are discarded.  This is mostly useful for writes
+// where throughput matters more than knowing that each one succeeded.
+//
+// Use .NoreplyWait() to wait until the server has finished all of the noreply
+// queries sent on the session.
+//
+// Example usage:
+//
+//  err := r.Table("logs").Insert(r.Map{"message": "hello"}).RunNoreply(session)
+func (e Exp) RunNoreply(session *Session) error {
+	return session.RunNoreply(e)
+}
+
+// RunNoreply sends a query to the server without waiting for the response, see
+// Exp.RunNoreply() for details.
+//
+// Example usage:
+//
+//  err := session.RunNoreply(r.Table("logs").Insert(r.Map{"message": "hello"}))
+func (s *Session) RunNoreply(query Exp) error {
+	return s.runNoreply(query, RunOpts{Noreply: Bool(true)})
+}
+
+// runNoreply sends a query with the noreply option set.
+func (s *Session) runNoreply(query Exp, opts RunOpts) (err error) {
+	queryProto, err := s.buildQuery(query, opts)
+	if err != nil {
+		return err
+	}
+	queryProto.Token = proto.Int64(s.getToken())
+	text := s.queryStarted(queryProto)
+	start := time.Now()
+	defer func() {
+		s.queryEnded(queryProto, text, time.Since(start), err)
+	}()
+
+	if debugMode {
+		fmt.Printf("rethinkdb: queryProto:\n%v", protobufToString(queryProto, 1))
+	}
+	if s.timeout == 0 {
+		s.conn.SetDeadline(time.Time{})
+	} else {
+		s.conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	err = s.conn.writeQuery(queryProto)
+	if err == nil {
+		err = s.conn.flushLater(s.timeout)
+	}
+	s.conn.SetDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	s.conn.pendingNoreply++
+	if s.noreplyHighWater > 0 && s.conn.pendingNoreply >= s.noreplyHighWater {
+		return s.NoreplyWait()
+	}
+	return nil
+}
+
+// NoreplyWait waits until the server has finished all of the noreply queries
+// that were sent on this session.
+//
+// Example usage:
+//
+//  for _, message := range messages {
+//      err := r.Table("logs").Insert(message).RunNoreply(session)
+//  }
+//  err := session.NoreplyWait()
+func (s *Session) NoreplyWait() error {
+	queryProto := &p.Query{
+		Type:  p.Query_NOREPLY_WAIT.Enum(),
+		Token: proto.Int64(s.getToken()),
+	}
+	_, responseType, err := s.conn.executeQuery(queryProto, s.timeout)
+	if err != nil {
+		return err
+	}
+	if responseType != p.Response_WAIT_COMPLETE {
+		return fmt.Errorf("rethinkdb: Unexpected response type from server: %v", responseType)
+	}
+	s.conn.pendingNoreply = 0
+	return nil
+}
+
+// PendingNoreply returns the number of noreply queries sent on this session
+// since the last .NoreplyWait().  The server may have finished some or all of
+// them already.
+//
+// Example usage:
+//
+//  fmt.Println("unconfirmed writes:", session.PendingNoreply())
+func (s *Session) PendingNoreply() int {
+	if s.conn == nil {
+		return 0
+	}
+	return s.conn.pendingNoreply
+}
+
+// SetNoreplyHighWater makes .RunNoreply() call .NoreplyWait() whenever the
+// number of pending noreply queries reaches `count`, so that a fast writer
+// cannot build up an unbounded queue of work on the server.  Set to zero to
+// disable, which is the default.
+//
+// Example usage:
+//
+//  session.SetNoreplyHighWater(1000)
+func (s *Session) SetNoreplyHighWater(count int) {
+	s.noreplyHighWater = count
+}
+
+// SetWriteDelay makes .RunNoreply() wait up to `delay` before sending a query,
+// so that noreply queries sent in quick succession go out in a single write
+// instead of one write each.  Queries that wait for a response, and
+// .NoreplyWait(), send any waiting queries straight away.  Set to zero to
+// disable, which is the default.
+//
+// Example usage:
+//
+//  session.SetWriteDelay(time.Millisecond)
+//  for _, entry := range entries {
+//      err := r.Table("logs").Insert(entry).RunNoreply(session)
+//  }
+//  err := session.Flush()
+func (s *Session) SetWriteDelay(delay time.Duration) {
+	s.writeDelay = delay
+	if s.conn != nil {
+		s.conn.flushDelay = delay
+	}
+}
+
+// Flush sends any noreply queries that are waiting because of
+// .SetWriteDelay().
+//
+// Example usage:
+//
+//  err := session.Flush()
+func (s *Session) Flush() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.flush()
+}
@@ -0,0 +1,53 @@
+package rethinkgo
+
+// Run a query one page at a time, along with the total number of rows.
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Page is one page of the results of a query, see Paginate().  It can be
+// encoded to JSON as is, for use as an API response.
+type Page struct {
+	Total   int         `json:"total"`    // number of rows in the whole query
+	Page    int         `json:"page"`     // number of this page, starting at 1
+	PerPage int         `json:"per_page"` // maximum number of rows in each page
+	Pages   int         `json:"pages"`    // number of pages in the whole query
+	Items   interface{} `json:"items"`    // the rows of this page
+}
+
+// Paginate runs a query for a single page of `perPage` rows, numbered from 1,
+// along with a count of all the rows.  Both queries are sent to the server at
+// once, see RunBatch().  The rows are decoded into `items`, which must be a
+// pointer to a slice, and the page's Items field holds the slice.
+//
+// Example usage:
+//
+//  var heroes []Hero
+//  page, err := r.Paginate(session, r.Table("heroes").OrderBy("name"), 2, 20, &heroes)
+//  fmt.Printf("page %v of %v\n", page.Page, page.Pages)
+func Paginate(session *Session, query Exp, page, perPage int, items interface{}) (Page, error) {
+	result := Page{Page: page, PerPage: perPage}
+	if page < 1 || perPage < 1 {
+		return result, fmt.Errorf("rethinkdb: Paginate needs a page and page size of at least 1, got %v and %v", page, perPage)
+	}
+
+	results, err := RunBatch(session, map[string]Exp{
+		"total": query.Count(),
+		"items": query.Skip((page - 1) * perPage).Limit(perPage),
+	})
+	if err != nil {
+		return result, err
+	}
+	if err := results["total"].One(&result.Total); err != nil {
+		return result, err
+	}
+	if err := results["items"].All(items); err != nil {
+		return result, err
+	}
+
+	result.Pages = (result.Total + perPage - 1) / perPage
+	result.Items = reflect.ValueOf(items).Elem().Interface()
+	return result, nil
+}
@@ -0,0 +1,183 @@
+package rethinkgo
+
+// Render queries as ReQL-like text, for logging and error messages.
+
+import (
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"strconv"
+	"strings"
+)
+
+// String renders the query as ReQL-like text in the style of the JavaScript
+// driver, such as r.table("heroes").filter(...).count(), so that queries can
+// be logged or printed with fmt.  Functions are shown with their variables
+// numbered from 1, and the options of a term as a final {key: value} object.
+// A query that cannot be built is shown as the error that .Validate() would
+// return, between angle brackets.
+//
+// Example usage:
+//
+//  query := r.Table("heroes").Map(func(hero r.Exp) r.Exp {
+//      return hero.Attr("speed")
+//  }).Limit(5)
+//  fmt.Println(query)
+//  // r.table("heroes").map(func(var1) { return var1.getField("speed") }).limit(5)
+func (e Exp) String() string {
+	queryProto, err := context{}.buildProtobuf(e)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return queryText(queryProto.GetQuery())
+}
+
+// queryText renders a query as ReQL-like text, with its variables renumbered.
+func queryText(term *p.Term) string {
+	return reqlString(canonicalTerm(term, map[float64]float64{}))
+}
+
+// reqlMethodNames are the names of terms that are not simply the term type in
+// camel case.
+var reqlMethodNames = map[p.Term_TermType]string{
+	p.Term_JAVASCRIPT: "js",
+	p.Term_CONCATMAP:  "concatMap",
+	p.Term_ORDERBY:    "orderBy",
+	p.Term_GROUPBY:    "groupBy",
+	p.Term_TYPEOF:     "typeOf",
+	p.Term_FUNCALL:    "do",
+	p.Term_ISO8601:    "ISO8601",
+	p.Term_TO_ISO8601: "toISO8601",
+}
+
+// reqlTopLevel are the terms that are called on r instead of on their first
+// argument.
+var reqlTopLevel = map[p.Term_TermType]bool{
+	p.Term_JAVASCRIPT: true,
+	p.Term_ERROR:      true,
+	p.Term_DB:         true,
+	p.Term_DB_CREATE:  true,
+	p.Term_DB_DROP:    true,
+	p.Term_DB_LIST:    true,
+	p.Term_BRANCH:     true,
+	p.Term_ASC:        true,
+	p.Term_DESC:       true,
+	p.Term_JSON:       true,
+	p.Term_LITERAL:    true,
+	p.Term_EPOCH_TIME: true,
+	p.Term_NOW:        true,
+	p.Term_TIME:       true,
+	p.Term_ISO8601:    true,
+	p.Term_POINT:      true,
+	p.Term_LINE:       true,
+	p.Term_POLYGON:    true,
+	p.Term_CIRCLE:     true,
+	p.Term_RANDOM:     true,
+	p.Term_UUID:       true,
+	p.Term_BINARY:     true,
+}
+
+// reqlTableTerms are called on r when they have no database argument, and on
+// the database otherwise.
+var reqlTableTerms = map[p.Term_TermType]bool{
+	p.Term_TABLE:        true,
+	p.Term_TABLE_CREATE: true,
+	p.Term_TABLE_DROP:   true,
+	p.Term_TABLE_LIST:   true,
+}
+
+// reqlString renders a term as ReQL-like text, see Exp.String().
+func reqlString(term *p.Term) string {
+	switch term.GetType() {
+	case p.Term_DATUM:
+		return termString(term)
+	case p.Term_JSON:
+		// values marshaled by the driver
+		if len(term.Args) == 1 && term.Args[0].GetDatum().GetType() == p.Datum_R_STR {
+			return termString(term)
+		}
+	case p.Term_VAR:
+		return termString(term)
+	case p.Term_IMPLICIT_VAR:
+		return "r.row"
+	case p.Term_MAKE_ARRAY:
+		return "[" + strings.Join(reqlArgs(term.Args), ", ") + "]"
+	case p.Term_MAKE_OBJ:
+		var fields []string
+		for _, optarg := range term.Optargs {
+			fields = append(fields, strconv.Quote(optarg.GetKey())+": "+reqlString(optarg.Val))
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case p.Term_FUNC:
+		// functions made from r.Row are written as they were
+		if len(term.Args) == 2 && containsImplicitVariable(term.Args[1]) {
+			return reqlString(term.Args[1])
+		}
+		if len(term.Args) == 2 {
+			var params []string
+			for _, param := range term.Args[0].Args {
+				params = append(params, "var"+termString(param))
+			}
+			return "func(" + strings.Join(params, ", ") + ") { return " + reqlString(term.Args[1]) + " }"
+		}
+	case p.Term_FUNCALL:
+		// the function is sent first, but written last
+		if len(term.Args) > 0 {
+			args := append(reqlArgs(term.Args[1:]), reqlString(term.Args[0]))
+			return "r.do(" + strings.Join(args, ", ") + ")"
+		}
+	}
+
+	terms := term.Args
+	if reqlTableTerms[term.GetType()] && len(terms) > 0 && isDefaultDb(terms[0]) {
+		terms = terms[1:]
+	}
+	args := reqlArgs(terms)
+	if len(term.Optargs) > 0 {
+		var options []string
+		for _, optarg := range term.Optargs {
+			options = append(options, optarg.GetKey()+": "+reqlString(optarg.Val))
+		}
+		args = append(args, "{"+strings.Join(options, ", ")+"}")
+	}
+
+	method := reqlMethodName(term.GetType())
+	topLevel := reqlTopLevel[term.GetType()] || len(terms) == 0
+	if reqlTableTerms[term.GetType()] {
+		topLevel = len(terms) == 0 || terms[0].GetType() != p.Term_DB
+	}
+	if topLevel {
+		return "r." + method + "(" + strings.Join(args, ", ") + ")"
+	}
+	subject := args[0]
+	switch terms[0].GetType() {
+	case p.Term_DATUM, p.Term_JSON, p.Term_MAKE_ARRAY, p.Term_MAKE_OBJ:
+		subject = "r.expr(" + subject + ")"
+	}
+	return subject + "." + method + "(" + strings.Join(args[1:], ", ") + ")"
+}
+
+// isDefaultDb is true for the database added to tables when a query is built
+// without a session.
+func isDefaultDb(term *p.Term) bool {
+	return term.GetType() == p.Term_DB && len(term.Args) == 1 && reqlString(term.Args[0]) == `""`
+}
+
+func reqlArgs(terms []*p.Term) []string {
+	args := []string{}
+	for _, term := range terms {
+		args = append(args, reqlString(term))
+	}
+	return args
+}
+
+// reqlMethodName returns the name of the method for a term type, e.g. getAll
+// for GET_ALL.
+func reqlMethodName(termType p.Term_TermType) string {
+	if name, ok := reqlMethodNames[termType]; ok {
+		return name
+	}
+	words := strings.Split(strings.ToLower(termType.String()), "_")
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+	}
+	return strings.Join(words, "")
+}
@@ -0,0 +1,58 @@
+package rethinkgo
+
+// Decode the profiles the server returns for queries run with the profile
+// option.
+
+import (
+	"encoding/json"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+)
+
+// ProfileTask is one step the server took while running a query, see
+// Rows.Profile().
+type ProfileTask struct {
+	// What the server was doing, e.g. "Evaluating filter."
+	Description string `json:"description"`
+	// Time taken, in milliseconds.
+	Duration float64 `json:"duration(ms)"`
+	// For steps that were repeated and sampled, the average time of each
+	// sample and the number of samples.
+	MeanDuration float64 `json:"mean_duration(ms)"`
+	Samples      int     `json:"n_samples"`
+	// Steps taken as part of this one.
+	SubTasks []ProfileTask `json:"sub_tasks"`
+	// Groups of steps that ran at the same time, for example on each shard of
+	// a table.
+	ParallelTasks [][]ProfileTask `json:"parallel_tasks"`
+}
+
+// Profile returns the profile of the query, which lists where the server spent
+// its time running it.  The query must have been run with the Profile option,
+// otherwise there is no profile and the result is nil.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Filter(r.Map{"speed": 7}).RunWith(session, r.RunOpts{Profile: r.Bool(true)})
+//  profile, err := rows.Profile()
+//  for _, task := range profile {
+//      fmt.Printf("%v took %vms\n", task.Description, task.Duration)
+//  }
+func (rows *Rows) Profile() ([]ProfileTask, error) {
+	if rows.profile == nil {
+		return nil, nil
+	}
+	return decodeProfile(rows.profile)
+}
+
+// decodeProfile converts the profile datum returned by the server.
+func decodeProfile(datum *p.Datum) ([]ProfileTask, error) {
+	data, err := datumToJson(datum)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []ProfileTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, newDecodeError(&tasks, data, err)
+	}
+	return tasks, nil
+}
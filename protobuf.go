@@ -6,6 +6,7 @@ package rethinkgo
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
 	"fmt"
 	p "github.com/christopherhesse/rethinkgo/ql2"
 	"reflect"
@@ -18,16 +19,65 @@ import (
 type context struct {
 	databaseName string
 	useOutdated  bool
-	durability   string
-	overwrite    bool
-	atomic       bool
-	returnValues bool
+	// version of the server the query will be sent to, may be unknown
+	serverVersion serverVersion
+	// strings shared by all the terms of the query being built
+	interned *internTable
+	// reject legacy constructs, see Session.SetStrict()
+	strict bool
+	// rewrites table names, or nil, see Session.SetTableResolver()
+	tableResolver func(name string) string
+}
+
+// internTable holds the strings that have already been used while building a
+// query, so that repeated attribute names and optarg keys, which are common in
+// queries that .Pluck() or .Filter() on the same fields many times, share a
+// single allocation.
+type internTable struct {
+	keys    map[string]*string
+	strings map[string]*p.Term
+}
+
+func newInternTable() *internTable {
+	return &internTable{
+		keys:    map[string]*string{},
+		strings: map[string]*p.Term{},
+	}
+}
+
+// key returns a pointer to the string, for use as an assoc pair key.
+func (t *internTable) key(s string) *string {
+	if t == nil {
+		return proto.String(s)
+	}
+	if ptr, ok := t.keys[s]; ok {
+		return ptr
+	}
+	ptr := proto.String(s)
+	t.keys[s] = ptr
+	return ptr
+}
+
+// stringTerm returns the term for a string literal.
+func (t *internTable) stringTerm(s string) *p.Term {
+	if term, ok := t.strings[s]; ok {
+		return term
+	}
+	term, err := datumMarshal(s, true)
+	if err != nil {
+		panic(err)
+	}
+	t.strings[s] = term
+	return term
 }
 
 // toTerm converts an arbitrary object to a Term, within the context that toTerm
 // was called on.
 func (ctx context) toTerm(o interface{}) *p.Term {
-	e := Expr(o)
+	if ctx.strict {
+		checkStrict(Expr(o))
+	}
+	e, termOptargs := unwrapTermOptions(Expr(o))
 
 	var termType p.Term_TermType
 	arguments := e.args
@@ -45,6 +95,7 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 
 	case tableKind:
 		termType = p.Term_TABLE
+		arguments = ctx.resolveTableArgument(arguments)
 		// first arg to table must be the database
 		if len(arguments) == 1 {
 			dbExpr := naryOperator(databaseKind, ctx.databaseName)
@@ -58,7 +109,7 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		termType = p.Term_BETWEEN
 		if len(arguments) == 4 {
 			// last argument is an index
-			options["index"] = arguments[3]
+			setIndexOption(options, arguments[3], arguments[0])
 			arguments = arguments[:3]
 		}
 	case reduceKind:
@@ -74,25 +125,19 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		options["index"] = arguments[3]
 		arguments = arguments[:3]
 
-	case updateKind, deleteKind, replaceKind, insertKind:
-		if ctx.durability != "" {
-			options["durability"] = ctx.durability
-		}
-		if ctx.returnValues {
-			options["return_vals"] = true
-		}
-		switch e.kind {
-		case updateKind:
-			termType = p.Term_UPDATE
-			options["non_atomic"] = !ctx.atomic
-		case deleteKind:
-			termType = p.Term_DELETE
-		case replaceKind:
-			termType = p.Term_REPLACE
-			options["non_atomic"] = !ctx.atomic
-		case insertKind:
-			termType = p.Term_INSERT
-			options["upsert"] = ctx.overwrite
+	case updateKind:
+		termType = p.Term_UPDATE
+		options["non_atomic"] = false
+	case deleteKind:
+		termType = p.Term_DELETE
+	case replaceKind:
+		termType = p.Term_REPLACE
+		options["non_atomic"] = false
+	case insertKind:
+		termType = p.Term_INSERT
+		// servers that understand conflict reject upsert
+		if _, ok := termOptargs["conflict"]; !ok {
+			options["upsert"] = false
 		}
 
 	case tableCreateKind:
@@ -100,6 +145,9 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		// last argument is the table spec
 		spec := arguments[len(arguments)-1].(TableSpec)
 		arguments = arguments[:len(arguments)-1]
+		if ctx.tableResolver != nil && !ctx.inSystemDb(arguments) {
+			spec.Name = ctx.tableResolver(spec.Name)
+		}
 
 		if len(arguments) == 0 {
 			// just spec, need to add database
@@ -122,6 +170,7 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		}
 	case tableDropKind:
 		termType = p.Term_TABLE_DROP
+		arguments = ctx.resolveTableArgument(arguments)
 		if len(arguments) == 1 {
 			// no database specified, use the session database
 			dbExpr := naryOperator(databaseKind, ctx.databaseName)
@@ -136,28 +185,29 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		}
 	case getAllKind:
 		termType = p.Term_GET_ALL
-		options["index"] = arguments[len(arguments)-1]
+		setIndexOption(options, arguments[len(arguments)-1], arguments[0])
 		arguments = arguments[:len(arguments)-1]
 
 	case funcKind:
+		if len(arguments) > 2 && arguments[0] == nil {
+			panic("a function is needed here, not nil")
+		}
 		return ctx.toFuncTerm(arguments[0], arguments[1].(int))
 
 	// special made-up kind to set options on the query
-	case upsertKind:
-		ctx.overwrite = e.args[1].(bool)
-		return ctx.toTerm(e.args[0])
-	case atomicKind:
-		ctx.atomic = e.args[1].(bool)
-		return ctx.toTerm(e.args[0])
 	case useOutdatedKind:
 		ctx.useOutdated = e.args[1].(bool)
 		return ctx.toTerm(e.args[0])
-	case durabilityKind:
-		ctx.durability = e.args[1].(string)
-		return ctx.toTerm(e.args[0])
-	case returnValuesKind:
-		ctx.returnValues = true
+	case useIndexKind:
+		// the index is picked up by the term that follows
 		return ctx.toTerm(e.args[0])
+	case upsertByIndexKind:
+		return ctx.upsertByIndexToTerm(arguments, termOptargs)
+	case deterministicKind:
+		if reflect.ValueOf(e.args[0]).Kind() == reflect.Func {
+			return checkDeterministic(ctx.compileGoFunc(e.args[0], -1))
+		}
+		return checkDeterministic(ctx.toTerm(e.args[0]))
 
 	case jsonKind:
 		termType = p.Term_JSON
@@ -169,6 +219,9 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		termType = p.Term_CONCATMAP
 	case orderByKind:
 		termType = p.Term_ORDERBY
+		if index, ok := indexHint(arguments[0]); ok {
+			options["index"] = index
+		}
 	case distinctKind:
 		termType = p.Term_DISTINCT
 	case countKind:
@@ -269,12 +322,36 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		termType = p.Term_WITHOUT
 	case mergeKind:
 		termType = p.Term_MERGE
+	case mergeLiteralKind:
+		termType = p.Term_LITERAL
+		if len(arguments) > 1 {
+			panic("Literal() takes at most one value")
+		}
 	case indexCreateKind:
 		termType = p.Term_INDEX_CREATE
+		// last argument may be an index spec
+		if spec, ok := arguments[len(arguments)-1].(IndexSpec); ok {
+			arguments = []interface{}{arguments[0], spec.Name}
+			if spec.Function != nil {
+				arguments = append(arguments, funcWrapper(spec.Function, 1))
+			}
+			if spec.Multi {
+				options["multi"] = true
+			}
+			if spec.Geo {
+				options["geo"] = true
+			}
+		}
 	case indexListKind:
 		termType = p.Term_INDEX_LIST
 	case indexDropKind:
 		termType = p.Term_INDEX_DROP
+	case indexStatusKind:
+		termType = p.Term_INDEX_STATUS
+	case indexWaitKind:
+		termType = p.Term_INDEX_WAIT
+	case changesKind:
+		termType = p.Term_CHANGES
 	case funcallKind:
 		termType = p.Term_FUNCALL
 	case branchKind:
@@ -305,21 +382,114 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 		termType = p.Term_DESC
 	case defaultKind:
 		termType = p.Term_DEFAULT
+	case epochTimeKind:
+		termType = p.Term_EPOCH_TIME
+	case nowKind:
+		if clock != nil {
+			return ctx.literalToTerm(clock())
+		}
+		termType = p.Term_NOW
+	case inTimezoneKind:
+		termType = p.Term_IN_TIMEZONE
+	case dateKind:
+		termType = p.Term_DATE
+	case timezoneKind:
+		termType = p.Term_TIMEZONE
+	case yearKind:
+		termType = p.Term_YEAR
+	case monthKind:
+		termType = p.Term_MONTH
+	case dayKind:
+		termType = p.Term_DAY
+	case hoursKind:
+		termType = p.Term_HOURS
+	case minutesKind:
+		termType = p.Term_MINUTES
+	case timeKind:
+		termType = p.Term_TIME
+	case pointKind:
+		termType = p.Term_POINT
+	case lineKind:
+		termType = p.Term_LINE
+	case polygonKind:
+		termType = p.Term_POLYGON
+	case circleKind:
+		termType = p.Term_CIRCLE
+	case distanceKind:
+		termType = p.Term_DISTANCE
+	case intersectsKind:
+		termType = p.Term_INTERSECTS
+	case includesKind:
+		termType = p.Term_INCLUDES
+	case getIntersectingKind:
+		termType = p.Term_GET_INTERSECTING
+		setIndexOption(options, arguments[2], arguments[0])
+		arguments = arguments[:2]
+	case getNearestKind:
+		termType = p.Term_GET_NEAREST
+		setIndexOption(options, arguments[2], arguments[0])
+		arguments = arguments[:2]
+	case uuidKind:
+		termType = p.Term_UUID
+	case randomKind:
+		termType = p.Term_RANDOM
+	case randomFloatKind:
+		termType = p.Term_RANDOM
+		options["float"] = true
+	case sumKind:
+		termType = p.Term_SUM
+	case avgKind:
+		termType = p.Term_AVG
+	case minKind:
+		termType = p.Term_MIN
+	case maxKind:
+		termType = p.Term_MAX
+	case groupKind:
+		termType = p.Term_GROUP
+		if index, ok := indexHint(arguments[0]); ok && len(arguments) == 1 {
+			options["index"] = index
+		}
+	case ungroupKind:
+		termType = p.Term_UNGROUP
+	case binaryKind:
+		termType = p.Term_BINARY
+	case duringKind:
+		termType = p.Term_DURING
+	case timeOfDayKind:
+		termType = p.Term_TIME_OF_DAY
+	case dayOfWeekKind:
+		termType = p.Term_DAY_OF_WEEK
+	case dayOfYearKind:
+		termType = p.Term_DAY_OF_YEAR
+	case secondsKind:
+		termType = p.Term_SECONDS
+	case toEpochTimeKind:
+		termType = p.Term_TO_EPOCH_TIME
+	case toIso8601Kind:
+		termType = p.Term_TO_ISO8601
+	case iso8601Kind:
+		termType = p.Term_ISO8601
 
 	default:
 		panic("invalid term kind")
 	}
 
+	ctx.checkServerVersion(termType)
+
+	for key, value := range termOptargs {
+		options[key] = value
+	}
+
 	args := []*p.Term{}
-	for _, arg := range arguments {
-		args = append(args, ctx.toTerm(arg))
+	for i, arg := range arguments {
+		args = append(args, ctx.childToTerm(termType, fmt.Sprintf("arg %v", i), arg))
 	}
 
 	var optargs []*p.Term_AssocPair
 	for key, value := range options {
 		optarg := &p.Term_AssocPair{
-			Key: proto.String(key),
-			Val: ctx.toTerm(value),
+			Key: ctx.interned.key(key),
+			Val: ctx.childToTerm(termType, "optarg "+key, value),
 		}
 		optargs = append(optargs, optarg)
 	}
@@ -331,6 +501,186 @@ func (ctx context) toTerm(o interface{}) *p.Term {
 	}
 }
 
+// termPathPanic carries a panic up through .toTerm(), collecting the path to
+// the term that caused it.
+type termPathPanic struct {
+	value interface{}
+	path  []string
+}
+
+// childToTerm converts an argument or optarg of a term, adding its position to
+// the path of any panic.
+func (ctx context) childToTerm(parent p.Term_TermType, position string, o interface{}) *p.Term {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			segment := parent.String() + " " + position
+			if pathPanic, ok := r.(termPathPanic); ok {
+				pathPanic.path = append([]string{segment}, pathPanic.path...)
+				panic(pathPanic)
+			}
+			panic(termPathPanic{value: r, path: []string{segment}})
+		}
+	}()
+	return ctx.toTerm(o)
+}
+
+// resolveTableArgument rewrites the table name, the last argument of .Table()
+// and .TableDrop(), with the session's table resolver.  Names that are
+// expressions, and the server's system tables, are sent as they are.
+func (ctx context) resolveTableArgument(arguments []interface{}) []interface{} {
+	name, ok := arguments[len(arguments)-1].(string)
+	if ctx.tableResolver == nil || !ok || ctx.inSystemDb(arguments[:len(arguments)-1]) {
+		return arguments
+	}
+	// copy, since the arguments belong to the expression
+	resolved := append([]interface{}{}, arguments[:len(arguments)-1]...)
+	return append(resolved, ctx.tableResolver(name))
+}
+
+// systemDb is the database holding the server's system tables, such as
+// "jobs".
+const systemDb = "rethinkdb"
+
+// inSystemDb is true if a table term with the given database arguments, which
+// are empty for the session's database, refers to the system database.
+func (ctx context) inSystemDb(dbArguments []interface{}) bool {
+	if len(dbArguments) == 0 {
+		return ctx.databaseName == systemDb
+	}
+	db, ok := dbArguments[0].(Exp)
+	return ok && db.kind == databaseKind && len(db.args) == 1 && db.args[0] == systemDb
+}
+
+// upsertByIndexToTerm expands .UpsertByIndex() into a branch that either
+// inserts or updates, with any write options applied to both writes.
+func (ctx context) upsertByIndexToTerm(arguments []interface{}, optargs map[string]interface{}) *p.Term {
+	table := Expr(arguments[0])
+	index, key, doc := arguments[1].(string), arguments[2], arguments[3]
+	existing := table.GetAll(index, key)
+
+	insert := ctx.toTerm(table.Insert(doc))
+	update := ctx.toTerm(existing.Update(doc))
+	for name, value := range optargs {
+		if name == "upsert" || name == "non_atomic" {
+			// only applies to one of the writes
+			continue
+		}
+		for _, write := range []*p.Term{insert, update} {
+			write.Optargs = append(write.Optargs, &p.Term_AssocPair{
+				Key: ctx.interned.key(name),
+				Val: ctx.toTerm(value),
+			})
+		}
+	}
+
+	return &p.Term{
+		Type: p.Term_BRANCH.Enum(),
+		Args: []*p.Term{ctx.toTerm(existing.IsEmpty()), insert, update},
+	}
+}
+
+// indexHint returns the index given to .UseIndex() if it was called on the
+// sequence.
+func indexHint(sequence interface{}) (string, bool) {
+	e, ok := sequence.(Exp)
+	if !ok || e.kind != useIndexKind {
+		return "", false
+	}
+	return e.args[1].(string), true
+}
+
+// setIndexOption sets the index optarg for .Between() and .GetAll().  An
+// empty index name means the one given to .UseIndex(), or the primary key if
+// there is none.
+func setIndexOption(options map[string]interface{}, index, sequence interface{}) {
+	if index != "" {
+		options["index"] = index
+	} else if hint, ok := indexHint(sequence); ok {
+		options["index"] = hint
+	}
+}
+
+// termOption describes a made-up kind that sets an optarg on the term it is
+// chained on, instead of on every term in the query.
+type termOption struct {
+	method  string           // name of the method that creates it, e.g. "LeftBound"
+	optarg  string           // name of the optarg sent to the server
+	targets []expressionKind // kinds of term it can be chained on
+	usage   string           // explains where it can be used, for errors
+	// converts the arguments of the method to the value of the optarg, if nil
+	// the only argument is used as is
+	value func(args []interface{}) interface{}
+}
+
+var writeKinds = []expressionKind{insertKind, updateKind, replaceKind, deleteKind, upsertByIndexKind}
+
+var termOptions = map[expressionKind]termOption{
+	leftBoundKind:  {"LeftBound", "left_bound", []expressionKind{betweenKind, duringKind}, "directly after .Between() or .During()", nil},
+	rightBoundKind: {"RightBound", "right_bound", []expressionKind{betweenKind, duringKind}, "directly after .Between() or .During()", nil},
+	durabilityKind: {"Durability", "durability", writeKinds, "directly after a write such as .Insert()", nil},
+	upsertKind:     {"Overwrite", "upsert", []expressionKind{insertKind}, "directly after .Insert()", nil},
+	conflictKind: {"OnConflict", "conflict", []expressionKind{insertKind}, "directly after .Insert()",
+		func(args []interface{}) interface{} {
+			if reflect.ValueOf(args[0]).Kind() == reflect.Func {
+				return funcWrapper(args[0], 3)
+			}
+			return args[0]
+		}},
+	atomicKind: {"Atomic", "non_atomic", []expressionKind{updateKind, replaceKind}, "directly after .Update() or .Replace()",
+		func(args []interface{}) interface{} { return !args[0].(bool) }},
+	unitKind: {"Unit", "unit", []expressionKind{distanceKind, circleKind, getNearestKind},
+		"directly after .Distance(), r.Circle() or .GetNearest()", nil},
+	maxDistKind:    {"MaxDist", "max_dist", []expressionKind{getNearestKind}, "directly after .GetNearest()", nil},
+	maxResultsKind: {"MaxResults", "max_results", []expressionKind{getNearestKind}, "directly after .GetNearest()", nil},
+	defaultTimezoneKind: {"DefaultTimezone", "default_timezone", []expressionKind{iso8601Kind},
+		"directly after r.ISO8601()", nil},
+	returnValuesKind: {"ReturnValues", "return_vals", writeKinds, "directly after a write such as .Insert()",
+		func(args []interface{}) interface{} { return true }},
+	includeStatesKind: {"IncludeStates", "include_states", []expressionKind{changesKind}, "directly after .Changes()",
+		func(args []interface{}) interface{} { return true }},
+	returnChangesKind: {"ReturnChanges", "return_changes", writeKinds, "directly after a write such as .Insert()",
+		func(args []interface{}) interface{} { return true }},
+}
+
+// unwrapTermOptions strips any term options off of an expression, returning
+// the term they apply to along with the optargs they set.  If an option is
+// given more than once, the last call wins.
+func unwrapTermOptions(e Exp) (Exp, map[string]interface{}) {
+	optargs := map[string]interface{}{}
+	var used []termOption
+	for {
+		option, ok := termOptions[e.kind]
+		if !ok {
+			break
+		}
+		if _, ok := optargs[option.optarg]; !ok {
+			if option.value == nil {
+				optargs[option.optarg] = e.args[1]
+			} else {
+				optargs[option.optarg] = option.value(e.args[1:])
+			}
+		}
+		used = append(used, option)
+		e = Expr(e.args[0])
+	}
+
+	for _, option := range used {
+		valid := false
+		for _, kind := range option.targets {
+			if e.kind == kind {
+				valid = true
+			}
+		}
+		if !valid {
+			panic(fmt.Sprintf(".%v() can only be used %v", option.method, option.usage))
+		}
+	}
+	return e, optargs
+}
+
 var variableCounter int64 = 0
 
 func nextVariableNumber() int64 {
@@ -358,10 +708,16 @@ func containsImplicitVariable(term *p.Term) bool {
 }
 
 func (ctx context) toFuncTerm(f interface{}, requiredArgs int) *p.Term {
-	if reflect.ValueOf(f).Kind() == reflect.Func {
+	if value := reflect.ValueOf(f); value.Kind() == reflect.Func {
+		if value.IsNil() {
+			panic(fmt.Sprintf("a function is needed here, not a nil %v", value.Type()))
+		}
 		return ctx.compileGoFunc(f, requiredArgs)
 	}
 	e := Expr(f)
+	if e.kind == deterministicKind {
+		return checkDeterministic(ctx.toFuncTerm(e.args[0], requiredArgs))
+	}
 	// the user may pass in a Map with r.Row elements, such as:
 	// 	r.Table("heroes").Filter(r.Map{"durability": r.Row.Attr("speed")})
 	// these have to be sent to the server as a function, but it looks a lot like a
@@ -386,7 +742,7 @@ func (ctx context) compileExpressionFunc(e Exp, requiredArgs int) *p.Term {
 	}
 
 	paramsTerm := paramsToTerm(params)
-	funcTerm := ctx.toTerm(e)
+	funcTerm := ctx.childToTerm(p.Term_FUNC, "arg 1", e)
 
 	return &p.Term{
 		Type: p.Term_FUNC.Enum(),
@@ -400,8 +756,11 @@ func (ctx context) compileGoFunc(f interface{}, requiredArgs int) *p.Term {
 	value := reflect.ValueOf(f)
 	valueType := value.Type()
 
+	if valueType.IsVariadic() {
+		panic(fmt.Sprintf("function %v cannot be variadic", valueType))
+	}
 	if requiredArgs != -1 && valueType.NumIn() != requiredArgs {
-		panic("Function expression has incorrect number of arguments")
+		panic(fmt.Sprintf("function %v should take %v argument(s) of type r.Exp", valueType, requiredArgs))
 	}
 
 	// check input types and generate the variables to pass to the function
@@ -417,17 +776,17 @@ func (ctx context) compileGoFunc(f interface{}, requiredArgs int) *p.Term {
 
 		// make sure all input arguments are of type Exp
 		if !valueType.In(i).AssignableTo(reflect.TypeOf(Exp{})) {
-			panic("Function argument is not of type Exp")
+			panic(fmt.Sprintf("function %v has argument %v of type %v, it should be r.Exp", valueType, i+1, valueType.In(i)))
 		}
 	}
 
 	if valueType.NumOut() != 1 {
-		panic("Function does not have a single return value")
+		panic(fmt.Sprintf("function %v should return a single value", valueType))
 	}
 
 	outValue := value.Call(args)[0]
 	paramsTerm := paramsToTerm(params)
-	funcTerm := ctx.toTerm(outValue.Interface())
+	funcTerm := ctx.childToTerm(p.Term_FUNC, "arg 1", outValue.Interface())
 
 	return &p.Term{
 		Type: p.Term_FUNC.Enum(),
@@ -455,24 +814,125 @@ func paramsToTerm(params []int64) *p.Term {
 	}
 }
 
+// NilEncoding is the way nil slices and maps are sent to the server, see
+// SetNilEncoding().
+type NilEncoding int
+
+const (
+	NilAsEmpty NilEncoding = iota // nil slices become [], nil maps become {}
+	NilAsNull                     // nil slices and maps become null
+)
+
+var nilEncoding = NilAsEmpty
+
+// SetNilEncoding changes how nil slices and maps passed to r.Expr() (or any
+// query method) are sent to the server, including those inside of structs,
+// maps and lists.  By default they are sent as an empty array or object,
+// since that's usually what was meant, use NilAsNull to send null instead.
+// A nil []byte is sent as empty binary data, or null with NilAsNull.  Nil
+// pointers are always sent as null, and struct fields tagged with
+// `json:",omitempty"` are left out when they are nil.
+//
+// Example usage:
+//
+//  var tags []string
+//  r.Expr(tags) // => []
+//  r.SetNilEncoding(r.NilAsNull)
+//  r.Expr(tags) // => null
+func SetNilEncoding(encoding NilEncoding) {
+	nilEncoding = encoding
+}
+
 func (ctx context) literalToTerm(literal interface{}) *p.Term {
 	value := reflect.ValueOf(literal)
 
-	if value.Kind() == reflect.Map {
+	if _, ok := literal.(Matcher); ok {
+		panic("r.Gt() and the other matchers can only be used as values in a .Filter() map")
+	}
+
+	if s, ok := literal.(string); ok && ctx.interned != nil {
+		return ctx.interned.stringTerm(s)
+	}
+
+	// servers before 2.0 get binary data as base64 strings, including binary
+	// data that the driver has already encoded, see toDocument()
+	binary := ctx.serverVersion.supports(p.Term_BINARY)
+	if data, ok := binaryData(literal); ok && !binary {
+		return ctx.literalToTerm(data)
+	}
+
+	// nil []byte values are left to encodeValue(), which sends them as binary
+	isNil := (value.Kind() == reflect.Map || value.Kind() == reflect.Slice) && value.IsNil()
+	if isNil && !isBytes(value.Type()) {
+		if nilEncoding == NilAsNull {
+			literal = nil
+		} else if value.Kind() == reflect.Slice {
+			literal = List{}
+		}
+	}
+
+	if value.Kind() == reflect.Map && literal != nil {
 		return &p.Term{
 			Type:    p.Term_MAKE_OBJ.Enum(),
 			Optargs: ctx.mapToAssocPairs(literal),
 		}
 	}
 
-	term, err := datumMarshal(literal)
+	// lists of plain values are sent as a single datum, but any expressions
+	// in them have to be sent as terms
+	if literal != nil && containsExp(value) {
+		term := &p.Term{Type: p.Term_MAKE_ARRAY.Enum()}
+		for _, item := range toArray(literal) {
+			term.Args = append(term.Args, ctx.toTerm(item))
+		}
+		return term
+	}
+
+	term, err := datumMarshal(literal, binary)
 	if err != nil {
+		if typeErr, ok := err.(*json.UnsupportedTypeError); ok {
+			panic(fmt.Sprintf("a value of type %v cannot be sent to the server", typeErr.Type))
+		}
 		panic(err)
 	}
 
 	return term
 }
 
+// containsExp is true if a list has an Exp in it, either directly or inside of
+// another list or map.
+func containsExp(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Interface:
+		if value.IsNil() {
+			return false
+		}
+		if _, ok := value.Interface().(Exp); ok {
+			return true
+		}
+		return containsExp(value.Elem())
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte
+			return false
+		}
+		for i := 0; i < value.Len(); i++ {
+			if containsExp(value.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			if containsExp(value.MapIndex(key)) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		return value.Type() == reflect.TypeOf(Exp{})
+	}
+	return false
+}
+
 // toArray and toObject seem overly complicated, like maybe some sort
 // of assignment assertion would be enough
 func toArray(a interface{}) []interface{} {
@@ -494,7 +954,7 @@ func toObject(m interface{}) map[string]interface{} {
 	keyType := mapType.Key()
 
 	if keyType.Kind() != reflect.String {
-		panic("string keys only in maps")
+		panic(fmt.Sprintf("map %v cannot be sent to the server, only maps with string keys can", mapType))
 	}
 
 	for _, keyValue := range mapValue.MapKeys() {
@@ -509,7 +969,7 @@ func toObject(m interface{}) map[string]interface{} {
 func (ctx context) mapToAssocPairs(m interface{}) (pairs []*p.Term_AssocPair) {
 	for key, value := range toObject(m) {
 		pair := &p.Term_AssocPair{
-			Key: proto.String(key),
+			Key: ctx.interned.key(key),
 			Val: ctx.toTerm(value),
 		}
 		pairs = append(pairs, pair)
@@ -532,10 +992,17 @@ func (ctx context) buildProtobuf(query Exp) (queryProto *p.Query, err error) {
 			if _, ok := r.(runtime.Error); ok {
 				panic(r)
 			}
-			err = fmt.Errorf("rethinkdb: %v", r)
+			if pathPanic, ok := r.(termPathPanic); ok {
+				err = BuildError{Message: fmt.Sprint(pathPanic.value), Path: pathPanic.path}
+				return
+			}
+			err = BuildError{Message: fmt.Sprint(r)}
 		}
 	}()
 
+	if ctx.interned == nil {
+		ctx.interned = newInternTable()
+	}
 	queryProto = query.toProtobuf(ctx)
 	return
 }
@@ -546,3 +1013,20 @@ func (e Exp) Check(s *Session) error {
 	_, err := s.getContext().buildProtobuf(e)
 	return err
 }
+
+// Validate looks for mistakes in a query that would stop it from being sent
+// to the server, such as a nil or wrongly typed function given to .Map(), or
+// a value that cannot be converted to JSON, without needing a session.  The
+// BuildError it returns has the path to the part of the query that is wrong.
+// Unlike .Check(), it does not know the server's version, so it cannot tell if
+// the server supports every term.
+//
+// Example usage:
+//
+//  err := r.Table("heroes").Map(func(a, b r.Exp) r.Exp { return a }).Validate()
+//  // rethinkdb: function func(rethinkgo.Exp, rethinkgo.Exp) rethinkgo.Exp
+//  // should take 1 argument(s) of type r.Exp, at MAP arg 1
+func (e Exp) Validate() error {
+	_, err := context{}.buildProtobuf(e)
+	return err
+}
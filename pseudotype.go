@@ -0,0 +1,114 @@
+package rethinkgo
+
+// Convert the server's pseudo-types, objects with a "$reql_type$" field, into
+// plain JSON values that can be decoded into Go types.
+
+import (
+	"encoding/json"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"math"
+	"strings"
+	"time"
+)
+
+// pseudoTypeFormat says which pseudo-types to leave as they are, the zero
+// value converts all of them.
+type pseudoTypeFormat struct {
+	rawTime bool
+	// convert GROUPED_DATA to an object keyed by group, for decoding into a
+	// map
+	groupsAsObject bool
+}
+
+// pseudoTypeToJson converts a pseudo-type object to JSON.  ok is false if the
+// datum is not a pseudo-type that should be converted.
+//
+//  TIME becomes an RFC 3339 string, which decodes into a time.Time
+//  BINARY becomes a base64 string, which decodes into a []byte
+//  GROUPED_DATA becomes a list of {"group": ..., "reduction": ...} objects, or
+//    an object of {group: reduction}, see groupKey()
+//
+// Pseudo-types nested anywhere inside the value are converted as well.
+func pseudoTypeToJson(datum *p.Datum, format pseudoTypeFormat) (data []byte, ok bool, err error) {
+	fields := map[string]*p.Datum{}
+	for _, assoc := range datum.GetRObject() {
+		fields[assoc.GetKey()] = assoc.GetVal()
+	}
+	reqlType := fields["$reql_type$"]
+	if reqlType == nil || reqlType.GetType() != p.Datum_R_STR {
+		return nil, false, nil
+	}
+
+	switch reqlType.GetRStr() {
+	case "TIME":
+		if format.rawTime {
+			return nil, false, nil
+		}
+		t, err := pseudoTime(fields["epoch_time"].GetRNum(), fields["timezone"].GetRStr())
+		if err != nil {
+			return nil, true, err
+		}
+		data, err = json.Marshal(t.Format(time.RFC3339Nano))
+		return data, true, err
+	case "BINARY":
+		data, err = json.Marshal(fields["data"].GetRStr())
+		return data, true, err
+	case "GROUPED_DATA":
+		items := []string{}
+		for _, pair := range fields["data"].GetRArray() {
+			values := pair.GetRArray()
+			if len(values) != 2 {
+				return nil, true, fmt.Errorf("rethinkdb: Malformed GROUPED_DATA from server")
+			}
+			group, err := datumToJsonFormat(values[0], format)
+			if err != nil {
+				return nil, true, err
+			}
+			reduction, err := datumToJsonFormat(values[1], format)
+			if err != nil {
+				return nil, true, err
+			}
+			if format.groupsAsObject {
+				items = append(items, groupKey(group)+`:`+string(reduction))
+			} else {
+				items = append(items, `{"group":`+string(group)+`,"reduction":`+string(reduction)+`}`)
+			}
+		}
+		if format.groupsAsObject {
+			return []byte("{" + strings.Join(items, ",") + "}"), true, nil
+		}
+		return []byte("[" + strings.Join(items, ",") + "]"), true, nil
+	}
+	return nil, false, nil
+}
+
+// groupKey converts the JSON of a group to an object key.  Strings are used as
+// they are, other groups, such as 1 or [1, "a"], as their JSON text, so
+// numbers can be decoded into maps with integer keys.
+func groupKey(group []byte) string {
+	if len(group) > 0 && group[0] == '"' {
+		return string(group)
+	}
+	key, _ := json.Marshal(string(group))
+	return string(key)
+}
+
+// pseudoTime converts the fields of a TIME pseudo-type to a time.Time.
+func pseudoTime(epochTime float64, timezone string) (time.Time, error) {
+	seconds := math.Floor(epochTime)
+	// the server keeps times to the millisecond, round off any error from the
+	// floating point representation
+	nanoseconds := math.Floor((epochTime-seconds)*1e6+0.5) * 1e3
+	t := time.Unix(int64(seconds), int64(nanoseconds)).UTC()
+
+	if timezone == "" || timezone == "Z" {
+		return t, nil
+	}
+	zone, err := time.Parse("-07:00", timezone)
+	if err != nil {
+		return t, fmt.Errorf("rethinkdb: Unknown timezone from server: %q", timezone)
+	}
+	_, offset := zone.Zone()
+	return t.In(time.FixedZone(timezone, offset)), nil
+}
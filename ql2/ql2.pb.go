@@ -52,20 +52,26 @@ func (x *VersionDummy_Version) UnmarshalJSON(data []byte) error {
 type Query_QueryType int32
 
 const (
-	Query_START    Query_QueryType = 1
-	Query_CONTINUE Query_QueryType = 2
-	Query_STOP     Query_QueryType = 3
+	Query_START        Query_QueryType = 1
+	Query_CONTINUE     Query_QueryType = 2
+	Query_STOP         Query_QueryType = 3
+	Query_NOREPLY_WAIT Query_QueryType = 4
+	Query_SERVER_INFO  Query_QueryType = 5
 )
 
 var Query_QueryType_name = map[int32]string{
 	1: "START",
 	2: "CONTINUE",
 	3: "STOP",
+	4: "NOREPLY_WAIT",
+	5: "SERVER_INFO",
 }
 var Query_QueryType_value = map[string]int32{
-	"START":    1,
-	"CONTINUE": 2,
-	"STOP":     3,
+	"START":        1,
+	"CONTINUE":     2,
+	"STOP":         3,
+	"NOREPLY_WAIT": 4,
+	"SERVER_INFO":  5,
 }
 
 func (x Query_QueryType) Enum() *Query_QueryType {
@@ -130,6 +136,8 @@ const (
 	Response_SUCCESS_ATOM     Response_ResponseType = 1
 	Response_SUCCESS_SEQUENCE Response_ResponseType = 2
 	Response_SUCCESS_PARTIAL  Response_ResponseType = 3
+	Response_WAIT_COMPLETE    Response_ResponseType = 4
+	Response_SERVER_INFO      Response_ResponseType = 5
 	Response_CLIENT_ERROR     Response_ResponseType = 16
 	Response_COMPILE_ERROR    Response_ResponseType = 17
 	Response_RUNTIME_ERROR    Response_ResponseType = 18
@@ -139,6 +147,8 @@ var Response_ResponseType_name = map[int32]string{
 	1:  "SUCCESS_ATOM",
 	2:  "SUCCESS_SEQUENCE",
 	3:  "SUCCESS_PARTIAL",
+	4:  "WAIT_COMPLETE",
+	5:  "SERVER_INFO",
 	16: "CLIENT_ERROR",
 	17: "COMPILE_ERROR",
 	18: "RUNTIME_ERROR",
@@ -147,6 +157,8 @@ var Response_ResponseType_value = map[string]int32{
 	"SUCCESS_ATOM":     1,
 	"SUCCESS_SEQUENCE": 2,
 	"SUCCESS_PARTIAL":  3,
+	"WAIT_COMPLETE":    4,
+	"SERVER_INFO":      5,
 	"CLIENT_ERROR":     16,
 	"COMPILE_ERROR":    17,
 	"RUNTIME_ERROR":    18,
@@ -314,100 +326,182 @@ const (
 	Term_SAMPLE             Term_TermType = 81
 	Term_DEFAULT            Term_TermType = 92
 	Term_JSON               Term_TermType = 98
+	Term_LITERAL            Term_TermType = 137
+	Term_EPOCH_TIME         Term_TermType = 101
+	Term_INDEX_STATUS       Term_TermType = 139
+	Term_INDEX_WAIT         Term_TermType = 140
+	Term_CHANGES            Term_TermType = 152
+	Term_NOW                Term_TermType = 103
+	Term_IN_TIMEZONE        Term_TermType = 104
+	Term_DATE               Term_TermType = 106
+	Term_TIMEZONE           Term_TermType = 127
+	Term_YEAR               Term_TermType = 128
+	Term_MONTH              Term_TermType = 129
+	Term_DAY                Term_TermType = 130
+	Term_HOURS              Term_TermType = 133
+	Term_MINUTES            Term_TermType = 134
+	Term_TIME               Term_TermType = 136
+	Term_POINT              Term_TermType = 159
+	Term_LINE               Term_TermType = 160
+	Term_POLYGON            Term_TermType = 161
+	Term_DISTANCE           Term_TermType = 162
+	Term_INTERSECTS         Term_TermType = 163
+	Term_INCLUDES           Term_TermType = 164
+	Term_CIRCLE             Term_TermType = 165
+	Term_GET_INTERSECTING   Term_TermType = 166
+	Term_GET_NEAREST        Term_TermType = 168
+	Term_RANDOM             Term_TermType = 151
+	Term_UUID               Term_TermType = 169
+	Term_SUM                Term_TermType = 145
+	Term_AVG                Term_TermType = 146
+	Term_MIN                Term_TermType = 147
+	Term_MAX                Term_TermType = 148
+	Term_GROUP              Term_TermType = 144
+	Term_UNGROUP            Term_TermType = 150
+	Term_BINARY             Term_TermType = 155
+	Term_TO_ISO8601         Term_TermType = 100
+	Term_TO_EPOCH_TIME      Term_TermType = 102
+	Term_DURING             Term_TermType = 105
+	Term_TIME_OF_DAY        Term_TermType = 126
+	Term_DAY_OF_WEEK        Term_TermType = 131
+	Term_DAY_OF_YEAR        Term_TermType = 132
+	Term_SECONDS            Term_TermType = 135
+	Term_ISO8601            Term_TermType = 99
 )
 
 var Term_TermType_name = map[int32]string{
-	1:  "DATUM",
-	2:  "MAKE_ARRAY",
-	3:  "MAKE_OBJ",
-	10: "VAR",
-	11: "JAVASCRIPT",
-	12: "ERROR",
-	13: "IMPLICIT_VAR",
-	14: "DB",
-	15: "TABLE",
-	16: "GET",
-	78: "GET_ALL",
-	17: "EQ",
-	18: "NE",
-	19: "LT",
-	20: "LE",
-	21: "GT",
-	22: "GE",
-	23: "NOT",
-	24: "ADD",
-	25: "SUB",
-	26: "MUL",
-	27: "DIV",
-	28: "MOD",
-	29: "APPEND",
-	80: "PREPEND",
-	95: "DIFFERENCE",
-	88: "SET_INSERT",
-	89: "SET_INTERSECTION",
-	90: "SET_UNION",
-	91: "SET_DIFFERENCE",
-	30: "SLICE",
-	70: "SKIP",
-	71: "LIMIT",
-	87: "INDEXES_OF",
-	93: "CONTAINS",
-	31: "GET_FIELD",
-	94: "KEYS",
-	32: "HAS_FIELDS",
-	96: "WITH_FIELDS",
-	33: "PLUCK",
-	34: "WITHOUT",
-	35: "MERGE",
-	36: "BETWEEN",
-	37: "REDUCE",
-	38: "MAP",
-	39: "FILTER",
-	40: "CONCATMAP",
-	41: "ORDERBY",
-	42: "DISTINCT",
-	43: "COUNT",
-	86: "IS_EMPTY",
-	44: "UNION",
-	45: "NTH",
-	46: "GROUPED_MAP_REDUCE",
-	47: "GROUPBY",
-	48: "INNER_JOIN",
-	49: "OUTER_JOIN",
-	50: "EQ_JOIN",
-	72: "ZIP",
-	82: "INSERT_AT",
-	83: "DELETE_AT",
-	84: "CHANGE_AT",
-	85: "SPLICE_AT",
-	51: "COERCE_TO",
-	52: "TYPEOF",
-	53: "UPDATE",
-	54: "DELETE",
-	55: "REPLACE",
-	56: "INSERT",
-	57: "DB_CREATE",
-	58: "DB_DROP",
-	59: "DB_LIST",
-	60: "TABLE_CREATE",
-	61: "TABLE_DROP",
-	62: "TABLE_LIST",
-	75: "INDEX_CREATE",
-	76: "INDEX_DROP",
-	77: "INDEX_LIST",
-	64: "FUNCALL",
-	65: "BRANCH",
-	66: "ANY",
-	67: "ALL",
-	68: "FOREACH",
-	69: "FUNC",
-	73: "ASC",
-	74: "DESC",
-	79: "INFO",
-	97: "MATCH",
-	81: "SAMPLE",
-	92: "DEFAULT",
-	98: "JSON",
+	1:   "DATUM",
+	2:   "MAKE_ARRAY",
+	3:   "MAKE_OBJ",
+	10:  "VAR",
+	11:  "JAVASCRIPT",
+	12:  "ERROR",
+	13:  "IMPLICIT_VAR",
+	14:  "DB",
+	15:  "TABLE",
+	16:  "GET",
+	78:  "GET_ALL",
+	17:  "EQ",
+	18:  "NE",
+	19:  "LT",
+	20:  "LE",
+	21:  "GT",
+	22:  "GE",
+	23:  "NOT",
+	24:  "ADD",
+	25:  "SUB",
+	26:  "MUL",
+	27:  "DIV",
+	28:  "MOD",
+	29:  "APPEND",
+	80:  "PREPEND",
+	95:  "DIFFERENCE",
+	88:  "SET_INSERT",
+	89:  "SET_INTERSECTION",
+	90:  "SET_UNION",
+	91:  "SET_DIFFERENCE",
+	30:  "SLICE",
+	70:  "SKIP",
+	71:  "LIMIT",
+	87:  "INDEXES_OF",
+	93:  "CONTAINS",
+	31:  "GET_FIELD",
+	94:  "KEYS",
+	32:  "HAS_FIELDS",
+	96:  "WITH_FIELDS",
+	33:  "PLUCK",
+	34:  "WITHOUT",
+	35:  "MERGE",
+	36:  "BETWEEN",
+	37:  "REDUCE",
+	38:  "MAP",
+	39:  "FILTER",
+	40:  "CONCATMAP",
+	41:  "ORDERBY",
+	42:  "DISTINCT",
+	43:  "COUNT",
+	86:  "IS_EMPTY",
+	44:  "UNION",
+	45:  "NTH",
+	46:  "GROUPED_MAP_REDUCE",
+	47:  "GROUPBY",
+	48:  "INNER_JOIN",
+	49:  "OUTER_JOIN",
+	50:  "EQ_JOIN",
+	72:  "ZIP",
+	82:  "INSERT_AT",
+	83:  "DELETE_AT",
+	84:  "CHANGE_AT",
+	85:  "SPLICE_AT",
+	51:  "COERCE_TO",
+	52:  "TYPEOF",
+	53:  "UPDATE",
+	54:  "DELETE",
+	55:  "REPLACE",
+	56:  "INSERT",
+	57:  "DB_CREATE",
+	58:  "DB_DROP",
+	59:  "DB_LIST",
+	60:  "TABLE_CREATE",
+	61:  "TABLE_DROP",
+	62:  "TABLE_LIST",
+	75:  "INDEX_CREATE",
+	76:  "INDEX_DROP",
+	77:  "INDEX_LIST",
+	64:  "FUNCALL",
+	65:  "BRANCH",
+	66:  "ANY",
+	67:  "ALL",
+	68:  "FOREACH",
+	69:  "FUNC",
+	73:  "ASC",
+	74:  "DESC",
+	79:  "INFO",
+	97:  "MATCH",
+	81:  "SAMPLE",
+	92:  "DEFAULT",
+	98:  "JSON",
+	137: "LITERAL",
+	101: "EPOCH_TIME",
+	139: "INDEX_STATUS",
+	140: "INDEX_WAIT",
+	152: "CHANGES",
+	103: "NOW",
+	104: "IN_TIMEZONE",
+	106: "DATE",
+	127: "TIMEZONE",
+	128: "YEAR",
+	129: "MONTH",
+	130: "DAY",
+	133: "HOURS",
+	134: "MINUTES",
+	136: "TIME",
+	159: "POINT",
+	160: "LINE",
+	161: "POLYGON",
+	162: "DISTANCE",
+	163: "INTERSECTS",
+	164: "INCLUDES",
+	165: "CIRCLE",
+	166: "GET_INTERSECTING",
+	168: "GET_NEAREST",
+	151: "RANDOM",
+	169: "UUID",
+	145: "SUM",
+	146: "AVG",
+	147: "MIN",
+	148: "MAX",
+	144: "GROUP",
+	150: "UNGROUP",
+	155: "BINARY",
+	100: "TO_ISO8601",
+	102: "TO_EPOCH_TIME",
+	105: "DURING",
+	126: "TIME_OF_DAY",
+	131: "DAY_OF_WEEK",
+	132: "DAY_OF_YEAR",
+	135: "SECONDS",
+	99:  "ISO8601",
 }
 var Term_TermType_value = map[string]int32{
 	"DATUM":              1,
@@ -501,6 +595,47 @@ var Term_TermType_value = map[string]int32{
 	"SAMPLE":             81,
 	"DEFAULT":            92,
 	"JSON":               98,
+	"LITERAL":            137,
+	"EPOCH_TIME":         101,
+	"INDEX_STATUS":       139,
+	"INDEX_WAIT":         140,
+	"CHANGES":            152,
+	"NOW":                103,
+	"IN_TIMEZONE":        104,
+	"DATE":               106,
+	"TIMEZONE":           127,
+	"YEAR":               128,
+	"MONTH":              129,
+	"DAY":                130,
+	"HOURS":              133,
+	"MINUTES":            134,
+	"TIME":               136,
+	"POINT":              159,
+	"LINE":               160,
+	"POLYGON":            161,
+	"DISTANCE":           162,
+	"INTERSECTS":         163,
+	"INCLUDES":           164,
+	"CIRCLE":             165,
+	"GET_INTERSECTING":   166,
+	"GET_NEAREST":        168,
+	"RANDOM":             151,
+	"UUID":               169,
+	"SUM":                145,
+	"AVG":                146,
+	"MIN":                147,
+	"MAX":                148,
+	"GROUP":              144,
+	"UNGROUP":            150,
+	"BINARY":             155,
+	"TO_ISO8601":         100,
+	"TO_EPOCH_TIME":      102,
+	"DURING":             105,
+	"TIME_OF_DAY":        126,
+	"DAY_OF_WEEK":        131,
+	"DAY_OF_YEAR":        132,
+	"SECONDS":            135,
+	"ISO8601":            99,
 }
 
 func (x Term_TermType) Enum() *Term_TermType {
@@ -658,6 +793,7 @@ type Response struct {
 	Token            *int64                 `protobuf:"varint,2,opt,name=token" json:"token,omitempty"`
 	Response         []*Datum               `protobuf:"bytes,3,rep,name=response" json:"response,omitempty"`
 	Backtrace        *Backtrace             `protobuf:"bytes,4,opt,name=backtrace" json:"backtrace,omitempty"`
+	Profile          *Datum                 `protobuf:"bytes,5,opt,name=profile" json:"profile,omitempty"`
 	XXX_unrecognized []byte                 `json:"-"`
 }
 
@@ -693,6 +829,13 @@ func (m *Response) GetBacktrace() *Backtrace {
 	return nil
 }
 
+func (m *Response) GetProfile() *Datum {
+	if m != nil {
+		return m.Profile
+	}
+	return nil
+}
+
 type Datum struct {
 	Type             *Datum_DatumType          `protobuf:"varint,1,opt,name=type,enum=Datum_DatumType" json:"type,omitempty"`
 	RBool            *bool                     `protobuf:"varint,2,opt,name=r_bool" json:"r_bool,omitempty"`
@@ -5,6 +5,10 @@ package rethinkgo
 // interface{} is effectively a void* type that we look at later to determine
 // the underlying type and perform any conversions.
 
+import (
+	"time"
+)
+
 // Map is a shorter name for a mapping from strings to arbitrary objects
 type Map map[string]interface{}
 
@@ -18,10 +22,14 @@ const (
 	allKind
 	anyKind
 	appendKind
+	avgKind
 	ascendingKind
 	betweenKind
+	binaryKind
 	branchKind
 	changeAtKind
+	changesKind
+	circleKind
 	coerceToKind
 	concatMapKind
 	containsKind
@@ -30,13 +38,20 @@ const (
 	databaseDropKind
 	databaseKind
 	databaseListKind
+	dateKind
+	dayKind
+	dayOfWeekKind
+	dayOfYearKind
 	deleteAtKind
 	deleteKind
 	descendingKind
 	differenceKind
+	distanceKind
 	distinctKind
 	divideKind
+	duringKind
 	defaultKind
+	epochTimeKind
 	eqJoinKind
 	equalityKind
 	errorKind
@@ -46,22 +61,33 @@ const (
 	funcKind
 	getAllKind
 	getFieldKind
+	getIntersectingKind
+	getNearestKind
 	getKind
 	greaterThanKind
 	greaterThanOrEqualKind
+	groupKind
 	groupByKind
 	groupedMapReduceKind
 	hasFieldsKind
+	hoursKind
 	implicitVariableKind
+	includesKind
 	indexCreateKind
 	indexDropKind
 	indexesOfKind
 	indexListKind
+	indexStatusKind
+	indexWaitKind
 	inequalityKind
 	infoKind
 	innerJoinKind
+	includeStatesKind
+	inTimezoneKind
+	iso8601Kind
 	insertAtKind
 	insertKind
+	intersectsKind
 	isEmptyKind
 	javascriptKind
 	jsonKind
@@ -69,21 +95,33 @@ const (
 	lessThanKind
 	lessThanOrEqualKind
 	limitKind
+	lineKind
 	logicalNotKind
+	maxKind
 	mapKind
 	matchKind
 	mergeKind
+	mergeLiteralKind
+	minutesKind
+	minKind
 	moduloKind
+	monthKind
 	multiplyKind
+	nowKind
 	nthKind
 	orderByKind
 	outerJoinKind
 	pluckKind
+	pointKind
+	polygonKind
 	prependKind
+	randomKind
 	reduceKind
+	returnChangesKind
 	returnValuesKind
 	replaceKind
 	sampleKind
+	secondsKind
 	setDifferenceKind
 	setInsertKind
 	setIntersectionKind
@@ -92,24 +130,44 @@ const (
 	sliceKind
 	spliceAtKind
 	subtractKind
+	sumKind
 	tableCreateKind
 	tableDropKind
 	tableKind
 	tableListKind
+	timeKind
+	timeOfDayKind
+	timezoneKind
+	toEpochTimeKind
+	toIso8601Kind
 	typeOfKind
+	ungroupKind
 	unionKind
 	updateKind
+	uuidKind
 	variableKind
 	withFieldsKind
 	withoutKind
+	yearKind
 	zipKind
 
 	// custom rethinkgo ones
 	upsertKind
+	conflictKind
 	atomicKind
 	useOutdatedKind
+	useIndexKind
+	upsertByIndexKind
+	deterministicKind
+	defaultTimezoneKind
 	durabilityKind
 	literalKind
+	leftBoundKind
+	rightBoundKind
+	unitKind
+	maxDistKind
+	maxResultsKind
+	randomFloatKind
 )
 
 func nullaryOperator(kind expressionKind) Exp {
@@ -134,6 +192,13 @@ func funcWrapper(f interface{}, arity int) Exp {
 	return naryOperator(funcKind, f, arity)
 }
 
+// requiredFuncWrapper is funcWrapper for arguments that have to be a function,
+// such as the one given to .Map(), where nil is a mistake rather than a null
+// value.
+func requiredFuncWrapper(f interface{}, arity int) Exp {
+	return naryOperator(funcKind, f, arity, true)
+}
+
 // Exp represents an RQL expression, such as the return value of
 // r.Expr(). Exp has all the RQL methods on it, such as .Add(), .Attr(),
 // .Filter() etc.
@@ -176,6 +241,9 @@ func funcWrapper(f interface{}, arity int) Exp {
 type Exp struct { // this would be Expr, but then it would conflict with the function that creates Exp instances
 	args []interface{}
 	kind expressionKind
+	// what to use instead, for expressions that strict mode rejects, see
+	// Session.SetStrict()
+	legacy string
 }
 
 // Row supplies access to the current row in any query, even if there's no go
@@ -238,6 +306,27 @@ func Json(value string) Exp {
 	return naryOperator(jsonKind, value)
 }
 
+// Binary creates binary data, which the server stores as bytes instead of as
+// a string.  Given a []byte, the bytes are sent as they are, otherwise the
+// string the value evaluates to is converted on the server.  A []byte
+// anywhere in a document is also sent as binary data, and binary data in rows
+// can be decoded into a []byte.  Servers before 2.0 have no binary data, so
+// they are sent []byte values as base64 strings instead.
+//
+// Example usage:
+//
+//  hash := sha1.Sum(contents)
+//  err := r.Table("files").Insert(r.Map{"name": name, "sha1": r.Binary(hash[:])}).Run(session).Exec()
+//
+//  var response []byte
+//  err := r.Binary(r.Expr("hello")).Run(session).One(&response)
+func Binary(data interface{}) Exp {
+	if _, ok := data.([]byte); ok {
+		return Expr(data)
+	}
+	return naryOperator(binaryKind, data)
+}
+
 ///////////
 // Terms //
 ///////////
@@ -290,6 +379,39 @@ func JsWithTimeout(body string, timeout float64) Exp {
 	return naryOperator(javascriptKind, body, timeout)
 }
 
+// UUID generates a random UUID on the server.
+//
+// Example usage:
+//
+//  r.Table("heroes").Insert(r.Map{"name": "Thing", "secret_identity": r.UUID()})
+func UUID() Exp {
+	return nullaryOperator(uuidKind)
+}
+
+// Random returns a random integer from `low` up to, but not including,
+// `high`.  With a single bound the range starts at zero.  With no bounds it
+// returns a float from 0 up to 1, see RandomFloat().
+//
+// Example usage:
+//
+//  r.Random(1, 7) => 4
+//  // a random hero
+//  heroes := r.Table("heroes")
+//  heroes.Nth(r.Random(heroes.Count()))
+func Random(bounds ...interface{}) Exp {
+	return Exp{kind: randomKind, args: bounds}
+}
+
+// RandomFloat is like Random(), but returns a float in the range instead of an
+// integer.
+//
+// Example usage:
+//
+//  r.RandomFloat(-1, 1) => -0.2867
+func RandomFloat(bounds ...interface{}) Exp {
+	return Exp{kind: randomFloatKind, args: bounds}
+}
+
 // RuntimeError tells the server to respond with a ErrRuntime, useful for
 // testing.
 //
@@ -360,6 +482,49 @@ func (e Exp) GetAll(index string, values ...interface{}) Exp {
 	return naryOperator(getAllKind, e, append(values, index)...)
 }
 
+var getAllChunkSize = 1000
+
+// SetGetAllChunkSize sets the largest number of keys sent in a single
+// .GetAll() term.  When a query that is just a .GetAll() has more keys than
+// this, it is run as several queries and the results are returned as one set
+// of Rows, since the server may reject terms with very many arguments.  Set to
+// zero to never split queries.  The default is 1000.
+//
+// Example usage:
+//
+//  r.SetGetAllChunkSize(500)
+func SetGetAllChunkSize(size int) {
+	getAllChunkSize = size
+}
+
+// splitGetAll splits a .GetAll() query with too many keys into several
+// queries.
+func splitGetAll(query Exp) ([]Exp, bool) {
+	if query.kind != getAllKind || getAllChunkSize <= 0 {
+		return nil, false
+	}
+	// arguments are the sequence, the keys, then the index
+	sequence := query.args[0]
+	keys := query.args[1 : len(query.args)-1]
+	index := query.args[len(query.args)-1]
+	if len(keys) <= getAllChunkSize {
+		return nil, false
+	}
+
+	var chunks []Exp
+	for len(keys) > 0 {
+		n := getAllChunkSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		args := append([]interface{}{}, keys[:n]...)
+		args = append(args, index)
+		chunks = append(chunks, naryOperator(getAllKind, sequence, args...))
+		keys = keys[n:]
+	}
+	return chunks, true
+}
+
 // GroupBy does a sort of grouped map reduce.  First the server groups all rows
 // that have the same value for `attribute`, then it applys the map reduce to
 // each group.  It takes one of the following reductions: r.Count(),
@@ -398,7 +563,72 @@ func (e Exp) GroupBy(attribute, groupedMapReduce interface{}) Exp {
 	if ok {
 		attribute = List{attribute}
 	}
-	return naryOperator(groupByKind, e, attribute, groupedMapReduce)
+	return legacy(naryOperator(groupByKind, e, attribute, groupedMapReduce),
+		".GroupBy() was removed in server 1.12, use .Group() followed by an aggregation such as .Count() instead")
+}
+
+// Group groups the elements of a sequence by the value of one or more fields,
+// or functions of each element.  The terms that follow it, such as .Count(),
+// .Sum() or .Map(), are applied to each group separately, and the result is a
+// list of {"group": ..., "reduction": ...} objects, which can be read into a
+// []GroupResult.  Reading it into a map instead gives a map from each group to
+// its reduction, where groups that are not strings are keyed by their JSON,
+// e.g. "1" or "[1,2]".  With no fields, the rows are grouped by the index
+// given to .UseIndex().  Call .Ungroup() to go on to work with the list on
+// the server.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  // Count the heroes with each durability
+//  err := r.Table("heroes").Group("durability").Count().Run(session).One(&response)
+//
+// Example response:
+//
+//  [
+//    {
+//      "group": 1,
+//      "reduction": 4
+//    },
+//    {
+//      "group": 2,
+//      "reduction": 7
+//    },
+//    ...
+//  ]
+//
+// Example with a function and an index:
+//
+//  // Read the counts into a map
+//  var counts map[int]int
+//  err = r.Table("heroes").Group("durability").Count().Run(session).One(&counts)
+//
+//  // Find the fastest hero in each affiliation and strength
+//  query := r.Table("heroes").Group("affiliation", func(row r.Exp) r.Exp {
+//      return row.Attr("strength")
+//  }).Max("speed")
+//  // Count the heroes with each name, using the name index
+//  query = r.Table("heroes").UseIndex("name").Group().Count()
+func (e Exp) Group(fieldsOrFuncs ...interface{}) Exp {
+	args := []interface{}{e}
+	for _, fieldOrFunc := range fieldsOrFuncs {
+		args = append(args, funcWrapper(fieldOrFunc, 1))
+	}
+	return Exp{kind: groupKind, args: args}
+}
+
+// Ungroup turns the result of .Group() into a list of {"group": ...,
+// "reduction": ...} objects, so that the query can go on to work with the
+// list as a whole, instead of with each group.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  // The three most common durabilities
+//  err := r.Table("heroes").Group("durability").Count().Ungroup().
+//      OrderBy(r.Desc("reduction")).Limit(3).Run(session).All(&response)
+func (e Exp) Ungroup() Exp {
+	return naryOperator(ungroupKind, e)
 }
 
 // UseOutdated tells the server to use potentially out-of-date data from all
@@ -415,11 +645,28 @@ func (e Exp) GroupBy(attribute, groupedMapReduce interface{}) Exp {
 //  compareFunc := r.Row.Attr("strength").Eq(villain_strength)
 //  rows := r.Table("heroes").Filter(compareFunc).UseOutdated(true).Run(session)
 func (e Exp) UseOutdated(useOutdated bool) Exp {
-	return naryOperator(useOutdatedKind, e, useOutdated)
+	return legacy(naryOperator(useOutdatedKind, e, useOutdated),
+		".UseOutdated() sets use_outdated on each table, which server 2.1 replaces with read_mode, use RunOpts.UseOutdated instead")
 }
 
-// Durability sets the durability for the expression, this can be set to either
-// "soft" or "hard".
+// UseIndex sets the index used by the .Between(), .GetAll(), .OrderBy() or
+// .Group() that directly follows it on a table.  For .Between() and .GetAll()
+// this is only used if the index name passed to them is empty, and for
+// .Group() only if no fields are given.  Ordering by an index requires server
+// >= 1.12.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  heroes := r.Table("heroes").UseIndex("name")
+//  err := heroes.Between("", "E", "F").Run(session).All(&response)
+//  err = heroes.OrderBy().Limit(10).Run(session).All(&response)
+func (e Exp) UseIndex(index string) Exp {
+	return naryOperator(useIndexKind, e, index)
+}
+
+// Durability sets the durability for the write it follows, this can be set to
+// either "soft" or "hard".  Other writes in the same query are not affected.
 //
 // Example usage:
 //
@@ -477,15 +724,45 @@ func (e Exp) Div(operand interface{}) Exp {
 	return naryOperator(divideKind, e, operand)
 }
 
-// Mod divides two numbers and returns the remainder.
+// Mod divides two integers and returns the remainder.  Like Go's % operator,
+// the remainder has the same sign as the first number, so it is negative when
+// that number is negative.
 //
 // Example usage:
 //
 //  r.Expr(23).Mod(10) => 3
+//  r.Expr(-23).Mod(10) => -3
+//  r.Expr(23).Mod(-10) => 3
 func (e Exp) Mod(operand interface{}) Exp {
 	return naryOperator(moduloKind, e, operand)
 }
 
+// IntDiv divides two integers and rounds the result down, so that it is always
+// the largest integer not greater than the exact quotient.  Unlike Go's /
+// operator, which rounds towards zero, negative quotients round away from
+// zero.
+//
+// Example usage:
+//
+//  r.Expr(7).IntDiv(2) => 3
+//  r.Expr(-7).IntDiv(2) => -4
+func (e Exp) IntDiv(operand interface{}) Exp {
+	remainder := e.Mod(operand)
+	// the server's modulo rounds towards zero, so step down when the result
+	// is negative and there is a remainder
+	quotient := e.Sub(remainder).Div(operand)
+	var negative Exp
+	switch number, ok := constantNumber(operand); {
+	case ok && number > 0:
+		negative = remainder.Lt(0)
+	case ok && number < 0:
+		negative = remainder.Gt(0)
+	default:
+		negative = remainder.Ne(0).And(remainder.Lt(0).Ne(Expr(operand).Lt(0)))
+	}
+	return Branch(negative, quotient.Sub(1), quotient)
+}
+
 // And performs a logical and on two values.
 //
 // Example usage:
@@ -596,6 +873,70 @@ func (e Exp) Count() Exp {
 	return naryOperator(countKind, e)
 }
 
+// aggregate creates an aggregation on a sequence, optionally of a field or
+// function of each element.
+func aggregate(kind expressionKind, e Exp, fieldOrFunc []interface{}) Exp {
+	switch len(fieldOrFunc) {
+	case 0:
+		return naryOperator(kind, e)
+	case 1:
+		return naryOperator(kind, e, funcWrapper(fieldOrFunc[0], 1))
+	}
+	panic(".Sum(), .Avg(), .Min() and .Max() take at most one field or function")
+}
+
+// Sum adds up the numbers in a sequence.  Given a field name, or a function of
+// each element, it adds up those values instead.
+//
+// Example usage:
+//
+//  var response int
+//  err := r.Table("heroes").Sum("strength").Run(session).One(&response)
+//
+//  // with a function
+//  err := r.Table("heroes").Sum(func(row r.Exp) r.Exp {
+//      return row.Attr("strength").Add(row.Attr("speed"))
+//  }).Run(session).One(&response)
+func (e Exp) Sum(fieldOrFunc ...interface{}) Exp {
+	return aggregate(sumKind, e, fieldOrFunc)
+}
+
+// Avg averages the numbers in a sequence, or a field or function of each
+// element, as for .Sum().  Averaging an empty sequence is an error, see
+// .Default().
+//
+// Example usage:
+//
+//  var response float64
+//  err := r.Table("heroes").Avg("strength").Run(session).One(&response)
+func (e Exp) Avg(fieldOrFunc ...interface{}) Exp {
+	return aggregate(avgKind, e, fieldOrFunc)
+}
+
+// Min returns the smallest element of a sequence.  Given a field name, or a
+// function of each element, it returns the element for which that value is
+// smallest, not the value itself.  An empty sequence is an error, see
+// .Default().
+//
+// Example usage:
+//
+//  var weakest Hero
+//  err := r.Table("heroes").Min("strength").Run(session).One(&weakest)
+func (e Exp) Min(fieldOrFunc ...interface{}) Exp {
+	return aggregate(minKind, e, fieldOrFunc)
+}
+
+// Max returns the largest element of a sequence, or the element with the
+// largest value of a field or function, as for .Min().
+//
+// Example usage:
+//
+//  var strongest Hero
+//  err := r.Table("heroes").Max("strength").Run(session).One(&strongest)
+func (e Exp) Max(fieldOrFunc ...interface{}) Exp {
+	return aggregate(maxKind, e, fieldOrFunc)
+}
+
 // Merge combines an object with another object, overwriting properties from
 // the first with properties from the second.
 //
@@ -617,6 +958,34 @@ func (e Exp) Merge(operand interface{}) Exp {
 	return naryOperator(mergeKind, e, operand)
 }
 
+// Literal marks an object so that .Merge() and .Update() replace the existing
+// value with it, instead of merging the two objects together.  Called with no
+// value, it removes the field altogether.
+//
+// Example usage:
+//
+//  var response interface{}
+//  hero := r.Map{"name": "Thor", "weapon": r.Map{"name": "Mjolnir", "weight": 42}}
+//  err := r.Expr(hero).Merge(r.Map{"weapon": r.Literal(r.Map{"name": "Jarnbjorn"})}).Run(session).One(&response)
+//
+// Example response:
+//
+//  {
+//    "name": "Thor",
+//    "weapon": {"name": "Jarnbjorn"}
+//  }
+//
+// Example usage (removing a field):
+//
+//  var response r.WriteResponse
+//  err := r.Table("heroes").Get("Thor", "name").Update(r.Map{"weapon": r.Literal()}).Run(session).One(&response)
+func Literal(value ...interface{}) Exp {
+	if len(value) == 0 {
+		return nullaryOperator(mergeLiteralKind)
+	}
+	return naryOperator(mergeLiteralKind, value[0], value[1:]...)
+}
+
 // Append appends a value to an array.
 //
 // Example usage:
@@ -755,7 +1124,7 @@ func (e Exp) Skip(start interface{}) Exp {
 //    ...
 //  ]
 func (e Exp) Map(operand interface{}) Exp {
-	return naryOperator(mapKind, e, funcWrapper(operand, 1))
+	return naryOperator(mapKind, e, requiredFuncWrapper(operand, 1))
 }
 
 // ConcatMap constructs a sequence by running the provided function on each row,
@@ -785,7 +1154,7 @@ func (e Exp) Map(operand interface{}) Exp {
 //
 //  ["Captain Britain", "Brian Braddock", "Iceman", "Robert \"Bobby\" Louis Drake", ...]
 func (e Exp) ConcatMap(operand interface{}) Exp {
-	return naryOperator(concatMapKind, e, funcWrapper(operand, 1))
+	return naryOperator(concatMapKind, e, requiredFuncWrapper(operand, 1))
 }
 
 // Filter removes all objects from a sequence that do not match the given
@@ -802,6 +1171,18 @@ func (e Exp) ConcatMap(operand interface{}) Exp {
 //
 //   err := r.Table("heroes").Filter(r.Map{"durability": 6}).Run(session).All(&response)
 //
+// Keys in an r.Map can end with a comparison operator (==, !=, <, <=, >, >=, in)
+// separated by a space, or have a map of operators as their value, to compare
+// the attribute instead of matching it exactly:
+//
+//   err := r.Table("heroes").Filter(r.Map{"durability >=": 6}).Run(session).All(&response)
+//   err := r.Table("heroes").Filter(r.Map{"durability": r.Map{">=": 4, "<": 7}}).Run(session).All(&response)
+//
+// The matchers r.Gt(), r.Gte(), r.Lt(), r.Lte(), r.Ne() and r.In() can be
+// used as values for the same effect:
+//
+//   err := r.Table("heroes").Filter(r.Map{"durability": r.Gte(6)}).Run(session).All(&response)
+//
 // Example with function:
 //
 //   filterFunc := func (row r.Exp) r.Exp { return row.Attr("durability").Eq(6) }
@@ -824,7 +1205,7 @@ func (e Exp) ConcatMap(operand interface{}) Exp {
 //    ...
 //  ]
 func (e Exp) Filter(operand interface{}) Exp {
-	return naryOperator(filterKind, e, funcWrapper(operand, 1))
+	return compileFilter(e, operand)
 }
 
 // HasFields returns true if an object has all the given attributes.
@@ -865,6 +1246,73 @@ func (e Exp) Between(index string, lowerbound, upperbound interface{}) Exp {
 	return naryOperator(betweenKind, e, lowerbound, upperbound, index)
 }
 
+// LeftBound sets whether the lower bound of the .Between() or .During() it
+// follows is included in the range, either "closed" (included) or "open"
+// (excluded).  It only applies to that term, not to any others in the query.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  // Retrieve all heroes with names after "E" but before "F"
+//  err := r.Table("heroes").Between("name", "E", "F").LeftBound("open").Run(session).All(&response)
+func (e Exp) LeftBound(bound string) Exp {
+	return naryOperator(leftBoundKind, e, bound)
+}
+
+// RightBound sets whether the upper bound of the .Between() or .During() it
+// follows is included in the range, either "closed" (included) or "open"
+// (excluded).  It only applies to that term, not to any others in the query.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  // Retrieve all heroes with names from "E" up to and including "F"
+//  err := r.Table("heroes").Between("name", "E", "F").RightBound("closed").Run(session).All(&response)
+func (e Exp) RightBound(bound string) Exp {
+	return naryOperator(rightBoundKind, e, bound)
+}
+
+// BetweenTime gets all rows where the value of the index falls in the time
+// range from `from` up to, but not including, `to`.  The times are sent to the
+// server as RethinkDB time values, so the index must contain times as well.
+// Use the zero time.Time{} to leave either end of the range unbounded.  The
+// bounds can be changed with .LeftBound() and .RightBound().
+//
+// Example usage:
+//
+//   var response []interface{}
+//   // Retrieve all battles that took place yesterday
+//   today := time.Now().Truncate(24 * time.Hour)
+//   err := r.Table("battles").BetweenTime("date", today.AddDate(0, 0, -1), today).Run(session).All(&response)
+func (e Exp) BetweenTime(index string, from, to time.Time) Exp {
+	var lowerbound, upperbound interface{}
+	if !from.IsZero() {
+		lowerbound = epochTime(from)
+	}
+	if !to.IsZero() {
+		upperbound = epochTime(to)
+	}
+	return e.Between(index, lowerbound, upperbound).LeftBound("closed").RightBound("open")
+}
+
+// WithinLast gets all rows where the value of the index is a time in the
+// last `d`, up to the current time of the clock set with SetClock() or the
+// system's clock, see .BetweenTime().  Times in the future are included too.
+//
+// Example usage:
+//
+//   var response []interface{}
+//   // Retrieve all battles in the last week
+//   err := r.Table("battles").WithinLast("date", 7*24*time.Hour).Run(session).All(&response)
+func (e Exp) WithinLast(index string, d time.Duration) Exp {
+	return e.BetweenTime(index, ClockNow().Add(-d), time.Time{})
+}
+
+// epochTime converts a time to an EPOCH_TIME term.
+func epochTime(t time.Time) Exp {
+	return EpochTime(float64(t.UnixNano()) / 1e9)
+}
+
 // OrderBy sort the sequence by the values of the given key(s) in each row. The
 // default sort is increasing.
 //
@@ -933,7 +1381,7 @@ func Desc(attr string) Exp {
 //
 //  232
 func (e Exp) Reduce(reduction, base interface{}) Exp {
-	return naryOperator(reduceKind, e, funcWrapper(reduction, 2), base)
+	return naryOperator(reduceKind, e, requiredFuncWrapper(reduction, 2), base)
 }
 
 // GroupedMapReduce partitions a sequence into groups, then performs a map and a
@@ -1000,7 +1448,8 @@ func (e Exp) Reduce(reduction, base interface{}) Exp {
 //    ...
 //  ]
 func (e Exp) GroupedMapReduce(grouping, mapping, reduction, base interface{}) Exp {
-	return naryOperator(groupedMapReduceKind, e, funcWrapper(grouping, 1), funcWrapper(mapping, 1), funcWrapper(reduction, 2), base)
+	return legacy(naryOperator(groupedMapReduceKind, e, requiredFuncWrapper(grouping, 1), requiredFuncWrapper(mapping, 1), requiredFuncWrapper(reduction, 2), base),
+		".GroupedMapReduce() was removed in server 1.12, use .Group() followed by .Map() and .Reduce() instead")
 }
 
 /////////////////////
@@ -1093,7 +1542,7 @@ func (e Exp) Without(attributes ...string) Exp {
 //    }
 //  ]
 func (leftExpr Exp) InnerJoin(rightExpr Exp, predicate interface{}) Exp {
-	return naryOperator(innerJoinKind, leftExpr, rightExpr, funcWrapper(predicate, 2))
+	return naryOperator(innerJoinKind, leftExpr, rightExpr, requiredFuncWrapper(predicate, 2))
 }
 
 // OuterJoin performs a left outer join on two sequences, using the provided
@@ -1153,7 +1602,7 @@ func (leftExpr Exp) InnerJoin(rightExpr Exp, predicate interface{}) Exp {
 //    ...
 //  ]
 func (leftExpr Exp) OuterJoin(rightExpr Exp, predicate interface{}) Exp {
-	return naryOperator(outerJoinKind, leftExpr, rightExpr, funcWrapper(predicate, 2))
+	return naryOperator(outerJoinKind, leftExpr, rightExpr, requiredFuncWrapper(predicate, 2))
 }
 
 // EqJoin performs a join on two expressions, it is more efficient than
@@ -1534,6 +1983,30 @@ func (e Exp) IndexCreate(name string, function interface{}) Exp {
 	return naryOperator(indexCreateKind, e, name, funcWrapper(function, 1))
 }
 
+// IndexSpec lets you specify the various parameters for a secondary index, then
+// create it with IndexCreateWithSpec() or EnsureIndexes().
+type IndexSpec struct {
+	Name     string
+	Function interface{} // if nil, the index is on the attribute named Name
+	Multi    bool        // index each element of an array separately
+	Geo      bool        // index geometry values
+	// KeyFunc computes the same key as Function does on the server, but in
+	// Go, so that the keys of rows can be checked without a server, see
+	// .Keys().  It is not sent to the server.
+	KeyFunc func(row map[string]interface{}) interface{}
+}
+
+// IndexCreateWithSpec creates a secondary index with the specified attributes.
+//
+// Example usage:
+//
+//  var response map[string]int
+//  spec := r.IndexSpec{Name: "powers", Multi: true}
+//  err := r.Table("heroes").IndexCreateWithSpec(spec).Run(session).One(&response)
+func (e Exp) IndexCreateWithSpec(spec IndexSpec) Exp {
+	return naryOperator(indexCreateKind, e, spec)
+}
+
 // IndexList lists all secondary indexes on a specified table.
 //
 // Example usage:
@@ -1564,6 +2037,58 @@ func (e Exp) IndexDrop(name string) Exp {
 	return naryOperator(indexDropKind, e, name)
 }
 
+// IndexStatus gets the status of the given secondary indexes, or of all of
+// them if no names are given.
+//
+// Example usage:
+//
+//  var response []interface{}
+//  err := r.Table("heroes").IndexStatus("name").Run(session).All(&response)
+//
+// Example response:
+//
+//  [
+//    {
+//      "index": "name",
+//      "ready": true
+//    }
+//  ]
+func (e Exp) IndexStatus(names ...string) Exp {
+	return naryOperator(indexStatusKind, e, stringsToInterfaces(names)...)
+}
+
+// IndexWait waits until the given secondary indexes, or all of them if no names
+// are given, have finished building.  The response is the same as for
+// .IndexStatus().
+//
+// Example usage:
+//
+//  err := r.Table("heroes").IndexWait("name").Run(session).Exec()
+func (e Exp) IndexWait(names ...string) Exp {
+	return naryOperator(indexWaitKind, e, stringsToInterfaces(names)...)
+}
+
+// Changes returns a changefeed, an endless stream of the changes made to a
+// table, each with the "old_val" and "new_val" of the row.  .Next() waits
+// until the next change arrives, use .Close() on the Rows to stop the feed.
+// The session's timeout does not apply while waiting for changes.
+//
+// A changefeed keeps reading from the session's connection, so run it on a
+// session of its own.  Requires server >= 1.16.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Changes().Run(feedSession)
+//  defer rows.Close()
+//  for rows.Next() {
+//      var change r.Change
+//      err := rows.Scan(&change)
+//      fmt.Println("hero changed from", change.OldValue, "to", change.NewValue)
+//  }
+func (e Exp) Changes() Exp {
+	return naryOperator(changesKind, e)
+}
+
 // Insert inserts rows into the database.  If no value is specified for the
 // primary key (by default "id"), a value will be generated by the server, e.g.
 // "05679c96-9a05-4f42-a2f6-a9e47c45a5ae".
@@ -1577,8 +2102,8 @@ func (e Exp) Insert(rows ...interface{}) Exp {
 	return naryOperator(insertKind, e, rows...)
 }
 
-// Overwrite tells an Insert query to overwrite existing rows instead of
-// returning an error.
+// Overwrite tells the .Insert() it follows to overwrite existing rows instead
+// of returning an error.
 //
 // Example usage:
 //
@@ -1586,13 +2111,42 @@ func (e Exp) Insert(rows ...interface{}) Exp {
 //  row := r.Map{"name": "Thing"}
 //  err := r.Table("heroes").Insert(row).Overwrite(true).Run(session).One(&response)
 func (e Exp) Overwrite(overwrite bool) Exp {
+	return legacy(e.overwrite(overwrite),
+		`.Overwrite() sets upsert, which server 2.0 replaces with conflict, use .InsertWith() with Conflict: "replace" instead`)
+}
+
+// OnConflict sets what the .Insert() it follows does with rows whose primary
+// key is already in the table: "error" (the default) fails those rows,
+// "replace" replaces the existing rows and "update" merges the new rows into
+// them, like .Update().  On server >= 2.3 it can also be a function that is
+// given the primary key, the existing row and the new row, and returns the
+// row to store.  This needs server >= 2.0, use .InsertWith() for older
+// servers.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  // set the hero's strength, creating the hero if needed
+//  row := r.Map{"id": "Thing", "strength": 9}
+//  err := r.Table("heroes").Insert(row).OnConflict("update").Run(session).One(&response)
+//
+//  // keep the highest score
+//  err := r.Table("scores").Insert(score).OnConflict(func(id, old, new r.Exp) r.Exp {
+//      return r.Branch(new.Attr("score").Gt(old.Attr("score")), new, old)
+//  }).Run(session).Exec()
+func (e Exp) OnConflict(conflict interface{}) Exp {
+	return naryOperator(conflictKind, e, conflict)
+}
+
+func (e Exp) overwrite(overwrite bool) Exp {
 	return naryOperator(upsertKind, e, overwrite)
 }
 
 // Atomic changes the required atomic-ness of a query.  By default queries will
 // only be run if they can be executed atomically, that is, all at once.  If a
 // query may not be executed atomically, the server will return an error.  To
-// disable the atomic requirement, use .Atomic(false).
+// disable the atomic requirement, use .Atomic(false) directly after the
+// .Update() or .Replace() it should apply to.
 //
 // Example usage:
 //
@@ -1620,9 +2174,29 @@ func (e Exp) Atomic(atomic bool) Exp {
 //  // Update all rows in the database
 //  err := r.Table("heroes").Update(replacement).Run(session).One(&response)
 func (e Exp) Update(mapping interface{}) Exp {
+	if mapping == nil {
+		return naryOperator(updateKind, e, nil)
+	}
 	return naryOperator(updateKind, e, funcWrapper(mapping, 1))
 }
 
+// UpsertByIndex updates the rows of a table where a secondary index matches
+// `key`, or inserts `doc` if there are none, in a single query.  Write options
+// such as .Durability() and .ReturnValues() can follow it and apply to
+// whichever write is performed.
+//
+// The check and the write are not atomic, so two of these running at the
+// same time for a new key can both insert.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  hero := r.Map{"name": "Storm", "strength": 6}
+//  err := r.Table("heroes").UpsertByIndex("name", "Storm", hero).Run(session).One(&response)
+func (e Exp) UpsertByIndex(index string, key interface{}, doc interface{}) Exp {
+	return naryOperator(upsertByIndexKind, e, index, key, doc)
+}
+
 // Replace replaces rows in the database. Accepts a JSON document or a RQL
 // expression, and replaces the original document with the new one. The new
 // row must have the same primary key as the original document.
@@ -1639,6 +2213,10 @@ func (e Exp) Update(mapping interface{}) Exp {
 //  // Replace all rows in a table
 //  err := r.Table("heroes").Replace(replacement).Run(session).One(&response)
 func (e Exp) Replace(mapping interface{}) Exp {
+	if mapping == nil {
+		// deletes the rows
+		return naryOperator(replaceKind, e, nil)
+	}
 	return naryOperator(replaceKind, e, funcWrapper(mapping, 1))
 }
 
@@ -1681,7 +2259,7 @@ func (e Exp) Delete() Exp {
 //    "deleted": 2
 //  }
 func (e Exp) ForEach(queryFunc interface{}) Exp {
-	return naryOperator(forEachKind, e, funcWrapper(queryFunc, 1))
+	return naryOperator(forEachKind, e, requiredFuncWrapper(queryFunc, 1))
 }
 
 // Do evalutes the last argument (a function) using all previous arguments as the arguments to the function.
@@ -1702,7 +2280,7 @@ func Do(operands ...interface{}) Exp {
 	// last argument is a function
 	f := operands[len(operands)-1]
 	operands = operands[:len(operands)-1]
-	return naryOperator(funcallKind, funcWrapper(f, -1), operands...)
+	return naryOperator(funcallKind, requiredFuncWrapper(f, -1), operands...)
 }
 
 // TypeOf returns the type of the expression.
@@ -1908,6 +2486,37 @@ func (e Exp) IsEmpty() Exp {
 	return naryOperator(isEmptyKind, e)
 }
 
+// Exists returns true if the sequence has at least one element.  Only the
+// first element is read, so this is cheaper than fetching the documents to
+// check for them.
+//
+// Example usage:
+//
+//  var response bool
+//  err := r.Table("heroes").Filter(r.Map{"name": "Storm"}).Exists().Run(session).One(&response)
+//
+// Example response:
+//
+//  true
+func (e Exp) Exists() Exp {
+	return e.Limit(1).Count().Gt(0)
+}
+
+// ExistsByIndex returns true if the table has a document with the given value
+// for a secondary index.
+//
+// Example usage:
+//
+//  var response bool
+//  err := r.Table("heroes").ExistsByIndex("name", "Storm").Run(session).One(&response)
+//
+// Example response:
+//
+//  true
+func (e Exp) ExistsByIndex(index string, key interface{}) Exp {
+	return e.GetAll(index, key).Exists()
+}
+
 // SetInsert adds a value to an array and returns the unique values of the resulting array.
 //
 // Example usage:
@@ -2026,6 +2635,7 @@ func (e Exp) Default(value interface{}) Exp {
 }
 
 // ReturnValues tells the server, when performing a single row insert/update/delete/upsert, to return the new and old values on single row
+// Like .Durability(), it only applies to the write it follows.
 //
 // Example usage:
 //
@@ -2034,5 +2644,28 @@ func (e Exp) Default(value interface{}) Exp {
 // Example response:
 //
 func (e Exp) ReturnValues() Exp {
+	return legacy(e.returnValues(),
+		".ReturnValues() sets return_vals, which server 1.16 replaces with return_changes, use .ReturnChanges() instead")
+}
+
+func (e Exp) returnValues() Exp {
 	return naryOperator(returnValuesKind, e)
 }
+
+// ReturnChanges tells the server to return the old and new values of every
+// row changed by the write it follows, in WriteResponse.Changes.  Like
+// .Durability(), it only applies to that write.  This needs server >= 1.16,
+// older servers only support .ReturnValues() for single rows.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  err := r.Table("heroes").Filter(r.Map{"team": "X-Men"}).Update(r.Map{"team": "X-Force"}).ReturnChanges().Run(session).One(&response)
+//  for _, change := range response.Changes {
+//      var before, after Hero
+//      err := change.Unmarshal(&before, &after)
+//      ...
+//  }
+func (e Exp) ReturnChanges() Exp {
+	return naryOperator(returnChangesKind, e)
+}
@@ -0,0 +1,195 @@
+package rethinkgo
+
+// Make sure that only one reconnect happens at a time, and back off between
+// failed reconnects, so that clients do not pile onto a recovering server.
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReconnectMinBackoff = 100 * time.Millisecond
+	defaultReconnectMaxBackoff = 10 * time.Second
+)
+
+// reconnectState is shared by everything that reconnects a session.
+type reconnectState struct {
+	mu sync.Mutex
+	// reconnect in progress, or nil
+	call *reconnectCall
+	// number of reconnects in a row that have failed
+	failures int
+	// number of successful reconnects, so that a caller whose query failed on
+	// an old connection can tell that it has already been replaced
+	generation int
+	// range of the delay before retrying after a failed reconnect, zero means
+	// the defaults
+	minBackoff, maxBackoff time.Duration
+	hook                   func(ReconnectEvent)
+}
+
+// reconnectCall is a single reconnect, which callers that arrive while it is
+// in progress wait for instead of starting their own.
+type reconnectCall struct {
+	done   chan struct{}
+	err    error
+	joined int
+}
+
+// ReconnectEvent describes a finished reconnect, see SetReconnectHook().
+type ReconnectEvent struct {
+	// Number of reconnects in a row that have failed, including this one, or
+	// zero if it succeeded.
+	Failures int
+	// How long the reconnect waited before dialing the server.
+	Wait time.Duration
+	// Number of other callers of .Reconnect() that waited for this one
+	// instead of reconnecting themselves.
+	Joined int
+	// Error from the reconnect, if any.
+	Err error
+}
+
+// Reconnect closes and re-opens a session.
+//
+// Only one reconnect happens at a time, callers that arrive while a reconnect
+// is in progress wait for it and get the same result.  After a reconnect
+// fails, the next one waits for a random, growing delay first, see
+// SetReconnectBackoff().
+//
+// This does not make it safe to run queries on the session from several
+// goroutines, see Connect().
+//
+// Example usage:
+//
+//  err := sess.Reconnect()
+func (s *Session) Reconnect() error {
+	return s.reconnectFrom(s.connGeneration())
+}
+
+// connGeneration returns the number of the current connection, to be passed
+// to .reconnectFrom() if it fails.
+func (s *Session) connGeneration() int {
+	s.reconnect.mu.Lock()
+	defer s.reconnect.mu.Unlock()
+	return s.reconnect.generation
+}
+
+// reconnectFrom is like .Reconnect(), but does nothing if the connection
+// numbered `generation` has already been replaced.
+func (s *Session) reconnectFrom(generation int) error {
+	r := &s.reconnect
+	r.mu.Lock()
+	if call := r.call; call != nil {
+		call.joined++
+		r.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	if r.generation != generation {
+		r.mu.Unlock()
+		return nil
+	}
+	call := &reconnectCall{done: make(chan struct{})}
+	r.call = call
+	wait := r.backoff()
+	r.mu.Unlock()
+
+	time.Sleep(wait)
+	call.err = s.redial()
+
+	r.mu.Lock()
+	if call.err == nil {
+		r.failures = 0
+		r.generation++
+	} else {
+		r.failures++
+	}
+	event := ReconnectEvent{Failures: r.failures, Wait: wait, Joined: call.joined, Err: call.err}
+	hook := r.hook
+	r.call = nil
+	r.mu.Unlock()
+	close(call.done)
+
+	if hook != nil {
+		hook(event)
+	}
+	return call.err
+}
+
+// redial does the actual work of .Reconnect().
+func (s *Session) redial() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.closed = false
+	s.conn.flushDelay = s.writeDelay
+
+	if s.configuredVersion.known() {
+		s.version = s.configuredVersion
+		return nil
+	}
+	s.version, err = s.conn.probeServerVersion(s.getToken(), s.timeout)
+	return err
+}
+
+// backoff returns how long to wait before the next reconnect, which doubles
+// with each failure and is jittered so that clients spread out their retries.
+func (r *reconnectState) backoff() time.Duration {
+	if r.failures == 0 {
+		return 0
+	}
+	min, max := r.minBackoff, r.maxBackoff
+	if min <= 0 {
+		min = defaultReconnectMinBackoff
+	}
+	if max <= 0 {
+		max = defaultReconnectMaxBackoff
+	}
+
+	delay := min
+	for i := 1; i < r.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	// pick a delay between half and all of the backoff
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// SetReconnectBackoff sets the range of delays used between failed reconnects.
+// The first retry waits around `min`, and each failure after that doubles the
+// delay, up to `max`.
+//
+// Example usage:
+//
+//  sess.SetReconnectBackoff(50*time.Millisecond, 5*time.Second)
+func (s *Session) SetReconnectBackoff(min, max time.Duration) {
+	s.reconnect.mu.Lock()
+	defer s.reconnect.mu.Unlock()
+	s.reconnect.minBackoff = min
+	s.reconnect.maxBackoff = max
+}
+
+// SetReconnectHook sets a function that is called after each reconnect, for
+// logging or metrics.  Set to nil to disable.
+//
+// Example usage:
+//
+//  sess.SetReconnectHook(func(event r.ReconnectEvent) {
+//      log.Printf("reconnect: %+v", event)
+//  })
+func (s *Session) SetReconnectHook(hook func(ReconnectEvent)) {
+	s.reconnect.mu.Lock()
+	defer s.reconnect.mu.Unlock()
+	s.reconnect.hook = hook
+}
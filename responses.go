@@ -1,5 +1,9 @@
 package rethinkgo
 
+import (
+	"encoding/json"
+)
+
 // WriteResponse is a type that can be used to read responses to write queries, such as .Insert()
 //
 // Example usage:
@@ -14,8 +18,136 @@ type WriteResponse struct {
 	Unchanged     int
 	Replaced      int
 	Deleted       int
+	Skipped       int
 	GeneratedKeys []string    `json:"generated_keys"`
 	FirstError    string      `json:"first_error"` // populated if Errors > 0
 	NewValue      interface{} `json:"new_val"`
 	OldValue      interface{} `json:"old_val"`
+	Changes       []RawChange `json:"changes"` // populated by .ReturnChanges()
+}
+
+// RawChange is the old and new values of a row changed by a write, as
+// returned by .ReturnChanges(), left as JSON so that they can be decoded into
+// the row's type.  OldValue is null for inserted rows and NewValue is null for
+// deleted rows.
+type RawChange struct {
+	OldValue json.RawMessage `json:"old_val"`
+	NewValue json.RawMessage `json:"new_val"`
+}
+
+// Unmarshal decodes the old and new values of the row into `oldValue` and
+// `newValue`, either of which can be nil to skip it.  A value that is null
+// leaves its destination unchanged.
+//
+// Example usage:
+//
+//  var before, after Hero
+//  err := response.Changes[0].Unmarshal(&before, &after)
+func (c RawChange) Unmarshal(oldValue, newValue interface{}) error {
+	for _, value := range []struct {
+		data json.RawMessage
+		dest interface{}
+	}{{c.OldValue, oldValue}, {c.NewValue, newValue}} {
+		if value.dest == nil || len(value.data) == 0 || isJsonNull(value.data) {
+			continue
+		}
+		if err := transformedDecode(value.data, value.dest); err != nil {
+			return newDecodeError(value.dest, value.data, err)
+		}
+	}
+	return nil
+}
+
+// RunWrite runs a write query and returns the server's response.  If the query
+// ran but some documents could not be written, the response is returned along
+// with a WriteError, see WriteResponse.Err().
+//
+// Example usage:
+//
+//  response, err := r.Table("heroes").Insert(r.Map{"name": "Professor X"}).RunWrite(session)
+//  heroId := response.GeneratedKeys[0]
+func (e Exp) RunWrite(session *Session) (WriteResponse, error) {
+	var response WriteResponse
+	if err := e.Run(session).One(&response); err != nil {
+		return response, err
+	}
+	return response, response.Err()
+}
+
+// Err returns a WriteError if any of the documents in the write could not be
+// written, or nil if they all succeeded.  The server only reports the first
+// error, so there's no way to tell which other documents failed.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  err := r.Table("heroes").Insert(heroes).Run(session).One(&response)
+//  if err == nil {
+//      err = response.Err()
+//  }
+func (wr WriteResponse) Err() error {
+	if wr.Errors == 0 {
+		return nil
+	}
+	return WriteError{FirstError: wr.FirstError, Errors: wr.Errors}
+}
+
+// add adds the counts from another response to this one, for queries that are
+// split into several writes.
+func (wr *WriteResponse) add(other WriteResponse) {
+	wr.Inserted += other.Inserted
+	wr.Errors += other.Errors
+	wr.Updated += other.Updated
+	wr.Unchanged += other.Unchanged
+	wr.Replaced += other.Replaced
+	wr.Deleted += other.Deleted
+	wr.Skipped += other.Skipped
+	wr.GeneratedKeys = append(wr.GeneratedKeys, other.GeneratedKeys...)
+	wr.Changes = append(wr.Changes, other.Changes...)
+	if wr.FirstError == "" {
+		wr.FirstError = other.FirstError
+	}
+}
+
+// GroupResult is one group of the result of .Group().  The reduction is kept as
+// JSON so that it can be decoded into a different type for each query, with
+// .Decode().
+//
+// Example usage:
+//
+//  var groups []r.GroupResult
+//  err := r.Table("heroes").Group("affiliation").Max("speed").Run(session).All(&groups)
+//  for _, group := range groups {
+//      var fastest Hero
+//      err = group.Decode(&fastest)
+//  }
+type GroupResult struct {
+	Group     interface{}     `json:"group"`
+	Reduction json.RawMessage `json:"reduction"`
+}
+
+// Decode decodes the reduction of the group into `dest`, as .Scan() would.
+func (g GroupResult) Decode(dest interface{}) error {
+	if err := transformedDecode(g.Reduction, dest); err != nil {
+		return newDecodeError(dest, g.Reduction, err)
+	}
+	return nil
+}
+
+// TableInfo is the response to .Info() on a table, see Exp.TableInfo().
+type TableInfo struct {
+	Type              string       `json:"type"` // always "TABLE"
+	Name              string       `json:"name"`
+	Id                string       `json:"id"` // only returned by newer servers
+	Db                DatabaseInfo `json:"db"`
+	PrimaryKey        string       `json:"primary_key"`
+	Indexes           []string     `json:"indexes"`
+	DocCountEstimates []int        `json:"doc_count_estimates"` // one per shard, only returned by newer servers
+}
+
+// DatabaseInfo is the response to .Info() on a database.
+type DatabaseInfo struct {
+	Type string `json:"type"` // always "DB"
+	Name string `json:"name"`
+	Id   string `json:"id"` // only returned by newer servers
 }
@@ -0,0 +1,61 @@
+// Command rethinkgen generates typed query builders from a schema, see the
+// rethinkgen package for the schema format and the generated code.
+//
+// Example usage:
+//
+//  //go:generate rethinkgen -schema schema.json -package models -o tables.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/christopherhesse/rethinkgo/rethinkgen"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rethinkgen: ")
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run generates the code as described by the command line arguments, writing
+// it to stdout unless -o is given.
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("rethinkgen", flag.ContinueOnError)
+	schemaPath := flags.String("schema", "schema.json", "JSON file describing the tables")
+	packageName := flags.String("package", os.Getenv("GOPACKAGE"), "package of the generated code")
+	output := flags.String("o", "", "file to write the generated code to, instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *packageName == "" {
+		return errors.New("-package is required outside of go generate")
+	}
+
+	file, err := os.Open(*schemaPath)
+	if err != nil {
+		return err
+	}
+	schema, err := rethinkgen.ReadSchema(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	source, err := rethinkgen.Generate(schema, *packageName)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err := stdout.Write(source)
+		return err
+	}
+	return ioutil.WriteFile(*output, source, 0644)
+}
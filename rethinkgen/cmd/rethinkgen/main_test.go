@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	test "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { test.TestingT(t) }
+
+type CommandSuite struct{}
+
+var _ = test.Suite(&CommandSuite{})
+
+func writeSchema(c *test.C, schema string) string {
+	path := filepath.Join(c.MkDir(), "schema.json")
+	c.Assert(ioutil.WriteFile(path, []byte(schema), 0644), test.IsNil)
+	return path
+}
+
+func (s *CommandSuite) TestStdout(c *test.C) {
+	schema := writeSchema(c, `{"tables": [{"name": "heroes", "fields": ["name"]}]}`)
+	var stdout bytes.Buffer
+	err := run([]string{"-schema", schema, "-package", "models"}, &stdout)
+	c.Assert(err, test.IsNil)
+	c.Assert(stdout.String(), test.Matches, `(?s)// Code generated by rethinkgen.*package models.*var Heroes = HeroesTable.*`)
+}
+
+func (s *CommandSuite) TestOutputFile(c *test.C) {
+	schema := writeSchema(c, `{"tables": [{"name": "heroes"}]}`)
+	output := filepath.Join(c.MkDir(), "tables.go")
+	var stdout bytes.Buffer
+	err := run([]string{"-schema", schema, "-package", "models", "-o", output}, &stdout)
+	c.Assert(err, test.IsNil)
+	c.Assert(stdout.Len(), test.Equals, 0)
+
+	source, err := ioutil.ReadFile(output)
+	c.Assert(err, test.IsNil)
+	c.Assert(string(source), test.Matches, `(?s).*package models.*`)
+}
+
+func (s *CommandSuite) TestErrors(c *test.C) {
+	os.Setenv("GOPACKAGE", "")
+	var stdout bytes.Buffer
+	err := run([]string{"-schema", writeSchema(c, `{"tables": []}`)}, &stdout)
+	c.Assert(err, test.ErrorMatches, "-package is required outside of go generate")
+
+	err = run([]string{"-schema", filepath.Join(c.MkDir(), "missing.json"), "-package", "models"}, &stdout)
+	c.Assert(err, test.NotNil)
+
+	schema := writeSchema(c, `{"tables": [{"name": "heroes"}, {"name": "heroes_fields"}]}`)
+	err = run([]string{"-schema", schema, "-package", "models"}, &stdout)
+	c.Assert(err, test.ErrorMatches, ".*both need the Go name HeroesFields")
+}
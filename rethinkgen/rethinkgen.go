@@ -0,0 +1,242 @@
+// Package rethinkgen generates typed query builders for the rethinkgo driver
+// from a schema, so that table, field and index names are checked by the
+// compiler instead of being repeated as strings across a codebase.
+//
+// The schema is JSON listing the tables:
+//
+//  {
+//    "tables": [
+//      {
+//        "name": "heroes",
+//        "db": "marvel",
+//        "primary_key": "id",
+//        "fields": ["name", "real_name", "strength"],
+//        "indexes": ["name", "strength"]
+//      }
+//    ]
+//  }
+//
+// For that schema, the generated code can be used like:
+//
+//  rows := models.Heroes.ByNameIndex().Between("A", "F").Run(session)
+//  rows = models.Heroes.Filter(r.Map{models.HeroesFields.Strength: 10}).Run(session)
+//
+// Example usage:
+//
+//  //go:generate rethinkgen -schema schema.json -package models -o tables.go
+package rethinkgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"text/template"
+	"unicode"
+)
+
+// Schema describes the tables to generate builders for.
+type Schema struct {
+	Tables []Table `json:"tables"`
+}
+
+// Table describes a single table.
+type Table struct {
+	// Name of the table.
+	Name string `json:"name"`
+	// Database the table is in, or empty to use the session's database.
+	Db string `json:"db"`
+	// Primary key of the table, defaults to "id".
+	PrimaryKey string `json:"primary_key"`
+	// Fields of the rows in the table.
+	Fields []string `json:"fields"`
+	// Secondary indexes on the table.
+	Indexes []string `json:"indexes"`
+}
+
+// ReadSchema parses a JSON schema.
+func ReadSchema(reader io.Reader) (Schema, error) {
+	var schema Schema
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schema); err != nil {
+		return schema, fmt.Errorf("rethinkgen: Could not parse schema: %v", err)
+	}
+	return schema, nil
+}
+
+// Generate returns the Go source for the builders of a schema, in the given
+// package.
+func Generate(schema Schema, packageName string) ([]byte, error) {
+	var tables []tableData
+	seen := map[string]string{}
+	for _, table := range schema.Tables {
+		data, err := newTableData(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range data.identifiers() {
+			if other, ok := seen[name]; ok {
+				return nil, fmt.Errorf("rethinkgen: Tables %q and %q both need the Go name %v", other, table.Name, name)
+			}
+			seen[name] = table.Name
+		}
+		tables = append(tables, data)
+	}
+
+	var buffer bytes.Buffer
+	err := fileTemplate.Execute(&buffer, map[string]interface{}{"Package": packageName, "Tables": tables})
+	if err != nil {
+		return nil, err
+	}
+	source, err := format.Source(buffer.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("rethinkgen: Generated invalid code: %v", err)
+	}
+	return source, nil
+}
+
+// tableData is what the template needs to know about a table.
+type tableData struct {
+	Table
+	// exported Go name, e.g. Heroes
+	Type string
+	// Go names of the fields and indexes, keyed by their names in the
+	// database
+	Fields  []nameData
+	Indexes []nameData
+}
+
+// identifiers returns the top-level Go names declared for the table, which
+// must not clash with those of other tables.
+func (data tableData) identifiers() []string {
+	return []string{data.Type, data.Type + "Table", data.Type + "Fields", data.Type + "Index"}
+}
+
+type nameData struct {
+	Name   string
+	GoName string
+}
+
+func newTableData(table Table) (tableData, error) {
+	if table.Name == "" {
+		return tableData{}, fmt.Errorf("rethinkgen: Table with no name")
+	}
+	if table.PrimaryKey == "" {
+		table.PrimaryKey = "id"
+	}
+	data := tableData{Table: table, Type: goName(table.Name)}
+
+	var err error
+	if data.Fields, err = goNames(table.Name, "field", table.Fields); err != nil {
+		return data, err
+	}
+	if data.Indexes, err = goNames(table.Name, "index", table.Indexes); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// goNames converts a list of names, making sure no two end up the same.
+func goNames(table, what string, names []string) ([]nameData, error) {
+	var result []nameData
+	seen := map[string]string{}
+	for _, name := range names {
+		n := nameData{Name: name, GoName: goName(name)}
+		if other, ok := seen[n.GoName]; ok {
+			return nil, fmt.Errorf("rethinkgen: Table %q has %vs %q and %q with the same Go name %v", table, what, other, name, n.GoName)
+		}
+		seen[n.GoName] = name
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// goName converts a database name such as "real_name" to an exported Go name
+// such as RealName.
+func goName(name string) string {
+	var result []rune
+	upper := true
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		result = append(result, c)
+	}
+	if len(result) == 0 || unicode.IsDigit(result[0]) {
+		result = append([]rune("X"), result...)
+	}
+	return string(result)
+}
+
+var fileTemplate = template.Must(template.New("file").Funcs(template.FuncMap{
+	"quote": func(s string) string { return fmt.Sprintf("%q", s) },
+}).Parse(`// Code generated by rethinkgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	r "github.com/christopherhesse/rethinkgo"
+)
+{{range .Tables}}{{$table := .}}
+// {{.Type}}Table is the {{quote .Name}} table, it can be used anywhere an r.Exp
+// for the table can.
+type {{.Type}}Table struct {
+	r.Exp
+}
+
+// {{.Type}} is the {{quote .Name}} table.
+var {{.Type}} = {{.Type}}Table{ {{if .Db}}r.Db({{quote .Db}}).Table({{quote .Name}}){{else}}r.Table({{quote .Name}}){{end}} }
+
+// {{.Type}}Fields holds the names of the fields of rows in the {{quote .Name}} table.
+var {{.Type}}Fields = struct {
+	{{range .Fields}}{{.GoName}} string
+	{{end}}
+}{
+	{{range .Fields}}{{.GoName}}: {{quote .Name}},
+	{{end}}
+}
+
+// {{.Type}}Index is an index on the {{quote .Name}} table.
+type {{.Type}}Index struct {
+	table r.Exp
+	name  string
+}
+
+// ByPrimaryKey is the primary key index, {{quote .PrimaryKey}}.
+func (t {{.Type}}Table) ByPrimaryKey() {{.Type}}Index {
+	return {{.Type}}Index{t.Exp, {{quote .PrimaryKey}}}
+}
+{{range .Indexes}}
+// By{{.GoName}}Index is the {{quote .Name}} index.
+func (t {{$table.Type}}Table) By{{.GoName}}Index() {{$table.Type}}Index {
+	return {{$table.Type}}Index{t.Exp, {{quote .Name}}}
+}
+{{end}}
+// Name returns the name of the index.
+func (i {{.Type}}Index) Name() string {
+	return i.name
+}
+
+// GetAll gets the rows whose index value is one of the given values.
+func (i {{.Type}}Index) GetAll(values ...interface{}) r.Exp {
+	return i.table.GetAll(i.name, values...)
+}
+
+// Between gets the rows whose index value is from lowerbound up to, but not
+// including, upperbound.
+func (i {{.Type}}Index) Between(lowerbound, upperbound interface{}) r.Exp {
+	return i.table.Between(i.name, lowerbound, upperbound)
+}
+
+// OrderBy sorts the table by the index.
+func (i {{.Type}}Index) OrderBy() r.Exp {
+	return i.table.UseIndex(i.name).OrderBy()
+}
+{{end}}`))
@@ -0,0 +1,126 @@
+package rethinkgen
+
+import (
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	test "launchpad.net/gocheck"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { test.TestingT(t) }
+
+type GenerateSuite struct{}
+
+var _ = test.Suite(&GenerateSuite{})
+
+const heroesSchema = `{
+  "tables": [
+    {
+      "name": "heroes",
+      "db": "marvel",
+      "fields": ["name", "real_name", "strength"],
+      "indexes": ["name", "strength"]
+    },
+    {
+      "name": "villains",
+      "primary_key": "name",
+      "fields": ["name", "lair"]
+    }
+  ]
+}`
+
+// typeCheck compiles generated code against the driver.
+func typeCheck(c *test.C, source []byte) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "tables.go", source, 0)
+	c.Assert(err, test.IsNil)
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	_, err = config.Check("models", fset, []*ast.File{file}, nil)
+	c.Assert(err, test.IsNil)
+}
+
+func (s *GenerateSuite) TestGolden(c *test.C) {
+	schema, err := ReadSchema(strings.NewReader(heroesSchema))
+	c.Assert(err, test.IsNil)
+	source, err := Generate(schema, "models")
+	c.Assert(err, test.IsNil)
+
+	golden := filepath.Join("testdata", "tables.golden")
+	if *update {
+		c.Assert(ioutil.WriteFile(golden, source, 0644), test.IsNil)
+	}
+	expected, err := ioutil.ReadFile(golden)
+	c.Assert(err, test.IsNil)
+	c.Assert(string(source), test.Equals, string(expected))
+
+	typeCheck(c, source)
+}
+
+func (s *GenerateSuite) TestReadSchema(c *test.C) {
+	_, err := ReadSchema(strings.NewReader(`{"tables": [{"name": "heroes", "colour": "red"}]}`))
+	c.Assert(err, test.ErrorMatches, `rethinkgen: Could not parse schema: .*colour.*`)
+}
+
+func (s *GenerateSuite) TestNameCollisions(c *test.C) {
+	tests := []struct {
+		schema Schema
+		err    string
+	}{
+		{
+			Schema{Tables: []Table{{Name: "heroes"}, {Name: "Heroes"}}},
+			`rethinkgen: Tables "heroes" and "Heroes" both need the Go name Heroes`,
+		},
+		{
+			Schema{Tables: []Table{{Name: "heroes"}, {Name: "heroes_fields"}}},
+			`rethinkgen: Tables "heroes" and "heroes_fields" both need the Go name HeroesFields`,
+		},
+		{
+			Schema{Tables: []Table{{Name: "heroes_table"}, {Name: "heroes"}}},
+			`rethinkgen: Tables "heroes_table" and "heroes" both need the Go name HeroesTable`,
+		},
+		{
+			Schema{Tables: []Table{{Name: "heroes", Fields: []string{"real_name", "real-name"}}}},
+			`rethinkgen: Table "heroes" has fields "real_name" and "real-name" with the same Go name RealName`,
+		},
+		{
+			Schema{Tables: []Table{{Name: ""}}},
+			`rethinkgen: Table with no name`,
+		},
+	}
+	for _, t := range tests {
+		_, err := Generate(t.schema, "models")
+		c.Assert(err, test.ErrorMatches, t.err)
+	}
+}
+
+func (s *GenerateSuite) TestGoName(c *test.C) {
+	names := map[string]string{
+		"real_name": "RealName",
+		"heroes":    "Heroes",
+		"x-men 2":   "XMen2",
+		"2fast":     "X2fast",
+		"_":         "X",
+	}
+	for name, expected := range names {
+		c.Assert(goName(name), test.Equals, expected)
+	}
+}
+
+func (s *GenerateSuite) TestSimilarNames(c *test.C) {
+	// close to the collisions above, but allowed
+	schema, err := ReadSchema(strings.NewReader(`{"tables": [{"name": "heroes"}, {"name": "hero"}, {"name": "heroes_idx"}]}`))
+	c.Assert(err, test.IsNil)
+	source, err := Generate(schema, "models")
+	c.Assert(err, test.IsNil)
+	typeCheck(c, source)
+}
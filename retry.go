@@ -0,0 +1,80 @@
+package rethinkgo
+
+// Reconnect and run a query again when the connection to the server fails.
+
+import (
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"io"
+	"net"
+)
+
+// SetMaxRetries makes the session reconnect and run a query again when it
+// fails because of a network error, such as the server closing the
+// connection, up to `retries` times.  Reconnects after the first back off,
+// see SetReconnectBackoff().  Set to zero to disable, which is the default.
+//
+// Queries that write, or change tables, databases or indexes, are never run
+// again, since the server may have applied them before the connection
+// failed.  Neither are timed out queries, which may still be running, or
+// fetches of more rows for a query that is already running, which only exist
+// on the old connection.
+//
+// Example usage:
+//
+//  sess.SetMaxRetries(3)
+func (s *Session) SetMaxRetries(retries int) {
+	s.maxRetries = retries
+}
+
+// sideEffectTerms are the terms that change something on the server.
+var sideEffectTerms = []p.Term_TermType{
+	p.Term_INSERT,
+	p.Term_UPDATE,
+	p.Term_REPLACE,
+	p.Term_DELETE,
+	p.Term_FOREACH,
+	p.Term_DB_CREATE,
+	p.Term_DB_DROP,
+	p.Term_TABLE_CREATE,
+	p.Term_TABLE_DROP,
+	p.Term_INDEX_CREATE,
+	p.Term_INDEX_DROP,
+}
+
+// canRetry says whether a query can safely be run again.
+func canRetry(queryProto *p.Query) bool {
+	for _, termType := range sideEffectTerms {
+		if containsTermType(queryProto.GetQuery(), termType) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNetworkError says whether an error means the connection to the server
+// failed, as opposed to the query failing or timing out.
+func isNetworkError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	netErr, ok := err.(net.Error)
+	return ok && !netErr.Timeout()
+}
+
+// executeWithRetry runs a query, reconnecting and running it again after a
+// network error if the session allows it.  It returns the number of times
+// the query was sent.
+func (s *Session) executeWithRetry(queryProto *p.Query) (response *p.Response, attempts int, err error) {
+	for attempts = 1; ; attempts++ {
+		generation := s.connGeneration()
+		response, err = s.conn.sendQuery(queryProto, s.timeout)
+		if err == nil || attempts > s.maxRetries || !isNetworkError(err) || !canRetry(queryProto) {
+			return
+		}
+		// if the connection was replaced while the query was running, it is
+		// run again on the new one without reconnecting.  if the reconnect
+		// fails, running the query on the closed connection fails as well,
+		// and the next attempt reconnects again
+		s.reconnectFrom(generation)
+	}
+}
@@ -2,10 +2,14 @@ package rethinkgo
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	p "github.com/christopherhesse/rethinkgo/ql2"
+	"sort"
+	"sync"
+	"time"
 )
 
 // Rows is an iterator to move through the rows returned by the database, call
@@ -36,6 +40,10 @@ import (
 // All three of these methods will return errors if used on a query response
 // that does not match the expected type (ErrWrongResponseType).
 type Rows struct {
+	// guards the state shared with .Close(), which may be called from another
+	// goroutine, it is not held while waiting for the server
+	mu sync.Mutex
+
 	session      *Session
 	closed       bool
 	buffer       []*p.Datum
@@ -44,6 +52,26 @@ type Rows struct {
 	lasterr      error
 	token        int64
 	responseType p.Response_ResponseType
+	// number of rows returned by Next() so far
+	count int
+	// when set, the rows are read from these instead of from the server, see
+	// MergeCursors()
+	sources []*mergeSource
+	// key to order the sources by, or nil to read them one after another
+	mergeKey func(*Rows) interface{}
+	// which pseudo-types to leave as they are when decoding
+	format pseudoTypeFormat
+	// the query is a changefeed, which never completes on its own
+	feed bool
+	// largest number of rows .All() reads, or zero
+	maxRows int
+	// profile returned with the first response, see Profile()
+	profile *p.Datum
+	// row read ahead by Peek(), returned by the next call to Next()
+	peeked    *p.Datum
+	hasPeeked bool
+	// when set, the rows are read from this file, see SpillToDisk()
+	spill *spillFile
 }
 
 // continueQuery creates a query that will cause this query to continue
@@ -52,9 +80,18 @@ func (rows *Rows) continueQuery() error {
 		Type:  p.Query_CONTINUE.Enum(),
 		Token: proto.Int64(rows.token),
 	}
-	buffer, responseType, err := rows.session.conn.executeQuery(queryProto, rows.session.timeout)
+	start := time.Now()
+	timeout := rows.session.timeout
+	if rows.feed {
+		// waiting for the next change can take any amount of time
+		timeout = 0
+	}
+	rows.mu.Unlock()
+	buffer, responseType, err := rows.session.conn.executeQuery(queryProto, timeout)
+	rows.mu.Lock()
 	if err != nil {
-		return err
+		rows.session.checkTimeout(err)
+		return withErrorInfo(err, rows.session.conn.errorInfo(rows.token, start))
 	}
 
 	switch responseType {
@@ -87,6 +124,17 @@ func (rows *Rows) continueQuery() error {
 //      ...
 //  }
 func (rows *Rows) Next() bool {
+	rows.mu.Lock()
+	defer rows.mu.Unlock()
+
+	if rows.hasPeeked {
+		rows.current = rows.peeked
+		rows.peeked = nil
+		rows.hasPeeked = false
+		rows.count++
+		return true
+	}
+
 	if rows.closed {
 		return false
 	}
@@ -95,27 +143,247 @@ func (rows *Rows) Next() bool {
 		return false
 	}
 
-	if len(rows.buffer) == 0 {
+	if rows.spill != nil {
+		return rows.nextFromSpill()
+	}
+
+	if rows.sources != nil {
+		return rows.nextFromSources()
+	}
+
+	for len(rows.buffer) == 0 {
 		// we're out of results, may need to fetch some more
 		if rows.complete {
 			rows.closed = true
 			return false
-		} else {
-			// more rows to get, fetch 'em
-			err := rows.continueQuery()
-			if err != nil {
-				rows.lasterr = err
-				return false
+		}
+		// more rows to get, fetch 'em, a batch may be empty if the server had
+		// nothing new to send
+		err := rows.continueQuery()
+		if err != nil {
+			rows.lasterr = err
+			return false
+		}
+	}
+
+	rows.current = rows.buffer[0]
+	rows.buffer = rows.buffer[1:len(rows.buffer)]
+	rows.count++
+	return true
+}
+
+// Peek writes the row after the current one into the provided variable,
+// without moving the iterator forward, the next call to .Next() moves to that
+// row.  ErrNoMoreRows is returned if there is no row after the current one,
+// or the error that stopped the iterator.
+//
+// Example usage:
+//
+//  // join two cursors that are both ordered by id
+//  for heroes.Next() {
+//      var hero, villain Hero
+//      heroes.Scan(&hero)
+//      for villains.Peek(&villain) == nil && villain.Id < hero.Id {
+//          villains.Next()
+//      }
+//      ...
+//  }
+func (rows *Rows) Peek(dest interface{}) error {
+	if !rows.hasPeeked {
+		current := rows.current
+		if !rows.Next() {
+			if rows.Err() != nil {
+				return rows.Err()
 			}
+			return ErrNoMoreRows{}
 		}
+		rows.peeked = rows.current
+		rows.hasPeeked = true
+		rows.current = current
+		rows.count--
 	}
+	return scanDatum(rows.peeked, rows.format, dest)
+}
 
-	if len(rows.buffer) > 0 {
-		rows.current = rows.buffer[0]
-		rows.buffer = rows.buffer[1:len(rows.buffer)]
+// Chan sends the rows to a channel, each decoded into the channel's element
+// type, from a separate goroutine.  More rows are fetched from the server only
+// as the channel is drained, so a buffered channel sets how far ahead of the
+// consumer the reading gets.  The channel is closed after the last row.
+//
+// The returned function stops the reading, closing the channel if that has
+// not happened yet, and returns the error that ended it, if any.  It must be
+// called once the rows are no longer needed.  Chan panics if `channel` is not
+// a channel that can be sent to.
+//
+// The goroutine reads from the session's connection, so the session must be
+// dedicated to these rows, and not used for anything else, until the returned
+// function has been called.
+//
+// Example usage:
+//
+//  heroes := make(chan Hero, 100)
+//  stop := r.Table("heroes").Run(feedSession).Chan(heroes)
+//  for i := 0; i < workers; i++ {
+//      go func() {
+//          for hero := range heroes {
+//              ...
+//          }
+//      }()
+//  }
+//  ...
+//  err := stop()
+func (rows *Rows) Chan(channel interface{}) (stop func() error) {
+	channelValue := reflect.ValueOf(channel)
+	if channelValue.Kind() != reflect.Chan || channelValue.Type().ChanDir()&reflect.SendDir == 0 {
+		panic("rethinkdb: .Chan() needs a channel that rows can be sent to")
 	}
 
-	return true
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		defer channelValue.Close()
+		// ends the query on the server however the reading stops
+		defer rows.Close()
+		for rows.Next() {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			elemValue := reflect.New(channelValue.Type().Elem())
+			if err = rows.Scan(elemValue.Interface()); err != nil {
+				return
+			}
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: channelValue, Send: elemValue.Elem()},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(quit)},
+			})
+			if chosen == 1 {
+				return
+			}
+		}
+		err = rows.Err()
+	}()
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() {
+			close(quit)
+			if rows.feed {
+				// a .Next() waiting on a changefeed only returns once the
+				// feed is stopped, other reads notice quit by themselves
+				rows.Close()
+			}
+		})
+		<-done
+		return err
+	}
+}
+
+// Close stops reading rows.  If the server has more rows for the query, it is
+// told to stop sending them, which is the only way to end a changefeed.  Close
+// may be called from another goroutine to stop a .Next() that is waiting for
+// a change, it is the only method of Rows that is safe to call while another
+// one is running.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Changes().Run(session)
+//  go func() {
+//      time.Sleep(time.Minute)
+//      rows.Close()
+//  }()
+//  for rows.Next() {
+//      ...
+//  }
+func (rows *Rows) Close() error {
+	rows.mu.Lock()
+	defer rows.mu.Unlock()
+
+	if rows.spill != nil {
+		rows.spill.remove()
+		rows.spill = nil
+	}
+	for _, source := range rows.sources {
+		source.rows.Close()
+	}
+	if rows.complete || rows.session == nil {
+		rows.closed = true
+		return nil
+	}
+
+	// the response to the STOP, and to any CONTINUE that is waiting, have our
+	// token, and are skipped by whichever query reads responses next
+	queryProto := &p.Query{
+		Type:  p.Query_STOP.Enum(),
+		Token: proto.Int64(rows.token),
+	}
+	rows.complete = true
+	if err := rows.session.conn.writeQuery(queryProto); err != nil {
+		return err
+	}
+	return rows.session.conn.flush()
+}
+
+// ResultType is the kind of result returned by a query, see Rows.Type().
+type ResultType int
+
+const (
+	ResultNone     ResultType = iota // no result, because the query failed
+	ResultAtom                       // a single value, read it with .One()
+	ResultSequence                   // a sequence of rows, read them with .Next() or .All()
+	ResultFeed                       // an endless stream of changes, read them with .Next()
+)
+
+func (t ResultType) String() string {
+	switch t {
+	case ResultAtom:
+		return "atom"
+	case ResultSequence:
+		return "sequence"
+	case ResultFeed:
+		return "feed"
+	}
+	return "none"
+}
+
+// Type returns the kind of result the query returned.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Get(id).Run(session)
+//  if rows.Type() == r.ResultAtom {
+//      ...
+//  }
+func (rows *Rows) Type() ResultType {
+	switch rows.responseType {
+	case p.Response_SUCCESS_ATOM:
+		return ResultAtom
+	case p.Response_SUCCESS_SEQUENCE, p.Response_SUCCESS_PARTIAL:
+		if rows.feed {
+			return ResultFeed
+		}
+		return ResultSequence
+	}
+	return ResultNone
+}
+
+// Index returns the zero-based position of the current row among all the rows
+// returned by the query, counting across batches fetched from the server.  It
+// is -1 before the first call to .Next().
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Run(session)
+//  for rows.Next() {
+//      if rows.Index()%1000 == 0 {
+//          log.Println("processed", rows.Index(), "heroes")
+//      }
+//  }
+func (rows *Rows) Index() int {
+	return rows.count - 1
 }
 
 // Scan writes the current row into the provided variable, which must be passed
@@ -124,8 +392,71 @@ func (rows *Rows) Next() bool {
 // NOTE: Scan uses json.Unmarshal internally and will not clear the destination
 // before writing the next row.  Make sure to create a new destination or clear
 // it before calling .Scan(&dest).
+//
+// If the row cannot be decoded into `dest`, a DecodeError is returned.  Any
+// Transform registered for the type of `dest` is applied, see
+// RegisterTransform().
 func (rows *Rows) Scan(dest interface{}) error {
-	return datumUnmarshal(rows.current, dest)
+	return scanDatum(rows.current, rows.format, dest)
+}
+
+// ScanFields is like .Scan(), but also returns the names of the fields of the
+// row that were decoded into `dest`, sorted.  This lets a query that only
+// .Pluck()s some fields be decoded into the full struct type, while telling
+// which fields were set.  Fields of `dest` that are not in the row are left
+// as they are.  For destinations other than structs, all of the fields of the
+// row are returned.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Pluck("id", "name").Run(session)
+//  for rows.Next() {
+//      var hero Hero
+//      fields, err := rows.ScanFields(&hero)
+//      // fields is ["id", "name"], the rest of hero is unset
+//  }
+func (rows *Rows) ScanFields(dest interface{}) ([]string, error) {
+	if err := rows.Scan(dest); err != nil {
+		return nil, err
+	}
+
+	structType := reflect.TypeOf(dest)
+	for structType != nil && structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	var structInfo []fieldInfo
+	if structType != nil && structType.Kind() == reflect.Struct {
+		structInfo = structFields(structType)
+	}
+
+	fields := []string{}
+	for _, pair := range rows.current.GetRObject() {
+		name := pair.GetKey()
+		if structInfo != nil {
+			if _, ok := fieldByName(structInfo, name); !ok {
+				continue
+			}
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// scanDatum decodes a row into `dest`, see .Scan().
+func scanDatum(datum *p.Datum, format pseudoTypeFormat, dest interface{}) error {
+	if value := reflect.ValueOf(dest); value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Map {
+		// grouped results are decoded into maps keyed by group
+		format.groupsAsObject = true
+	}
+	data, err := datumToJsonFormat(datum, format)
+	if err != nil {
+		return err
+	}
+	if err := transformedDecode(data, dest); err != nil {
+		return newDecodeError(dest, data, err)
+	}
+	return nil
 }
 
 // Err returns the last error encountered, for example, a network error while
@@ -140,7 +471,8 @@ func (rows *Rows) Err() error {
 
 // All fetches all the results from an iterator into a reference to a slice.  It
 // may perform multiple network requests to the server until it has retrieved
-// all results.
+// all results.  If RunOpts.MaxRows is set and there are more rows than that,
+// ErrTooManyRows is returned and `slice` is left unchanged.
 //
 // Example usage:
 //
@@ -172,6 +504,9 @@ func (rows *Rows) All(slice interface{}) error {
 				return err
 			}
 			newSliceValue = reflect.Append(newSliceValue, elemValue.Elem())
+			if rows.maxRows > 0 && newSliceValue.Len() > rows.maxRows {
+				return ErrTooManyRows{Limit: rows.maxRows}
+			}
 		}
 
 		if rows.Err() != nil {
@@ -182,6 +517,9 @@ func (rows *Rows) All(slice interface{}) error {
 		return nil
 	} else if rows.responseType == p.Response_SUCCESS_ATOM {
 		// if we got a single datum from the server, try to read it into the slice we got
+		if rows.maxRows > 0 && len(rows.buffer) > 0 && len(rows.buffer[0].GetRArray()) > rows.maxRows {
+			return ErrTooManyRows{Limit: rows.maxRows}
+		}
 		if rows.Next() {
 			if err := rows.Scan(slicePointerValue.Interface()); err != nil {
 				return err
@@ -196,7 +534,70 @@ func (rows *Rows) All(slice interface{}) error {
 	return ErrWrongResponseType{}
 }
 
-// One gets the first result from a query response.
+// Reduce folds the rows into a single value on the client, one row at a
+// time, for aggregations that cannot be written as a query.  `fn` is called
+// with the value so far, starting with `acc`, and the JSON of the next row,
+// and returns the new value.  Rows are fetched from the server as they are
+// needed, so only one batch is held in memory at a time.  If the result is a
+// single array, its items are folded over instead.  Reading stops at the
+// first error returned by `fn`, and the rows are closed.
+//
+// Example usage:
+//
+//  // time spent fighting, counting overlapping battles once
+//  rows := r.Table("battles").OrderBy("started").Run(session)
+//  result, err := rows.Reduce(timeline{}, func(acc interface{}, row json.RawMessage) (interface{}, error) {
+//      var battle Battle
+//      if err := json.Unmarshal(row, &battle); err != nil {
+//          return nil, err
+//      }
+//      return acc.(timeline).add(battle.Started, battle.Ended), nil
+//  })
+func (rows *Rows) Reduce(acc interface{}, fn func(acc interface{}, row json.RawMessage) (interface{}, error)) (interface{}, error) {
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	switch rows.Type() {
+	case ResultAtom:
+		var items []json.RawMessage
+		if err := rows.All(&items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			var err error
+			if acc, err = fn(acc, item); err != nil {
+				return nil, err
+			}
+		}
+	case ResultSequence:
+		for rows.Next() {
+			var row json.RawMessage
+			if err := rows.Scan(&row); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			var err error
+			if acc, err = fn(acc, row); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+	default:
+		return nil, ErrWrongResponseType{}
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return acc, nil
+}
+
+// One gets the result from a query response that is a single value.  It can
+// also be used on a sequence that has exactly one row, an ErrRowCount is
+// returned if the sequence has more or fewer rows than that, and the rest of
+// the sequence is closed.  If an error is returned, the contents of `row` are
+// undefined.
 //
 // Example usage:
 //
@@ -207,16 +608,67 @@ func (rows *Rows) One(row interface{}) error {
 		return rows.Err()
 	}
 
-	if rows.responseType != p.Response_SUCCESS_ATOM {
+	switch rows.Type() {
+	case ResultAtom:
+		rows.Next()
+		if err := rows.Scan(row); err != nil {
+			return err
+		}
+	case ResultSequence:
+		if !rows.Next() {
+			if rows.Err() != nil {
+				return rows.Err()
+			}
+			return ErrRowCount{Count: 0}
+		}
+		if err := rows.Scan(row); err != nil {
+			rows.Close()
+			return err
+		}
+		if rows.Next() {
+			// stop the server from sending the rest of the sequence
+			rows.Close()
+			return ErrRowCount{Count: 2}
+		}
+	default:
 		return ErrWrongResponseType{}
 	}
 
-	rows.Next()
-	if err := rows.Scan(row); err != nil {
+	return rows.Err()
+}
+
+// DecodeInto gets the result from a query response that is a single object,
+// and decodes each of its fields into a separate destination, keyed by field
+// name.  This lets one query return several independent results, each with its
+// own type.  Every field in `dests` must be in the object, fields that are
+// not in `dests` are ignored.
+//
+// Example usage:
+//
+//  var heroes []Hero
+//  var villainCount int
+//  err := r.Expr(r.Map{
+//      "heroes":   r.Table("heroes").OrderBy("name").Limit(10),
+//      "villains": r.Table("villains").Count(),
+//  }).Run(session).DecodeInto(map[string]interface{}{
+//      "heroes":   &heroes,
+//      "villains": &villainCount,
+//  })
+func (rows *Rows) DecodeInto(dests map[string]interface{}) error {
+	var fields map[string]json.RawMessage
+	if err := rows.One(&fields); err != nil {
 		return err
 	}
-
-	return rows.Err()
+	for name, dest := range dests {
+		data, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("rethinkdb: .DecodeInto() result has no field %q", name)
+		}
+		if err := transformedDecode(data, dest); err != nil {
+			return newDecodeError(dest, data, err)
+		}
+	}
+	return nil
 }
 
 // Exec is for queries for which you wish to ignore the result.  For instance,
@@ -0,0 +1,182 @@
+package rethinkgo
+
+// Options that apply to a whole query, sent to the server along with it.
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"sort"
+)
+
+// RunOpts holds options for running a query with .RunWith().  Options that are
+// left unset use the session's defaults, see Session.SetDefaultRunOpts().
+type RunOpts struct {
+	// Allow reading potentially out-of-date data from every table in the query.
+	UseOutdated *bool
+	// How times are decoded, either "native" (the default) to decode them as
+	// time.Time values, or "raw" to leave them as the server's
+	// {"$reql_type$": "TIME", ...} objects.  This is handled by the driver and
+	// is not sent to the server.
+	TimeFormat string
+	// Ask the server to profile the query.
+	Profile *bool
+	// Durability of every write in the query, either "hard" or "soft".
+	Durability string
+	// Database used by tables in the query that do not name one, instead of
+	// the session's database.  This is handled by the driver and is not sent
+	// to the server.
+	Db string
+	// Send the query without waiting for the response, as .RunNoreply()
+	// does.  The returned Rows are empty.
+	Noreply *bool
+	// Largest array the server will build while running the query, zero for
+	// the server's default.
+	ArrayLimit int
+	// Hints for how many rows the server sends in each batch of a stream,
+	// fetched with one round trip each: at most MaxBatchRows rows, stopping
+	// after about MaxBatchBytes bytes.  The first batch is smaller by
+	// FirstBatchScaledown, so that the first rows arrive sooner.  Zero for
+	// the server's defaults.
+	MaxBatchRows        int
+	MaxBatchBytes       int
+	FirstBatchScaledown int
+	// Largest number of rows .All() reads before giving up with
+	// ErrTooManyRows, zero for no limit.  This is handled by the driver and is
+	// not sent to the server.
+	MaxRows int
+	// Any other optargs for the whole query, keyed by name, for options this
+	// struct has no field for.  The fields above take precedence over these.
+	Optargs map[string]interface{}
+}
+
+// Bool returns a pointer to a bool, for use with the optional fields of
+// RunOpts.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").RunWith(session, r.RunOpts{UseOutdated: r.Bool(true)})
+func Bool(b bool) *bool {
+	return &b
+}
+
+// merge returns the options with any unset fields taken from the defaults.
+func (opts RunOpts) merge(defaults RunOpts) RunOpts {
+	if opts.UseOutdated == nil {
+		opts.UseOutdated = defaults.UseOutdated
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = defaults.TimeFormat
+	}
+	if opts.Profile == nil {
+		opts.Profile = defaults.Profile
+	}
+	if opts.Durability == "" {
+		opts.Durability = defaults.Durability
+	}
+	if opts.Db == "" {
+		opts.Db = defaults.Db
+	}
+	if opts.Noreply == nil {
+		opts.Noreply = defaults.Noreply
+	}
+	if opts.ArrayLimit == 0 {
+		opts.ArrayLimit = defaults.ArrayLimit
+	}
+	if opts.MaxRows == 0 {
+		opts.MaxRows = defaults.MaxRows
+	}
+	if opts.MaxBatchRows == 0 {
+		opts.MaxBatchRows = defaults.MaxBatchRows
+	}
+	if opts.MaxBatchBytes == 0 {
+		opts.MaxBatchBytes = defaults.MaxBatchBytes
+	}
+	if opts.FirstBatchScaledown == 0 {
+		opts.FirstBatchScaledown = defaults.FirstBatchScaledown
+	}
+	if len(defaults.Optargs) > 0 {
+		optargs := map[string]interface{}{}
+		for key, value := range defaults.Optargs {
+			optargs[key] = value
+		}
+		for key, value := range opts.Optargs {
+			optargs[key] = value
+		}
+		opts.Optargs = optargs
+	}
+	return opts
+}
+
+// globalOptargs returns the options that are set, keyed by optarg name.
+func (opts RunOpts) globalOptargs() map[string]interface{} {
+	optargs := map[string]interface{}{}
+	for key, value := range opts.Optargs {
+		optargs[key] = value
+	}
+	if opts.UseOutdated != nil {
+		optargs["use_outdated"] = *opts.UseOutdated
+	}
+	if opts.Profile != nil {
+		optargs["profile"] = *opts.Profile
+	}
+	if opts.Durability != "" {
+		optargs["durability"] = opts.Durability
+	}
+	if opts.Noreply != nil && *opts.Noreply {
+		optargs["noreply"] = true
+	}
+	if opts.ArrayLimit != 0 {
+		optargs["array_limit"] = opts.ArrayLimit
+	}
+	if opts.MaxBatchRows != 0 {
+		optargs["max_batch_rows"] = opts.MaxBatchRows
+	}
+	if opts.MaxBatchBytes != 0 {
+		optargs["max_batch_bytes"] = opts.MaxBatchBytes
+	}
+	if opts.FirstBatchScaledown != 0 {
+		optargs["first_batch_scaledown_factor"] = opts.FirstBatchScaledown
+	}
+	return optargs
+}
+
+// pseudoTypeFormat returns how pseudo-types in the results are decoded.
+func (opts RunOpts) pseudoTypeFormat() pseudoTypeFormat {
+	return pseudoTypeFormat{rawTime: opts.TimeFormat == "raw"}
+}
+
+// addGlobalOptargs adds optargs that apply to the whole query.
+func addGlobalOptargs(queryProto *p.Query, optargs map[string]interface{}) error {
+	// keep the generated query the same from run to run
+	var keys []string
+	for key := range optargs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := datumMarshal(optargs[key], true)
+		if err != nil {
+			return err
+		}
+		queryProto.GlobalOptargs = append(queryProto.GlobalOptargs, &p.Query_AssocPair{
+			Key: proto.String(key),
+			Val: value,
+		})
+	}
+	return nil
+}
+
+// SetDefaultRunOpts sets the options used for every query run on the session.
+// Options passed to .RunWith() take precedence over these.
+//
+// Example usage:
+//
+//  session.SetDefaultRunOpts(r.RunOpts{Durability: "soft"})
+//  // this insert is soft
+//  err := r.Table("logs").Insert(entry).Run(session).Exec()
+//  // this one is not
+//  err = r.Table("heroes").Insert(hero).RunWith(session, r.RunOpts{Durability: "hard"}).Exec()
+func (s *Session) SetDefaultRunOpts(opts RunOpts) {
+	s.defaultRunOpts = opts
+}
@@ -2,8 +2,10 @@ package rethinkgo
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
 	"fmt"
 	p "github.com/christopherhesse/rethinkgo/ql2"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -15,54 +17,154 @@ type Session struct {
 	// current query identifier, just needs to be unique for each query, so we
 	// can match queries with responses, e.g. 4782371
 	token int64
-	// address of server, e.g. "localhost:28015"
-	address string
+	// addresses of servers, e.g. ["localhost:28015"]
+	addresses []string
 	// database to use if no database is specified in query, e.g. "test"
 	database string
 	// maximum duration of a single query
 	timeout time.Duration
 	// authorization key for servers configured to check this
 	authkey string
+	// opens connections to the servers, or nil to use TCP
+	dialer Dialer
+	// version of the server, found when connecting, or zero if it is unknown
+	version serverVersion
+	// version set with SetServerVersion(), used instead of asking the server
+	configuredVersion serverVersion
+	// number of pending noreply queries that triggers a NoreplyWait(), or zero
+	noreplyHighWater int
+	// options for queries that do not set their own
+	defaultRunOpts RunOpts
+	// whether to kill queries on the server when they time out
+	killOnTimeout bool
+	// how long noreply queries wait to be sent with others, see SetWriteDelay()
+	writeDelay time.Duration
+	// keeps concurrent reconnects from piling up, see Reconnect()
+	reconnect reconnectState
+	// number of times a query is run again after a network error
+	maxRetries int
+	// reject legacy constructs in queries, see SetStrict()
+	strict bool
+	// rewrites the names of tables in queries, see SetTableResolver()
+	tableResolver func(name string) string
+	// queries that take longer than this are logged, or zero
+	slowQueryThreshold time.Duration
+	slowQueryLogger    func(query string, duration time.Duration)
+	// told about each query, see SetQueryHook()
+	queryHook QueryHook
 
-	conn *connection
-	closed    bool
+	conn   *connection
+	closed bool
 }
 
 // Connect creates a new database session.
 //
+// The address may be a comma-separated list of servers, in which case the
+// session connects to the first one that accepts the connection.  Sessions
+// take turns starting with each server, so that connections are spread
+// across them.
+//
 // NOTE: You probably should not share sessions between goroutines.
 //
 // Example usage:
 //
 //  sess, err := r.Connect("localhost:28015", "test")
+//  sess, err := r.Connect("db1:28015,db2:28015,db3:28015", "test")
 func Connect(address, database string) (*Session, error) {
-	s := &Session{address: address, database: database, closed: true}
+	s := &Session{addresses: splitAddresses(address), database: database, closed: true}
 	err := s.Reconnect()
 	return s, err
 }
 
 // ConnectWithAuth is the same as Connect, but also sets the authorization key
 // used to connect to the server.
-func ConnectWithAuth(address, database, authkey string) (*Session, error)  {
-	s := &Session{address: address, database: database, authkey: authkey, closed: true}
+func ConnectWithAuth(address, database, authkey string) (*Session, error) {
+	s := &Session{addresses: splitAddresses(address), database: database, authkey: authkey, closed: true}
 	err := s.Reconnect()
 	return s, err
 }
 
-// Reconnect closes and re-opens a session.
+// ConnectOpts holds the settings for ConnectWithOpts().
+type ConnectOpts struct {
+	// Address of the server, or a comma-separated list of servers, as for
+	// Connect().
+	Address string
+	// Database to use if no database is specified in a query.
+	Database string
+	// Authorization key for servers configured to check it.
+	AuthKey string
+	// Dialer opens the connection to each address, it is called with the
+	// network "tcp".  Set it to connect over a unix domain socket, through a
+	// tunnel, or to a fake server running in the same process.  Connections
+	// are made over TCP if it is nil.
+	Dialer Dialer
+	// Number of times a query is run again after a network error, see
+	// Session.SetMaxRetries().
+	MaxRetries int
+	// Range of delays between failed reconnects, see
+	// Session.SetReconnectBackoff().  Zero uses the defaults.
+	MinBackoff, MaxBackoff time.Duration
+}
+
+// ConnectWithOpts is the same as Connect, but takes all of the connection
+// settings in a ConnectOpts.
 //
 // Example usage:
 //
-//  err := sess.Reconnect()
-func (s *Session) Reconnect() error {
-	if err := s.Close(); err != nil {
-		return err
+//  sess, err := r.ConnectWithOpts(r.ConnectOpts{
+//      Address:  "rethinkdb",
+//      Database: "test",
+//      Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+//          var d net.Dialer
+//          return d.DialContext(ctx, "unix", "/var/run/rethinkdb.sock")
+//      },
+//  })
+func ConnectWithOpts(opts ConnectOpts) (*Session, error) {
+	s := &Session{
+		addresses:  splitAddresses(opts.Address),
+		database:   opts.Database,
+		authkey:    opts.AuthKey,
+		dialer:     opts.Dialer,
+		maxRetries: opts.MaxRetries,
+		closed:     true,
 	}
+	s.SetReconnectBackoff(opts.MinBackoff, opts.MaxBackoff)
+	err := s.Reconnect()
+	return s, err
+}
 
-	s.closed = false
-	var err error
-	s.conn, err = serverConnect(s.address, s.authkey)
-	return err
+// nextAddress is used to pick which server a new connection tries first.
+var nextAddress uint32
+
+// splitAddresses splits a comma-separated list of server addresses.
+func splitAddresses(address string) []string {
+	var addresses []string
+	for _, a := range strings.Split(address, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
+}
+
+// dial connects to one of the session's servers, trying each of them in turn
+// until one accepts the connection.
+func (s *Session) dial() (*connection, error) {
+	if len(s.addresses) == 0 {
+		return nil, fmt.Errorf("rethinkdb: No server address given")
+	}
+
+	start := int(atomic.AddUint32(&nextAddress, 1))
+	var lasterr error
+	for i := range s.addresses {
+		address := s.addresses[(start+i)%len(s.addresses)]
+		conn, err := serverConnect(s.dialer, address, s.authkey)
+		if err == nil {
+			return conn, nil
+		}
+		lasterr = err
+	}
+	return nil, lasterr
 }
 
 // Close closes the session, freeing any associated resources.
@@ -107,6 +209,101 @@ func (s *Session) Use(database string) {
 	s.database = database
 }
 
+// SetTablePrefix makes queries run on the session use tables whose names
+// start with `prefix`, so that r.Table("heroes") refers to the table
+// "tenant123_heroes".  This applies to creating and dropping tables as well,
+// but not to the names returned by r.TableList().  An empty prefix turns it
+// off.  This should not be used if the session is shared between goroutines.
+//
+// Example usage:
+//
+//  sess.SetTablePrefix("tenant123_")
+//  rows := r.Table("heroes").Run(sess) // reads from "tenant123_heroes"
+func (s *Session) SetTablePrefix(prefix string) {
+	if prefix == "" {
+		s.SetTableResolver(nil)
+		return
+	}
+	s.SetTableResolver(func(name string) string {
+		return prefix + name
+	})
+}
+
+// SetTableResolver is like SetTablePrefix(), but the table names used in
+// queries are rewritten by calling `resolver`.  Only names given as strings
+// are rewritten.  Set it to nil to turn it off.
+//
+// Example usage:
+//
+//  sess.SetTableResolver(func(name string) string {
+//      if name == "settings" {
+//          // shared by all tenants
+//          return name
+//      }
+//      return tenant + "_" + name
+//  })
+func (s *Session) SetTableResolver(resolver func(name string) string) {
+	s.tableResolver = resolver
+}
+
+// ServerVersion returns the version of RethinkDB that the session is connected
+// to, e.g. "1.7.1", or "" if it is not known.  Queries that use terms the
+// server does not support will fail with an error before being sent, which
+// is only possible when the version is known.
+//
+// Example usage:
+//
+//  fmt.Println("server version:", sess.ServerVersion())
+func (s *Session) ServerVersion() string {
+	if !s.version.known() {
+		return ""
+	}
+	return s.version.String()
+}
+
+// SetServerVersion tells the session which version of RethinkDB it is talking
+// to, e.g. "1.12.0", for servers that cannot report their version themselves.
+// Queries that use terms added after that version then fail before being
+// sent, and features that need a newer server are turned off.  The version is
+// kept across reconnects.  Set it to "" to forget it, the server is asked
+// again on the next reconnect.
+//
+// Example usage:
+//
+//  err := sess.SetServerVersion("1.12.0")
+func (s *Session) SetServerVersion(version string) error {
+	if version == "" {
+		s.configuredVersion = serverVersion{}
+		s.version = serverVersion{}
+		return nil
+	}
+	v, ok := parseServerVersion(version)
+	if !ok {
+		return fmt.Errorf("rethinkdb: Invalid server version %q", version)
+	}
+	s.configuredVersion = v
+	s.version = v
+	return nil
+}
+
+// Ping runs a trivial query on the server and returns how long it took to get
+// the answer, including the round trip over the network.
+//
+// Example usage:
+//
+//  latency, err := sess.Ping()
+func (s *Session) Ping() (time.Duration, error) {
+	if s.closed {
+		return 0, fmt.Errorf("rethinkdb: Session is closed")
+	}
+	start := time.Now()
+	var pong bool
+	if err := Expr(true).Run(s).One(&pong); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
 // getToken generates the next query token, used to number requests and match
 // responses with requests.
 func (s *Session) getToken() int64 {
@@ -130,17 +327,70 @@ func (s *Session) getToken() int64 {
 //      ...
 //  }
 func (s *Session) Run(query Exp) *Rows {
-	queryProto, err := s.getContext().buildProtobuf(query)
+	return s.RunWith(query, RunOpts{})
+}
+
+// RunWith is like .Run(), but with options that apply to the whole query.
+// Options that are not set use the session's defaults.
+//
+// Example usage:
+//
+//  rows := session.RunWith(query, r.RunOpts{UseOutdated: r.Bool(true)})
+func (s *Session) RunWith(query Exp, opts RunOpts) *Rows {
+	if chunks, ok := splitGetAll(query); ok {
+		var sources []*Rows
+		for _, chunk := range chunks {
+			rows := s.RunWith(chunk, opts)
+			if rows.Err() != nil {
+				return rows
+			}
+			sources = append(sources, rows)
+		}
+		return MergeCursors(sources...)
+	}
+
+	if noreply := opts.merge(s.defaultRunOpts).Noreply; noreply != nil && *noreply {
+		err := s.runNoreply(query, opts)
+		return &Rows{lasterr: err, complete: true, closed: true}
+	}
+
+	queryProto, err := s.buildQuery(query, opts)
 	if err != nil {
 		return &Rows{lasterr: err}
 	}
+	runLintHook(query, queryProto)
 
 	queryProto.Token = proto.Int64(s.getToken())
-	buffer, responseType, err := s.conn.executeQuery(queryProto, s.timeout)
+	text := s.queryStarted(queryProto)
+	start := time.Now()
+	response, attempts, err := s.executeWithRetry(queryProto)
+	duration := time.Since(start)
+	s.logSlowQuery(queryProto, duration)
+	var buffer []*p.Datum
+	var responseType p.Response_ResponseType
+	if err == nil {
+		buffer, responseType, err = parseResponse(response)
+	}
 	if err != nil {
+		s.checkTimeout(err)
+		info := s.conn.errorInfo(queryProto.GetToken(), start)
+		info.Attempt = attempts
+		err = withErrorInfo(err, info)
+		s.queryEnded(queryProto, text, duration, err)
 		return &Rows{lasterr: err}
 	}
+	s.queryEnded(queryProto, text, duration, nil)
+	rows := s.newRows(buffer, responseType, queryProto.GetToken())
+	merged := opts.merge(s.defaultRunOpts)
+	rows.format = merged.pseudoTypeFormat()
+	rows.maxRows = merged.MaxRows
+	rows.profile = response.GetProfile()
+	rows.feed = containsTermType(queryProto.GetQuery(), p.Term_CHANGES)
+	return rows
+}
 
+// newRows creates the iterator for the first response to a query.
+func (s *Session) newRows(buffer []*p.Datum, responseType p.Response_ResponseType, token int64) *Rows {
 	switch responseType {
 	case p.Response_SUCCESS_ATOM:
 		// single document (or json) response, return an iterator anyway for
@@ -157,7 +407,7 @@ func (s *Session) Run(query Exp) *Rows {
 		return &Rows{
 			session:      s,
 			buffer:       buffer,
-			token:        queryProto.GetToken(),
+			token:        token,
 			responseType: responseType,
 		}
 	case p.Response_SUCCESS_SEQUENCE:
@@ -174,8 +424,131 @@ func (s *Session) Run(query Exp) *Rows {
 	return &Rows{lasterr: fmt.Errorf("rethinkdb: Unexpected response type from server: %v", responseType)}
 }
 
+// RunBatch runs several independent queries at once and returns the results
+// keyed by the same names as the queries.  All of the queries are sent to the
+// server before waiting for any responses, so the total time taken is close to
+// that of the slowest query rather than the sum of all of them.
+//
+// An error is returned if there was a problem talking to the server, errors in
+// individual queries are returned by the .Err() method of their Rows.
+//
+// Example usage:
+//
+//  results, err := r.RunBatch(session, map[string]r.Exp{
+//      "heroes":   r.Table("heroes").Count(),
+//      "villains": r.Table("villains").OrderBy("name").Limit(10),
+//  })
+//  var heroCount int
+//  err = results["heroes"].One(&heroCount)
+//  var villains []interface{}
+//  err = results["villains"].All(&villains)
+func RunBatch(session *Session, queries map[string]Exp) (map[string]*Rows, error) {
+	results := map[string]*Rows{}
+	names := map[int64]string{}
+	var queryProtos []*p.Query
+
+	for name, query := range queries {
+		queryProto, err := session.buildQuery(query, RunOpts{})
+		if err != nil {
+			results[name] = &Rows{lasterr: err}
+			continue
+		}
+		queryProto.Token = proto.Int64(session.getToken())
+		names[queryProto.GetToken()] = name
+		queryProtos = append(queryProtos, queryProto)
+	}
+
+	if len(queryProtos) == 0 {
+		return results, nil
+	}
+
+	texts := map[int64]string{}
+	protos := map[int64]*p.Query{}
+	for _, queryProto := range queryProtos {
+		texts[queryProto.GetToken()] = session.queryStarted(queryProto)
+		protos[queryProto.GetToken()] = queryProto
+	}
+	start := time.Now()
+	responses, err := session.conn.executeQueries(queryProtos, session.timeout)
+	duration := time.Since(start)
+	if err != nil {
+		for _, queryProto := range queryProtos {
+			session.queryEnded(queryProto, texts[queryProto.GetToken()], duration, err)
+		}
+		return nil, err
+	}
+
+	for token, response := range responses {
+		queryProto := protos[token]
+		session.logSlowQuery(queryProto, duration)
+		buffer, responseType, err := parseResponse(response)
+		if err != nil {
+			err = withErrorInfo(err, session.conn.errorInfo(token, start))
+			session.queryEnded(queryProto, texts[token], duration, err)
+			results[names[token]] = &Rows{lasterr: err}
+			continue
+		}
+		session.queryEnded(queryProto, texts[token], duration, nil)
+		rows := session.newRows(buffer, responseType, token)
+		rows.format = session.defaultRunOpts.pseudoTypeFormat()
+		rows.maxRows = session.defaultRunOpts.MaxRows
+		rows.profile = response.GetProfile()
+		results[names[token]] = rows
+	}
+	return results, nil
+}
+
+// EvalAll evaluates several expressions in a single query, so that they only
+// cost one round trip to the server.  The results are returned as JSON in the
+// same order as the expressions.  If any of the expressions fails, the whole
+// query fails.
+//
+// Example usage:
+//
+//  results, err := r.EvalAll(session, []r.Exp{
+//      r.Table("heroes").Get(heroId).HasFields("flight"),
+//      r.Table("villains").Count(),
+//  })
+//  var canFly bool
+//  err = json.Unmarshal(results[0], &canFly)
+func EvalAll(session *Session, exprs []Exp) ([]json.RawMessage, error) {
+	items := make(List, len(exprs))
+	for i, expr := range exprs {
+		items[i] = expr
+	}
+	var results []json.RawMessage
+	if err := Expr(items).Run(session).One(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 func (s *Session) getContext() context {
-	return context{databaseName: s.database, atomic: true}
+	return context{
+		databaseName:  s.database,
+		serverVersion: s.version,
+		strict:        s.strict,
+		tableResolver: s.tableResolver,
+	}
+}
+
+// buildQuery converts a query to a protobuf, adding the options merged with
+// the session's defaults.
+func (s *Session) buildQuery(query Exp, opts RunOpts) (*p.Query, error) {
+	opts = opts.merge(s.defaultRunOpts)
+	ctx := s.getContext()
+	if opts.Db != "" {
+		ctx.databaseName = opts.Db
+	}
+	queryProto, err := ctx.buildProtobuf(query)
+	if err != nil {
+		return nil, err
+	}
+	optargs := opts.globalOptargs()
+	if err := addGlobalOptargs(queryProto, optargs); err != nil {
+		return nil, err
+	}
+	return queryProto, nil
 }
 
 // Run runs a query using the given session, there is one Run()
@@ -183,3 +556,24 @@ func (s *Session) getContext() context {
 func (e Exp) Run(session *Session) *Rows {
 	return session.Run(e)
 }
+
+// RunWith runs a query using the given session and options, see
+// Session.RunWith().
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").RunWith(session, r.RunOpts{UseOutdated: r.Bool(true)})
+func (e Exp) RunWith(session *Session, opts RunOpts) *Rows {
+	return session.RunWith(e, opts)
+}
+
+// RunWithDb runs a query using the given session, with tables that do not name
+// a database taken from the given one instead of the session's.  Unlike
+// Session.Use(), this does not change the session.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").RunWithDb(session, "marvel")
+func (e Exp) RunWithDb(session *Session, database string) *Rows {
+	return session.RunWith(e, RunOpts{Db: database})
+}
@@ -0,0 +1,130 @@
+package rethinkgo
+
+// Share a single server query between identical read queries that run at the
+// same time.
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"sync"
+)
+
+// SharedReads lets identical read queries that are run at the same time share
+// one query to the server and its results, see .RunShared().  Unlike a Cache,
+// nothing is kept once the query finishes, so results are never stale.  It is
+// safe to use from multiple goroutines, each with their own session.
+type SharedReads struct {
+	mu      sync.Mutex
+	running map[string]*sharedRead
+}
+
+// sharedRead is a query that is being run for one or more callers.
+type sharedRead struct {
+	done chan struct{}
+	// results, set before done is closed
+	buffer       []*p.Datum
+	responseType p.Response_ResponseType
+	err          error
+}
+
+// NewSharedReads creates an empty SharedReads.
+//
+// Example usage:
+//
+//  var shared = r.NewSharedReads()
+func NewSharedReads() *SharedReads {
+	return &SharedReads{running: map[string]*sharedRead{}}
+}
+
+// RunShared runs a query like .Run(), but if an identical query is already
+// being run through `shared`, waits for it and returns the same results
+// instead of sending the query again.  Queries are identical if they produce
+// the same terms and options, even if they were built separately.  All of the
+// results are read before any are returned.
+//
+// Writes and changefeeds are always run on their own.
+//
+// Example usage:
+//
+//  var shared = r.NewSharedReads()
+//
+//  // in each request handler
+//  var count int
+//  err := r.Table("heroes").Count().RunShared(session, shared).One(&count)
+func (e Exp) RunShared(session *Session, shared *SharedReads) *Rows {
+	queryProto, err := session.buildQuery(e, RunOpts{})
+	if err != nil {
+		return &Rows{lasterr: err}
+	}
+	if !canRetry(queryProto) || containsTermType(queryProto.GetQuery(), p.Term_CHANGES) {
+		return session.Run(e)
+	}
+	key, err := sharedKey(queryProto)
+	if err != nil {
+		return &Rows{lasterr: err}
+	}
+
+	shared.mu.Lock()
+	read, ok := shared.running[key]
+	if !ok {
+		read = &sharedRead{done: make(chan struct{})}
+		shared.running[key] = read
+	}
+	shared.mu.Unlock()
+
+	if !ok {
+		read.run(session, e)
+		shared.mu.Lock()
+		delete(shared.running, key)
+		shared.mu.Unlock()
+		close(read.done)
+	}
+	<-read.done
+
+	if read.err != nil {
+		return &Rows{lasterr: read.err}
+	}
+	return &Rows{
+		buffer:       read.buffer,
+		complete:     true,
+		responseType: read.responseType,
+		format:       session.defaultRunOpts.pseudoTypeFormat(),
+	}
+}
+
+// run runs the query and reads all of its results.
+func (read *sharedRead) run(session *Session, e Exp) {
+	rows := session.Run(e)
+	for rows.Next() {
+		read.buffer = append(read.buffer, rows.current)
+	}
+	read.err = rows.Err()
+
+	read.responseType = rows.responseType
+	if read.responseType == p.Response_SUCCESS_PARTIAL {
+		read.responseType = p.Response_SUCCESS_SEQUENCE
+	}
+}
+
+// sharedKey returns a key that is the same for identical queries, including
+// their global options.
+func sharedKey(queryProto *p.Query) (string, error) {
+	data, err := proto.Marshal(canonicalTerm(queryProto.GetQuery(), map[float64]float64{}))
+	if err != nil {
+		return "", fmt.Errorf("rethinkdb: Could not marshal protocol buffer: %v", err)
+	}
+	hash := sha1.New()
+	hash.Write(data)
+	// global optargs are already sorted by key
+	for _, optarg := range queryProto.GlobalOptargs {
+		data, err := proto.Marshal(optarg)
+		if err != nil {
+			return "", fmt.Errorf("rethinkdb: Could not marshal protocol buffer: %v", err)
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,38 @@
+package rethinkgo
+
+// Log queries that take longer than expected.
+
+import (
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"log"
+	"time"
+)
+
+// SetSlowQueryThreshold logs every query run on the session that takes longer
+// than `threshold` to get its first response, along with how long it took.
+// The query and duration are passed to `logger`, if it is nil they are
+// written with the "log" package.  Set the threshold to zero to disable.
+//
+// Example usage:
+//
+//  sess.SetSlowQueryThreshold(100*time.Millisecond, nil)
+//  sess.SetSlowQueryThreshold(time.Second, func(query string, duration time.Duration) {
+//      metrics.RecordSlowQuery(query, duration)
+//  })
+func (s *Session) SetSlowQueryThreshold(threshold time.Duration, logger func(query string, duration time.Duration)) {
+	s.slowQueryThreshold = threshold
+	s.slowQueryLogger = logger
+}
+
+// logSlowQuery logs a query if it was slow, see SetSlowQueryThreshold().
+func (s *Session) logSlowQuery(queryProto *p.Query, duration time.Duration) {
+	if s.slowQueryThreshold <= 0 || duration < s.slowQueryThreshold {
+		return
+	}
+	query := termString(queryProto.GetQuery())
+	if s.slowQueryLogger != nil {
+		s.slowQueryLogger(query, duration)
+		return
+	}
+	log.Printf("rethinkdb: slow query took %v: %v", duration, query)
+}
@@ -0,0 +1,61 @@
+package rethinkgo
+
+// Mark rows as deleted instead of removing them.
+
+var softDeleteField = "deleted_at"
+
+// SetSoftDeleteField sets the field that .SoftDelete() stores the time of
+// deletion in, "deleted_at" by default.
+//
+// Example usage:
+//
+//  r.SetSoftDeleteField("removed_on")
+func SetSoftDeleteField(field string) {
+	softDeleteField = field
+}
+
+// SoftDelete marks the selected rows as deleted by setting the soft delete
+// field to the server's current time, see SetSoftDeleteField().  The rows stay
+// in the table, use .WithoutDeleted() to leave them out of queries and
+// .RestoreDeleted() to bring them back.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  err := r.Table("heroes").Get("Omega Red").SoftDelete().Run(session).One(&response)
+func (e Exp) SoftDelete() Exp {
+	return e.Update(Map{softDeleteField: Now()})
+}
+
+// RestoreDeleted clears the soft delete field of the selected rows, undoing
+// .SoftDelete().
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  err := r.Table("heroes").OnlyDeleted().RestoreDeleted().Run(session).One(&response)
+func (e Exp) RestoreDeleted() Exp {
+	return e.Update(Map{softDeleteField: nil})
+}
+
+// WithoutDeleted filters out the rows that were marked as deleted with
+// .SoftDelete().
+//
+// Example usage:
+//
+//  var heroes []Hero
+//  err := r.Table("heroes").WithoutDeleted().Run(session).All(&heroes)
+func (e Exp) WithoutDeleted() Exp {
+	return e.Filter(Row.Attr(softDeleteField).Default(nil).Eq(nil))
+}
+
+// OnlyDeleted keeps only the rows that were marked as deleted with
+// .SoftDelete().
+//
+// Example usage:
+//
+//  var heroes []Hero
+//  err := r.Table("heroes").OnlyDeleted().Run(session).All(&heroes)
+func (e Exp) OnlyDeleted() Exp {
+	return e.Filter(Row.Attr(softDeleteField).Default(nil).Ne(nil))
+}
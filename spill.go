@@ -0,0 +1,142 @@
+package rethinkgo
+
+// Read all of a query's results ahead into a temporary file.
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/binary"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// spillFile holds rows that were read ahead from the server, each stored as
+// a 4-byte little-endian length followed by the protocol buffer for the row.
+type spillFile struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// SpillToDisk reads all of the remaining rows from the server and writes them
+// to a temporary file in `dir`, or the default temporary directory if it is
+// empty.  The rows are then read back from the file, one at a time, by
+// .Next() and the other methods.  This keeps the memory used to a single batch
+// of rows, however large the result is, while letting the query on the server
+// finish without waiting for the rows to be processed.  The file is removed
+// once all of the rows have been read, or when the rows are closed.
+//
+// Changefeeds never finish, so they cannot be spilled.
+//
+// Example usage:
+//
+//  rows := r.Table("heroes").Run(session)
+//  if err := rows.SpillToDisk(""); err != nil {
+//      return err
+//  }
+//  defer rows.Close()
+//  for rows.Next() {
+//      var hero Hero
+//      rows.Scan(&hero)
+//      exportSlowly(hero)
+//  }
+func (rows *Rows) SpillToDisk(dir string) error {
+	if rows.feed {
+		return fmt.Errorf("rethinkdb: Cannot spill a changefeed to disk")
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	file, err := ioutil.TempFile(dir, "rethinkgo-spill-")
+	if err != nil {
+		return err
+	}
+	spill := &spillFile{file: file}
+	if err := spill.write(rows); err != nil {
+		spill.remove()
+		return err
+	}
+
+	// read the rows from the start of the file from now on
+	if _, err := file.Seek(0, 0); err != nil {
+		spill.remove()
+		return err
+	}
+	spill.reader = bufio.NewReader(file)
+	rows.spill = spill
+	rows.closed = false
+	return nil
+}
+
+// write reads all of the remaining rows and writes them to the file, leaving
+// the count of rows returned as it was.
+func (spill *spillFile) write(rows *Rows) error {
+	count := rows.count
+	current := rows.current
+	writer := bufio.NewWriter(spill.file)
+	for rows.Next() {
+		data, err := proto.Marshal(rows.current)
+		if err != nil {
+			return fmt.Errorf("rethinkdb: Could not marshal protocol buffer: %v", err)
+		}
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint32(header, uint32(len(data)))
+		if _, err := writer.Write(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	rows.count = count
+	rows.current = current
+	return writer.Flush()
+}
+
+// next reads the next row from the file, returning nil once there are none
+// left.
+func (spill *spillFile) next() (*p.Datum, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(spill.reader, header); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(header))
+	if _, err := io.ReadFull(spill.reader, data); err != nil {
+		return nil, err
+	}
+	datum := &p.Datum{}
+	if err := proto.Unmarshal(data, datum); err != nil {
+		return nil, fmt.Errorf("rethinkdb: Could not unmarshal protocol buffer: %v", err)
+	}
+	return datum, nil
+}
+
+// remove closes and deletes the file.
+func (spill *spillFile) remove() error {
+	spill.file.Close()
+	return os.Remove(spill.file.Name())
+}
+
+// nextFromSpill moves to the next row in the spill file.
+func (rows *Rows) nextFromSpill() bool {
+	datum, err := rows.spill.next()
+	if err != nil || datum == nil {
+		rows.lasterr = err
+		rows.closed = true
+		rows.spill.remove()
+		rows.spill = nil
+		return false
+	}
+	rows.current = datum
+	rows.count++
+	return true
+}
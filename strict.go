@@ -0,0 +1,40 @@
+package rethinkgo
+
+// Reject constructs that only work with the protocol of older servers.
+
+// SetStrict makes queries run on the session fail with a BuildError if they use
+// a method that sends something newer servers have replaced or removed, such
+// as .Overwrite() or .GroupBy().  The error says what to use instead, so
+// strict mode can be turned on in tests to find what needs changing before
+// an upgrade.  Options set by the driver's own helpers, such as
+// .InsertWith(), are allowed, since those can be updated in one place.
+//
+// Example usage:
+//
+//  session.SetStrict(true)
+//  err := r.Table("heroes").Insert(hero).Overwrite(true).Run(session).Exec()
+//  // rethinkdb: .Overwrite() sets upsert, which server 2.0 replaces with conflict, ...
+func (s *Session) SetStrict(strict bool) {
+	s.strict = strict
+}
+
+// legacy marks an expression as rejected by strict mode, with a message saying
+// what to use instead.
+func legacy(e Exp, message string) Exp {
+	e.legacy = message
+	return e
+}
+
+// checkStrict panics if an expression, or any of the options chained onto it,
+// is rejected by strict mode.
+func checkStrict(e Exp) {
+	for {
+		if e.legacy != "" {
+			panic(e.legacy)
+		}
+		if _, ok := termOptions[e.kind]; !ok {
+			return
+		}
+		e = Expr(e.args[0])
+	}
+}
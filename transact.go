@@ -0,0 +1,100 @@
+package rethinkgo
+
+// Read-modify-write of a single document, retried when the document changes
+// in between.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// transactConflict is the message of the error raised by the server when the
+// document changed between reading and replacing it.
+const transactConflict = "rethinkgo: Document changed during transaction"
+
+// TransactionConflictError is returned by .Transact() when the document kept
+// changing between reading and writing it, and it ran out of retries.
+type TransactionConflictError struct {
+	Attempts int // the number of times the document was read and written
+}
+
+func (e TransactionConflictError) Error() string {
+	return fmt.Sprintf("rethinkdb: Document changed during each of %v attempts at a transaction", e.Attempts)
+}
+
+// Transact atomically updates a single document, such as one selected with
+// .Get(), with changes worked out in Go.  The document is read and passed to
+// `update`, which returns the new document, or nil to delete it.  The new
+// document is only written if the document on the server is still the same as
+// the one that was read, otherwise it is read again and `update` is called
+// again, up to `retries` more times before giving up with a
+// TransactionConflictError.  If `update` returns an error, nothing is written
+// and the error is returned.
+//
+// The document is nil if it does not exist, and times in it are left as the
+// server's {"$reql_type$": "TIME", ...} objects so that they can be compared
+// exactly.  Since `update` may be called more than once, it should not have
+// other side effects.
+//
+// Example usage:
+//
+//  // move funds without letting the balance go below zero
+//  response, err := r.Table("accounts").Get("alice").Transact(session, 5, func(doc map[string]interface{}) (interface{}, error) {
+//      balance := doc["balance"].(float64)
+//      if balance < 100 {
+//          return nil, ErrInsufficientFunds
+//      }
+//      doc["balance"] = balance - 100
+//      doc["reserved"] = doc["reserved"].(float64) + 100
+//      return doc, nil
+//  })
+func (e Exp) Transact(session *Session, retries int, update func(doc map[string]interface{}) (interface{}, error)) (WriteResponse, error) {
+	for attempt := 1; ; attempt++ {
+		var doc map[string]interface{}
+		err := e.RunWith(session, RunOpts{TimeFormat: "raw"}).One(&doc)
+		if err != nil {
+			return WriteResponse{}, err
+		}
+
+		// the document is passed to update, which may change it
+		var original interface{}
+		if doc != nil {
+			original = copyDocument(doc)
+		}
+		replacement, err := update(doc)
+		if err != nil {
+			return WriteResponse{}, err
+		}
+
+		precondition := Branch(Row.Eq(original), replacement, RuntimeError(transactConflict))
+		var response WriteResponse
+		if err := e.Replace(precondition).Run(session).One(&response); err != nil {
+			return response, err
+		}
+		if response.Errors == 0 || !strings.Contains(response.FirstError, transactConflict) {
+			return response, response.Err()
+		}
+		if attempt > retries {
+			return response, TransactionConflictError{Attempts: attempt}
+		}
+	}
+}
+
+// copyDocument makes a deep copy of a decoded JSON value.
+func copyDocument(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[key] = copyDocument(item)
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, item := range v {
+			result = append(result, copyDocument(item))
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,123 @@
+package rethinkgo
+
+// Reshape rows for particular destination types as they are decoded, so that
+// fixes for old or unusual data live in one place.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Transform changes rows decoded into a particular type, see
+// RegisterTransform().  Either function may be nil.
+type Transform struct {
+	// Before changes a row before it is decoded, e.g. to rename legacy
+	// fields.  It is only called for rows that are objects.  Numbers in the
+	// row are json.Number, so that large integers are not rounded.
+	Before func(row map[string]interface{}) error
+	// After changes a value after it has been decoded, e.g. to fill in
+	// derived fields.  It is called with a pointer to the value.
+	After func(value interface{}) error
+}
+
+var transforms = struct {
+	sync.RWMutex
+	types map[reflect.Type]Transform
+}{types: map[reflect.Type]Transform{}}
+
+// RegisterTransform sets the Transform applied to rows that are decoded into
+// values of the same type as `value`, by .Scan(), .One() and .All(), including
+// each element when decoding into a slice of that type.
+//
+// Example usage:
+//
+//  r.RegisterTransform(Hero{}, r.Transform{
+//      Before: func(row map[string]interface{}) error {
+//          if name, ok := row["hero_name"]; ok {
+//              row["name"] = name
+//          }
+//          return nil
+//      },
+//      After: func(value interface{}) error {
+//          hero := value.(*Hero)
+//          hero.Total = hero.Strength + hero.Speed
+//          return nil
+//      },
+//  })
+func RegisterTransform(value interface{}, t Transform) {
+	transforms.Lock()
+	defer transforms.Unlock()
+	transforms.types[reflect.TypeOf(value)] = t
+}
+
+func lookupTransform(t reflect.Type) (Transform, bool) {
+	transforms.RLock()
+	defer transforms.RUnlock()
+	if len(transforms.types) == 0 {
+		return Transform{}, false
+	}
+	transform, ok := transforms.types[t]
+	return transform, ok
+}
+
+// transformedDecode decodes a row into dest, applying any transforms for its
+// type, or the type of its elements.
+func transformedDecode(data []byte, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return decodeJson(data, dest)
+	}
+	t := v.Type().Elem()
+	if transform, ok := lookupTransform(t); ok {
+		return decodeWithTransform(data, v, transform)
+	}
+
+	if t.Kind() != reflect.Slice {
+		return decodeJson(data, dest)
+	}
+	transform, ok := lookupTransform(t.Elem())
+	if !ok || isJsonNull(data) {
+		return decodeJson(data, dest)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return decodeJson(data, dest)
+	}
+	slice := reflect.MakeSlice(t, len(items), len(items))
+	for i, item := range items {
+		if err := decodeWithTransform(item, slice.Index(i).Addr(), transform); err != nil {
+			return withPath(err, fmt.Sprintf(".%v", i))
+		}
+	}
+	v.Elem().Set(slice)
+	return nil
+}
+
+// decodeWithTransform decodes a single value, where v is a pointer to it.
+func decodeWithTransform(data []byte, v reflect.Value, transform Transform) error {
+	if transform.Before != nil {
+		var row map[string]interface{}
+		// keep numbers exactly as the server sent them
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if decoder.Decode(&row) == nil && row != nil {
+			if err := transform.Before(row); err != nil {
+				return err
+			}
+			var err error
+			if data, err = json.Marshal(row); err != nil {
+				return err
+			}
+		}
+	}
+	if err := decodeJson(data, v.Interface()); err != nil {
+		return err
+	}
+	if transform.After != nil {
+		return transform.After(v.Interface())
+	}
+	return nil
+}
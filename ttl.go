@@ -0,0 +1,171 @@
+package rethinkgo
+
+// Delete rows once their expiry time has passed.
+
+import (
+	"sync"
+	"time"
+)
+
+var expiryField = "expires_at"
+
+// how many expired rows are deleted by each query, see ReapExpired()
+var reapBatchSize = 1000
+
+// the earliest time the server can store, so that .Expired() only selects
+// rows whose expiry field is a time
+var earliestTime = time.Date(1400, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SetExpiryField sets the field that holds the time a row expires at,
+// "expires_at" by default.  The field is also the name of the secondary index
+// on it, see TTLIndex().
+//
+// Example usage:
+//
+//  r.SetExpiryField("valid_until")
+func SetExpiryField(field string) {
+	expiryField = field
+}
+
+// TTLIndex returns the spec of the secondary index on the expiry field, which
+// .Expired() and ReapExpired() need.  RethinkDB does not remove rows by itself
+// when they expire, so a Reaper should be run for each table that has it.
+//
+// Example usage:
+//
+//  err := r.EnsureIndexes(session, "sessions", []r.IndexSpec{r.TTLIndex()})
+func TTLIndex() IndexSpec {
+	return IndexSpec{Name: expiryField}
+}
+
+// ExpiresIn returns a time `d` after the server's current time, to store in
+// the expiry field, see SetExpiryField().
+//
+// Example usage:
+//
+//  err := r.Table("sessions").Insert(r.Map{"token": token, "expires_at": r.ExpiresIn(time.Hour)}).Run(session).Exec()
+func ExpiresIn(d time.Duration) Exp {
+	return NowOffset(d)
+}
+
+// Expired selects the rows of a table whose expiry time has passed, using the
+// index from TTLIndex().  Rows without an expiry time never expire, nor do
+// rows whose expiry field holds something other than a time.
+//
+// Example usage:
+//
+//  var count int
+//  err := r.Table("sessions").Expired().Count().Run(session).One(&count)
+func (e Exp) Expired() Exp {
+	return e.Between(expiryField, earliestTime, Now())
+}
+
+// ReapExpired deletes the expired rows of a table, a batch at a time so that
+// no single query runs for long, and returns how many were deleted.
+//
+// Example usage:
+//
+//  deleted, err := r.ReapExpired(session, "sessions")
+func ReapExpired(session *Session, table string) (int, error) {
+	return reapExpired(session, table, reapBatchSize)
+}
+
+func reapExpired(session *Session, table string, batchSize int) (int, error) {
+	deleted := 0
+	for {
+		var response WriteResponse
+		err := Table(table).Expired().Limit(batchSize).Delete().Run(session).One(&response)
+		if err == nil {
+			err = response.Err()
+		}
+		deleted += response.Deleted
+		if err != nil || response.Deleted < batchSize {
+			return deleted, err
+		}
+	}
+}
+
+// Reaper deletes the expired rows of a table in the background, see
+// NewReaper().
+type Reaper struct {
+	quit chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	deleted int
+	// the error from the last pass, if any
+	err error
+}
+
+// NewReaper starts a goroutine that calls ReapExpired() on `table` every
+// `interval` until .Stop() is called.  Errors do not stop it, the next pass
+// is made as usual, and the error from the last pass is returned by .Err().
+// The session is used from the reaper's goroutine, so it should not be used
+// for anything else until the reaper is stopped.
+//
+// Example usage:
+//
+//  reaperSession, err := r.Connect("localhost:28015", "test")
+//  reaper := r.NewReaper(reaperSession, "sessions", time.Minute)
+//  defer reaper.Stop()
+func NewReaper(session *Session, table string, interval time.Duration) *Reaper {
+	reaper := &Reaper{
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(reaper.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			deleted, err := ReapExpired(session, table)
+			reaper.mu.Lock()
+			reaper.deleted += deleted
+			reaper.err = err
+			reaper.mu.Unlock()
+
+			select {
+			case <-ticker.C:
+			case <-reaper.quit:
+				return
+			}
+		}
+	}()
+	return reaper
+}
+
+// Deleted returns how many rows the reaper has deleted so far.
+//
+// Example usage:
+//
+//  log.Printf("%v sessions expired", reaper.Deleted())
+func (reaper *Reaper) Deleted() int {
+	reaper.mu.Lock()
+	defer reaper.mu.Unlock()
+	return reaper.deleted
+}
+
+// Err returns the error from the reaper's last pass, or nil if it succeeded.
+//
+// Example usage:
+//
+//  if err := reaper.Err(); err != nil {
+//      log.Print(err)
+//  }
+func (reaper *Reaper) Err() error {
+	reaper.mu.Lock()
+	defer reaper.mu.Unlock()
+	return reaper.err
+}
+
+// Stop stops the reaper, waiting for a pass that is running to finish, and
+// returns the error from the last pass.  It must only be called once.
+//
+// Example usage:
+//
+//  err := reaper.Stop()
+func (reaper *Reaper) Stop() error {
+	close(reaper.quit)
+	<-reaper.done
+	return reaper.Err()
+}
@@ -2,6 +2,8 @@ package rethinkgo
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"reflect"
 	"strings"
 )
 
@@ -29,3 +31,51 @@ func prefixLines(s string, prefix string) (result string) {
 func protobufToString(p proto.Message, indentLevel int) string {
 	return prefixLines(proto.MarshalTextString(p), strings.Repeat("    ", indentLevel))
 }
+
+// constantNumber returns the value of a Go number, so that queries can be
+// simplified when it is known ahead of time.
+func constantNumber(o interface{}) (float64, bool) {
+	v := reflect.ValueOf(o)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// termString renders a term on a single line, for logging, e.g.
+// TABLE(DB("test"), "heroes").  Datums are shown as JSON.
+func termString(term *p.Term) string {
+	switch term.GetType() {
+	case p.Term_DATUM:
+		data, err := datumToJsonFormat(term.GetDatum(), pseudoTypeFormat{rawTime: true})
+		if err != nil {
+			return "?"
+		}
+		return string(data)
+	case p.Term_JSON:
+		// values marshaled by the driver are sent as a JSON string
+		if len(term.Args) == 1 && term.Args[0].GetDatum().GetType() == p.Datum_R_STR {
+			return term.Args[0].GetDatum().GetRStr()
+		}
+	case p.Term_VAR:
+		if len(term.Args) == 1 {
+			return "var" + termString(term.Args[0])
+		}
+	case p.Term_IMPLICIT_VAR:
+		return "row"
+	}
+
+	var args []string
+	for _, arg := range term.Args {
+		args = append(args, termString(arg))
+	}
+	for _, optarg := range term.Optargs {
+		args = append(args, optarg.GetKey()+"="+termString(optarg.Val))
+	}
+	return term.GetType().String() + "(" + strings.Join(args, ", ") + ")"
+}
@@ -0,0 +1,144 @@
+package rethinkgo
+
+// Keep track of which version of RethinkDB we are talking to, so that we can
+// refuse to send terms the server does not understand.
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	p "github.com/christopherhesse/rethinkgo/ql2"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// serverVersion is a RethinkDB release number, e.g. 1.7.1
+type serverVersion struct {
+	major, minor, patch int
+}
+
+// minServerVersion lists the terms that were added after 1.7.1, along with the
+// first server version that supports them.
+var minServerVersion = map[p.Term_TermType]serverVersion{
+	p.Term_LITERAL:          {1, 8, 0},
+	p.Term_EPOCH_TIME:       {1, 8, 0},
+	p.Term_NOW:              {1, 8, 0},
+	p.Term_IN_TIMEZONE:      {1, 8, 0},
+	p.Term_DATE:             {1, 8, 0},
+	p.Term_TIMEZONE:         {1, 8, 0},
+	p.Term_YEAR:             {1, 8, 0},
+	p.Term_MONTH:            {1, 8, 0},
+	p.Term_DAY:              {1, 8, 0},
+	p.Term_HOURS:            {1, 8, 0},
+	p.Term_MINUTES:          {1, 8, 0},
+	p.Term_TIME:             {1, 8, 0},
+	p.Term_ISO8601:          {1, 8, 0},
+	p.Term_TO_ISO8601:       {1, 8, 0},
+	p.Term_TO_EPOCH_TIME:    {1, 8, 0},
+	p.Term_DURING:           {1, 8, 0},
+	p.Term_TIME_OF_DAY:      {1, 8, 0},
+	p.Term_DAY_OF_WEEK:      {1, 8, 0},
+	p.Term_DAY_OF_YEAR:      {1, 8, 0},
+	p.Term_SECONDS:          {1, 8, 0},
+	p.Term_INDEX_STATUS:     {1, 12, 0},
+	p.Term_INDEX_WAIT:       {1, 12, 0},
+	p.Term_GROUP:            {1, 13, 0},
+	p.Term_UNGROUP:          {1, 13, 0},
+	p.Term_SUM:              {1, 13, 0},
+	p.Term_AVG:              {1, 13, 0},
+	p.Term_MIN:              {1, 13, 0},
+	p.Term_MAX:              {1, 13, 0},
+	p.Term_POINT:            {1, 15, 0},
+	p.Term_LINE:             {1, 15, 0},
+	p.Term_POLYGON:          {1, 15, 0},
+	p.Term_CIRCLE:           {1, 15, 0},
+	p.Term_DISTANCE:         {1, 15, 0},
+	p.Term_INTERSECTS:       {1, 15, 0},
+	p.Term_INCLUDES:         {1, 15, 0},
+	p.Term_GET_INTERSECTING: {1, 15, 0},
+	p.Term_GET_NEAREST:      {1, 15, 0},
+	p.Term_RANDOM:           {1, 15, 0},
+	p.Term_UUID:             {1, 15, 0},
+	p.Term_CHANGES:          {1, 16, 0},
+	p.Term_BINARY:           {2, 0, 0},
+}
+
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseServerVersion finds the version number in a version string such as
+// "rethinkdb 1.8.0-1 (GCC 4.6.3)".
+func parseServerVersion(s string) (v serverVersion, ok bool) {
+	match := versionPattern.FindStringSubmatch(s)
+	if match == nil {
+		return v, false
+	}
+	v.major, _ = strconv.Atoi(match[1])
+	v.minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		v.patch, _ = strconv.Atoi(match[3])
+	}
+	return v, true
+}
+
+// known is false for the zero version, which is used when we have not talked
+// to a server yet.
+func (v serverVersion) known() bool {
+	return v != serverVersion{}
+}
+
+func (v serverVersion) less(other serverVersion) bool {
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	if v.minor != other.minor {
+		return v.minor < other.minor
+	}
+	return v.patch < other.patch
+}
+
+func (v serverVersion) String() string {
+	return fmt.Sprintf("%v.%v.%v", v.major, v.minor, v.patch)
+}
+
+// supports is false if the version is known to be too old for a term.
+func (v serverVersion) supports(termType p.Term_TermType) bool {
+	required, ok := minServerVersion[termType]
+	return !ok || !v.known() || !v.less(required)
+}
+
+// checkServerVersion panics if the server is too old to support a term.
+func (ctx context) checkServerVersion(termType p.Term_TermType) {
+	if ctx.serverVersion.supports(termType) {
+		return
+	}
+	required := minServerVersion[termType]
+	panic(fmt.Sprintf("%v requires server >= %v, but the server is running %v", termType, required, ctx.serverVersion))
+}
+
+// probeServerVersion asks the server what version it is running.  Servers that
+// refuse the SERVER_INFO query, or do not say which version they run, are
+// left at the zero version, which is unknown, so that no terms are refused
+// for them, see Session.SetServerVersion().
+func (c *connection) probeServerVersion(token int64, timeout time.Duration) (serverVersion, error) {
+	queryProto := &p.Query{
+		Type:  p.Query_SERVER_INFO.Enum(),
+		Token: proto.Int64(token),
+	}
+	buffer, _, err := c.executeQuery(queryProto, timeout)
+	switch err.(type) {
+	case nil:
+	case ErrBrokenClient, ErrBadQuery, ErrRuntime:
+		return serverVersion{}, nil
+	default:
+		return serverVersion{}, err
+	}
+
+	var info struct {
+		Version string `json:"version"`
+	}
+	if len(buffer) == 1 {
+		datumUnmarshal(buffer[0], &info)
+	}
+	v, _ := parseServerVersion(info.Version)
+	return v, nil
+}
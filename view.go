@@ -0,0 +1,141 @@
+package rethinkgo
+
+// Keep an in-memory copy of the results of a query up to date.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Change is a single change to a row, as returned by the server for writes
+// with .ReturnValues() and by changefeeds.  OldValue is nil for inserted rows
+// and NewValue is nil for deleted rows.
+type Change struct {
+	OldValue map[string]interface{} `json:"old_val"`
+	NewValue map[string]interface{} `json:"new_val"`
+}
+
+// MaterializedView is an in-memory copy of the rows returned by a query,
+// keyed by primary key, which is kept up to date by applying changes to it.
+// It is safe to use from multiple goroutines.
+type MaterializedView struct {
+	mu         sync.RWMutex
+	primaryKey string
+	// keyed by viewKey() of the primary key
+	rows map[string]map[string]interface{}
+}
+
+// NewMaterializedView creates a view holding the rows returned by a query,
+// keyed by the `primaryKey` field of each row.
+//
+// Example usage:
+//
+//  view, err := r.NewMaterializedView(session, r.Table("heroes"), "id")
+//  hero, ok := view.Get(heroId)
+func NewMaterializedView(session *Session, query Exp, primaryKey string) (*MaterializedView, error) {
+	var rows []map[string]interface{}
+	if err := query.Run(session).All(&rows); err != nil {
+		return nil, err
+	}
+	view := &MaterializedView{primaryKey: primaryKey, rows: map[string]map[string]interface{}{}}
+	for _, row := range rows {
+		key, ok := row[primaryKey]
+		if !ok {
+			return nil, fmt.Errorf("rethinkdb: row has no %q field for the view: %v", primaryKey, row)
+		}
+		view.rows[viewKey(key)] = row
+	}
+	return view, nil
+}
+
+// viewKey encodes a primary key as JSON, so that keys that are arrays, which
+// cannot be map keys in Go, can be used, and keys that are numbers in Go match
+// the float64 that they are decoded as.
+func viewKey(key interface{}) string {
+	if number, ok := constantNumber(key); ok {
+		key = number
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Sprint(key)
+	}
+	return string(data)
+}
+
+// Get returns a copy of the row with the given primary key.
+func (v *MaterializedView) Get(key interface{}) (map[string]interface{}, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	row, ok := v.rows[viewKey(key)]
+	if !ok {
+		return nil, false
+	}
+	return copyDocument(row).(map[string]interface{}), true
+}
+
+// Len returns the number of rows in the view.
+func (v *MaterializedView) Len() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.rows)
+}
+
+// Snapshot returns a copy of the rows in the view, ordered by the JSON
+// encoding of their primary keys.  The rows can be changed without affecting
+// the view.
+func (v *MaterializedView) Snapshot() []map[string]interface{} {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	var keys []string
+	for key := range v.rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	snapshot := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		snapshot = append(snapshot, copyDocument(v.rows[key]).(map[string]interface{}))
+	}
+	return snapshot
+}
+
+// Apply updates the view with a change to one of its rows.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  err := r.Table("heroes").Get(heroId).Update(r.Map{"speed": 7}).ReturnValues().Run(session).One(&response)
+//  view.Apply(r.Change{OldValue: response.OldValue.(map[string]interface{}), NewValue: response.NewValue.(map[string]interface{})})
+func (v *MaterializedView) Apply(change Change) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if change.OldValue != nil {
+		delete(v.rows, viewKey(change.OldValue[v.primaryKey]))
+	}
+	if change.NewValue != nil {
+		v.rows[viewKey(change.NewValue[v.primaryKey])] = change.NewValue
+	}
+}
+
+// Follow applies each of the changes returned by a query to the view, until
+// the query runs out of rows or fails.  The rows are closed when it returns.
+// Use it with a changefeed to keep the view up to date.
+//
+// Example usage:
+//
+//  go func() {
+//      err := view.Follow(changefeed.Run(session))
+//      ...
+//  }()
+func (v *MaterializedView) Follow(rows *Rows) error {
+	defer rows.Close()
+	for rows.Next() {
+		var change Change
+		if err := rows.Scan(&change); err != nil {
+			return err
+		}
+		v.Apply(change)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,59 @@
+package rethinkgo
+
+// Options for .Update() and .Replace() given all at once, instead of as a
+// chain of methods after the write.
+
+// UpdateOpts holds the options for .UpdateWithOpts() and .ReplaceWithOpts().
+// Fields that are left unset use the server's defaults.
+type UpdateOpts struct {
+	// Allow the write to use non-deterministic functions, such as r.Js(), by
+	// not running it atomically, see .Atomic().
+	NonAtomic bool
+	// Durability of the write, either "hard" or "soft".
+	Durability string
+	// Return the old and new values of the row, for writes to a single row.
+	ReturnValues bool
+	// Return the old and new values of every row written, in
+	// WriteResponse.Changes, see .ReturnChanges().
+	ReturnChanges bool
+}
+
+// apply chains the options onto a write.
+func (opts UpdateOpts) apply(e Exp) Exp {
+	if opts.NonAtomic {
+		e = e.Atomic(false)
+	}
+	if opts.Durability != "" {
+		e = e.Durability(opts.Durability)
+	}
+	if opts.ReturnValues {
+		e = e.returnValues()
+	}
+	if opts.ReturnChanges {
+		e = e.ReturnChanges()
+	}
+	return e
+}
+
+// UpdateWithOpts is like .Update(), with the write's options given in a struct.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  opts := r.UpdateOpts{NonAtomic: true, Durability: "soft"}
+//  err := r.Table("heroes").UpdateWithOpts(r.Map{"strength": r.Js("Math.random()")}, opts).Run(session).One(&response)
+func (e Exp) UpdateWithOpts(mapping interface{}, opts UpdateOpts) Exp {
+	return opts.apply(e.Update(mapping))
+}
+
+// ReplaceWithOpts is like .Replace(), with the write's options given in a
+// struct.
+//
+// Example usage:
+//
+//  var response r.WriteResponse
+//  opts := r.UpdateOpts{ReturnValues: true}
+//  err := r.Table("heroes").Get(id).ReplaceWithOpts(replacement, opts).Run(session).One(&response)
+func (e Exp) ReplaceWithOpts(mapping interface{}, opts UpdateOpts) Exp {
+	return opts.apply(e.Replace(mapping))
+}